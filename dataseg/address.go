@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PathDomain is domain of PathValue, with the same numbers as Cadence.
+type PathDomain uint8
+
+const (
+	PathDomainStorage PathDomain = 1
+	PathDomainPrivate PathDomain = 2
+	PathDomainPublic  PathDomain = 3
+)
+
+func (d PathDomain) String() string {
+	switch d {
+	case PathDomainStorage:
+		return "storage"
+	case PathDomainPrivate:
+		return "private"
+	case PathDomainPublic:
+		return "public"
+	default:
+		return fmt.Sprintf("PathDomain(%d)", uint8(d))
+	}
+}
+
+type AddressSerializable struct {
+	v AddressValue
+}
+
+// Encode encodes AddressValue as
+//
+//	cbor.Tag{
+//			Number:  cborTagAddressValue,
+//			Content: []byte(address (8 bytes)),
+//	}
+//
+// Unlike Cadence, leading zero bytes of address aren't trimmed
+// so that all addresses have the same size.
+func (s *AddressSerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagAddressValue
+	buf[2] = cborMajorByteString | byte(len(s.v))
+	copy(buf[3:], s.v[:])
+
+	return buf, nil
+}
+
+func (s *AddressSerializable) Decode(b []byte) error {
+	if uint32(len(b)) < s.ByteSize() {
+		return errors.New("too short for AddressValue type")
+	}
+
+	if b[0] != 0xd8 || b[1] != cborTagAddressValue || b[2] != cborMajorByteString|byte(len(s.v)) {
+		return errors.New("not AddressValue type")
+	}
+
+	copy(s.v[:], b[3:])
+	return nil
+}
+
+func (s *AddressSerializable) ByteSize() uint32 {
+	// tag number (2 bytes) + byte string head (1 byte) + address (8 bytes)
+	return 11
+}
+
+func (s *AddressSerializable) IsConstantSized() bool { return true }
+
+func (s *AddressSerializable) GetValue() Value {
+	return s.v
+}
+
+type PathSerializable struct {
+	v PathValue
+}
+
+// Encode encodes PathValue as
+//
+//	cbor.Tag{
+//			Number:  cborTagPathValue,
+//			Content: []interface{}{domain, identifier},
+//	}
+func (s *PathSerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagPathValue
+	buf[2] = 0x80 | 2
+
+	index := 3
+	index += encodeCBORHead(buf[index:], 0, uint64(s.v.Domain))
+	index += encodeCBORHead(buf[index:], cborMajorTextString, uint64(len(s.v.Identifier)))
+	copy(buf[index:], s.v.Identifier)
+
+	return buf, nil
+}
+
+func (s *PathSerializable) Decode(b []byte) error {
+	if len(b) < 3 {
+		return errors.New("too short for PathValue type")
+	}
+
+	if b[0] != 0xd8 || b[1] != cborTagPathValue || b[2] != 0x80|2 {
+		return errors.New("not PathValue type")
+	}
+
+	domain, n, err := decodeCBORHead(b[3:], 0)
+	if err != nil {
+		return err
+	}
+	if n != 1 || domain < uint64(PathDomainStorage) || domain > uint64(PathDomainPublic) {
+		return fmt.Errorf("wrong domain %d for PathValue type", domain)
+	}
+
+	identifier, err := decodeCBORString(b[3+n:], cborMajorTextString)
+	if err != nil {
+		return err
+	}
+
+	s.v = PathValue{
+		Domain:     PathDomain(domain),
+		Identifier: string(identifier),
+	}
+	return nil
+}
+
+// ByteSize returns size of tag number (2 bytes), array head (1 byte),
+// domain (1 byte) and identifier.
+func (s *PathSerializable) ByteSize() uint32 {
+	return 4 + cborHeadSize(uint64(len(s.v.Identifier))) + uint32(len(s.v.Identifier))
+}
+
+func (s *PathSerializable) IsConstantSized() bool { return false }
+
+func (s *PathSerializable) GetValue() Value {
+	return s.v
+}
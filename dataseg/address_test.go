@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressPathEncodeDecode(t *testing.T) {
+
+	tests := []struct {
+		value   Value
+		encoded []byte
+	}{
+		{
+			AddressValue{0, 0, 0, 0, 0, 0, 0, 1},
+			[]byte{0xd8, cborTagAddressValue, 0x48, 0, 0, 0, 0, 0, 0, 0, 1},
+		},
+		{
+			PathValue{Domain: PathDomainStorage, Identifier: "vault"},
+			[]byte{0xd8, cborTagPathValue, 0x82, 0x01, 0x65, 'v', 'a', 'u', 'l', 't'},
+		},
+		{
+			PathValue{Domain: PathDomainPublic, Identifier: ""},
+			[]byte{0xd8, cborTagPathValue, 0x82, 0x03, 0x60},
+		},
+		{
+			CharacterValue("a"),
+			[]byte{0xd8, cborTagCharacterValue, 0x61, 'a'},
+		},
+		{
+			CharacterValue("é"),
+			[]byte{0xd8, cborTagCharacterValue, 0x62, 0xc3, 0xa9},
+		},
+	}
+
+	for _, test := range tests {
+		s := test.value.GetSerizable()
+
+		b, err := s.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, test.encoded, b)
+		assert.Equal(t, int(s.ByteSize()), len(b))
+
+		decoded, rest, err := decodeSerializable(append(b, 0xff))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xff}, rest)
+		assert.Equal(t, s.ByteSize(), decoded.ByteSize())
+		assert.Equal(t, test.value, decoded.GetValue())
+
+		_, _, err = decodeSerializable(b[:len(b)-1])
+		require.Error(t, err)
+	}
+
+	path := PathValue{Domain: PathDomainPrivate, Identifier: strings.Repeat("p", 100)}
+	b, err := path.GetSerizable().Encode()
+	require.NoError(t, err)
+	decoded, _, err := decodeSerializable(b)
+	require.NoError(t, err)
+	assert.Equal(t, path, decoded.GetValue())
+
+	// Unknown path domain
+	_, _, err = decodeSerializable([]byte{0xd8, cborTagPathValue, 0x82, 0x04, 0x60})
+	require.Error(t, err)
+
+	// Address must have 8 bytes
+	_, _, err = decodeSerializable([]byte{0xd8, cborTagAddressValue, 0x41, 1, 0, 0, 0, 0, 0, 0, 0})
+	require.Error(t, err)
+}
+
+func TestArrayAccountValues(t *testing.T) {
+
+	values := make([]Value, 300)
+	for i := 0; i < len(values); i++ {
+		switch i % 3 {
+		case 0:
+			values[i] = AddressValue{0, 0, 0, 0, 0, 0, 0, byte(i)}
+		case 1:
+			values[i] = PathValue{Domain: PathDomainPublic, Identifier: strings.Repeat("p", i%20)}
+		case 2:
+			values[i] = CharacterValue(string(rune('a' + i%26)))
+		}
+	}
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+	verifyArrayTree(t, array, values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+}
@@ -6,42 +6,162 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// arrayMetaSlabInternalFlag is set in the encoded slab count of an
+// ArrayMetaSlab whose children are ArrayMetaSlabs instead of ArraySlabs.
+const arrayMetaSlabInternalFlag = uint32(1) << 31
+
+// arrayMetaSlabNestedFlag is set in the encoded slab count of the root
+// ArrayMetaSlab of nested array, whose header is followed by StorageID
+// of parent array or composite.
+const arrayMetaSlabNestedFlag = uint32(1) << 30
+
+// arrayMetaSlabOverflowFlag is set in the slab count of ArrayMetaSlab
+// encoded with Encode if encoded data of its data slabs is preceded
+// by overflow slabs of their elements.
+const arrayMetaSlabOverflowFlag = uint32(1) << 29
+
+// arrayMetaSlabHeaderSize is the encoded size of meta slab header:
+// address (8 bytes) + index (8 bytes) + slab count (4 bytes).
+// Header of root slab of nested array is followed by StorageID of
+// parent array or composite: address (8 bytes) + index (8 bytes).
+const arrayMetaSlabHeaderSize = 20
+
+// arrayMetaSlabChildHeaderSize is the encoded size of each child slab
+// in meta slab: slab index (8 bytes) + slab size (4 bytes).
+// Child slabs have the same owner address as meta slab.
+const arrayMetaSlabChildHeaderSize = 12
+
+// arrayMetaSlabStoredChildHeaderSize is the size of each child slab
+// in meta slab encoded on its own: slab index (8 bytes) +
+// element count (4 bytes) + slab size (4 bytes).
+const arrayMetaSlabStoredChildHeaderSize = 16
+
+// ArraySlabHeader holds cached slab info. Meta slabs keep a copy of the
+// header of each child slab, which is updated when child slab is stored.
 type ArraySlabHeader struct {
 	id    StorageID
-	slab  *ArraySlab // remove this when switching to SlabStorage
-	count uint32     // number of elements in ArraySlab
-	size  uint32     // sum of all element size + array header size
+	count uint32 // number of elements in slab (or in all slabs under a meta slab)
+	size  uint32 // sum of all element size + array header size (or meta slab header size)
+}
+
+// ArrayNode is a node of the array tree, either a data slab (ArraySlab)
+// or a meta slab (ArrayMetaSlab) holding headers of its children.
+type ArrayNode interface {
+	Slab
+
+	Header() *ArraySlabHeader
+
+	// Elements are accessed through storage of array, which retrieves
+	// child slabs of meta slabs. Data slabs don't use it.
+	Get(storage SlabStorage, index uint32) (Serializable, error)
+	Set(storage SlabStorage, index uint32, v Serializable) error
+	Insert(storage SlabStorage, index uint32, v Serializable) error
+	Append(storage SlabStorage, v Serializable) error
+	Remove(storage SlabStorage, index uint32) error
 }
 
 // ArraySlab implements Slab interface
 type ArraySlab struct {
 	header   *ArraySlabHeader
 	elements []Serializable
+
+	// data is encoded slab data if slab is loaded lazily.
+	// It is decoded into elements on first access, which is safe for
+	// concurrent readers, and discarded when slab is modified.
+	data     []byte
+	loadOnce sync.Once
+	loadErr  error
 }
 
+// ArrayMetaSlab implements Slab interface.
+// Children of ArrayMetaSlab are either all ArraySlabs or all ArrayMetaSlabs
+// (internal is set). Child slabs are stored in and retrieved from storage
+// by StorageID. Storage is passed by ArrayValue accessing the slab, because
+// slabs are shared by all ArrayValues loaded from the same storage.
 type ArrayMetaSlab struct {
-	id             StorageID
+	header         *ArraySlabHeader
 	orderedHeaders list.List
-	v              *ArrayValue
+	internal       bool
+
+	// parent is StorageID of array or composite holding this array as
+	// element or field referenced by StorageID, if this is root slab
+	// of nested array.
+	parent StorageID
+
+	// children and cumulativeCounts are rebuilt by updateHeader.
+	// cumulativeCounts[i] is the number of elements in children[0..i],
+	// so child slab containing an index can be found by binary search.
+	children         []*list.Element
+	cumulativeCounts []uint32
 }
 
-func (a *ArraySlab) Get(index uint32) (Serializable, error) {
+// newLazyArraySlab returns data slab with encoded data,
+// whose elements are decoded on first access.
+func newLazyArraySlab(header *ArraySlabHeader, data []byte) (*ArraySlab, error) {
+	if len(data) < 5 || data[0] != 0x80|byte(26) {
+		return nil, errors.New("wrong data for array slab")
+	}
+
+	// Element count is read from array head without decoding elements
+	header.size = uint32(len(data))
+	header.count = binary.BigEndian.Uint32(data[1:])
+
+	return &ArraySlab{header: header, data: data}, nil
+}
+
+func (a *ArraySlab) Header() *ArraySlabHeader {
+	return a.header
+}
+
+// load decodes elements from encoded data if slab was loaded lazily.
+func (a *ArraySlab) load() error {
+	a.loadOnce.Do(func() {
+		if a.data != nil {
+			a.loadErr = a.Decode(a.data)
+		}
+	})
+	return a.loadErr
+}
+
+// loadForUpdate loads slab which is about to be modified,
+// and discards encoded data which won't match modified elements.
+func (a *ArraySlab) loadForUpdate() error {
+	if err := a.load(); err != nil {
+		return err
+	}
+	a.data = nil
+	return nil
+}
+
+func (a *ArraySlab) Get(storage SlabStorage, index uint32) (Serializable, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
 	if int(index) >= len(a.elements) {
 		return nil, fmt.Errorf("out of bounds")
 	}
 	return a.elements[index], nil
 }
 
-func (a *ArraySlab) Append(v Serializable) error {
+func (a *ArraySlab) Append(storage SlabStorage, v Serializable) error {
+	if err := a.loadForUpdate(); err != nil {
+		return err
+	}
 	a.elements = append(a.elements, v)
 	a.header.size += v.ByteSize()
 	a.header.count++
 	return nil
 }
 
-func (a *ArraySlab) Remove(index uint32) error {
+func (a *ArraySlab) Remove(storage SlabStorage, index uint32) error {
+	if err := a.loadForUpdate(); err != nil {
+		return err
+	}
 	if int(index) >= len(a.elements) {
 		return fmt.Errorf("out of bounds")
 	}
@@ -58,7 +178,10 @@ func (a *ArraySlab) Remove(index uint32) error {
 	return nil
 }
 
-func (a *ArraySlab) Insert(index uint32, v Serializable) error {
+func (a *ArraySlab) Insert(storage SlabStorage, index uint32, v Serializable) error {
+	if err := a.loadForUpdate(); err != nil {
+		return err
+	}
 	if index >= uint32(len(a.elements)) {
 		return fmt.Errorf("out of bounds")
 	}
@@ -74,7 +197,10 @@ func (a *ArraySlab) Insert(index uint32, v Serializable) error {
 	return nil
 }
 
-func (a *ArraySlab) Set(index uint32, v Serializable) error {
+func (a *ArraySlab) Set(storage SlabStorage, index uint32, v Serializable) error {
+	if err := a.loadForUpdate(); err != nil {
+		return err
+	}
 	if index >= uint32(len(a.elements)) {
 		return fmt.Errorf("out of bounds")
 	}
@@ -94,7 +220,10 @@ func (a *ArraySlab) headerSize() uint32 {
 	return 5
 }
 
-func (a *ArraySlab) Split() (*ArraySlab, error) {
+func (a *ArraySlab) Split(storage SlabStorage) (Segmentable, error) {
+	if err := a.loadForUpdate(); err != nil {
+		return nil, err
+	}
 
 	if len(a.elements) == 1 {
 		// Can't split array with one element
@@ -109,29 +238,42 @@ func (a *ArraySlab) Split() (*ArraySlab, error) {
 	newSlabStartIndex := 0
 	slab1Size := a.headerSize()
 	for i, v := range a.elements {
-		slab1Size += v.ByteSize()
-		if slab1Size > uint32(breakPoint) {
-			newSlabStartIndex = i + 1
+		if slab1Size+v.ByteSize() > uint32(breakPoint) {
+			// Element i crosses the break point. Keep it in the first slab
+			// unless moving it to the new slab gives a more even split,
+			// which matters when elements have different sizes.
+			withSize := maxUint32(slab1Size+v.ByteSize(), size-slab1Size-v.ByteSize()+a.headerSize())
+			withoutSize := maxUint32(slab1Size, size-slab1Size+a.headerSize())
+			if i == len(a.elements)-1 || (i > 0 && withoutSize < withSize) {
+				newSlabStartIndex = i
+			} else {
+				newSlabStartIndex = i + 1
+				slab1Size += v.ByteSize()
+			}
 			break
 		}
+		slab1Size += v.ByteSize()
 	}
 
-	if newSlabStartIndex == len(a.elements) {
-		// Split last element from the rest of elements
-		newSlabStartIndex = len(a.elements) - 1
-		slab1Size = a.header.size - a.elements[len(a.elements)-1].ByteSize()
+	id, err := storage.GenerateStorageID(a.header.id.Address)
+	if err != nil {
+		return nil, err
 	}
 
 	newSlabHeader := &ArraySlabHeader{
-		id:    generateStorageID(),
+		id:    id,
 		count: a.header.count - uint32(newSlabStartIndex),
 		size:  a.header.size - slab1Size + a.headerSize(),
 	}
+
+	// Copy elements so both slabs don't share the same underlying array
+	elements := make([]Serializable, len(a.elements)-newSlabStartIndex)
+	copy(elements, a.elements[newSlabStartIndex:])
+
 	newSlab := &ArraySlab{
 		header:   newSlabHeader,
-		elements: a.elements[newSlabStartIndex:],
+		elements: elements,
 	}
-	newSlabHeader.slab = newSlab
 
 	a.elements = a.elements[:newSlabStartIndex]
 	a.header.size = slab1Size
@@ -140,18 +282,42 @@ func (a *ArraySlab) Split() (*ArraySlab, error) {
 	return newSlab, nil
 }
 
-func (a *ArraySlab) Merge(slab2 *ArraySlab) error {
+func (a *ArraySlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*ArraySlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into array slab", s)
+	}
+	if err := a.loadForUpdate(); err != nil {
+		return err
+	}
+	if err := slab2.load(); err != nil {
+		return err
+	}
 	a.elements = append(a.elements, slab2.elements...)
-	a.header.size += slab2.header.size
+	a.header.size += slab2.header.size - a.headerSize()
 	a.header.count += slab2.header.count
 	return nil
 }
 
+func (a *ArraySlab) Clone() Slab {
+	header := *a.header
+	return &ArraySlab{
+		header:   &header,
+		elements: append([]Serializable(nil), a.elements...),
+		data:     a.data,
+	}
+}
+
 func (a *ArraySlab) ID() StorageID {
 	return a.header.id
 }
 
 func (a *ArraySlab) Encode() ([]byte, error) {
+	// Reuse encoded data if slab isn't loaded
+	if a.data != nil {
+		return a.data, nil
+	}
+
 	buf := make([]byte, a.ByteSize())
 
 	// Array head
@@ -202,134 +368,464 @@ func (a *ArraySlab) ByteSize() uint32 {
 	return a.header.size // Array head size + element size cached in slab header
 }
 
+func (a *ArraySlab) IsConstantSized() bool { return false }
+
+// GetValue returns nil because data slab holds only part of elements
+// of array. ArrayValue is returned by GetValue of Serializable returned
+// by its GetSerizable.
+func (a *ArraySlab) GetValue() Value {
+	return nil
+}
+
+func newArrayMetaSlab(storage SlabStorage, address Address) (*ArrayMetaSlab, error) {
+	id, err := storage.GenerateStorageID(address)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &ArrayMetaSlab{header: &ArraySlabHeader{id: id}}
+	meta.header.size = meta.headerSize()
+	return meta, nil
+}
+
+// GetValue returns nil because meta slab is shared by ArrayValues loaded
+// from storage. ArrayValue is returned by GetValue of Serializable returned
+// by its GetSerizable.
 func (a *ArrayMetaSlab) GetValue() Value {
-	return a.v
+	return nil
 }
 
 func (a *ArrayMetaSlab) IsConstantSized() bool { return false }
 
 func (a *ArrayMetaSlab) ID() StorageID {
-	return a.id
+	return a.header.id
 }
 
-// TODO: count can be cached
-func (a *ArrayMetaSlab) GetCount() uint32 {
+func (a *ArrayMetaSlab) Header() *ArraySlabHeader {
+	return a.header
+}
+
+func (a *ArrayMetaSlab) headerSize() uint32 {
+	if a.isNested() {
+		return arrayMetaSlabHeaderSize + 16
+	}
+	return arrayMetaSlabHeaderSize
+}
+
+// isNested returns true if this is root slab of array nested in parent
+// array or composite.
+func (a *ArrayMetaSlab) isNested() bool {
+	return a.parent != StorageID{}
+}
+
+// setParent sets parent array or composite of nested array whose root slab this is,
+// and stores root slab, which is split if it exceeds maxThreshold.
+func (a *ArrayMetaSlab) setParent(storage SlabStorage, parent StorageID) error {
+	a.parent = parent
+	a.updateHeader()
+	return a.updateRoot(storage)
+}
+
+// hasMetaChildren returns true if children of this meta slab are meta slabs.
+func (a *ArrayMetaSlab) hasMetaChildren() bool {
+	return a.internal
+}
+
+func (a *ArrayMetaSlab) Clone() Slab {
+	header := *a.header
+	meta := &ArrayMetaSlab{
+		header:   &header,
+		internal: a.internal,
+		parent:   a.parent,
+	}
+	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
+		h := *e.Value.(*ArraySlabHeader)
+		meta.orderedHeaders.PushBack(&h)
+	}
+	meta.updateHeader()
+	return meta
+}
+
+// getSlab retrieves child slab with given header from storage.
+func (a *ArrayMetaSlab) getSlab(storage SlabStorage, header *ArraySlabHeader) (ArrayNode, error) {
+	slab, found, err := storage.Retrieve(header.id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("array slab %s not found", header.id)
+	}
+	node, ok := slab.(ArrayNode)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not array slab", header.id, slab)
+	}
+	return node, nil
+}
+
+// getSlabForUpdate retrieves child slab which is about to be modified,
+// so that snapshots can preserve its current version.
+func (a *ArrayMetaSlab) getSlabForUpdate(storage SlabStorage, header *ArraySlabHeader) (ArrayNode, error) {
+	slab, err := a.getSlab(storage, header)
+	if err != nil {
+		return nil, err
+	}
+	if tracked, ok := storage.(*trackedSlabStorage); ok {
+		tracked.beforeUpdate(slab)
+	}
+	return slab, nil
+}
+
+// storeSlab stores modified child slab and updates its header at headerElement.
+func (a *ArrayMetaSlab) storeSlab(storage SlabStorage, headerElement *list.Element, slab ArrayNode) {
+	header := *slab.Header()
+	headerElement.Value = &header
+	storage.Store(slab)
+}
+
+// updateHeader recomputes element count, meta slab size and cumulative
+// counts from child headers. It must be called whenever children are modified.
+func (a *ArrayMetaSlab) updateHeader() {
+	a.children = a.children[:0]
+	a.cumulativeCounts = a.cumulativeCounts[:0]
+
 	count := uint32(0)
 	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
 		header := e.Value.(*ArraySlabHeader)
 		count += header.count
+		a.children = append(a.children, e)
+		a.cumulativeCounts = append(a.cumulativeCounts, count)
 	}
-	return count
+	a.header.count = count
+	a.header.size = a.headerSize() + uint32(a.orderedHeaders.Len())*arrayMetaSlabChildHeaderSize
+}
+
+func (a *ArrayMetaSlab) GetCount() uint32 {
+	return a.header.count
 }
 
+// Encode encodes meta slab on its own (see encodeSlab), because child
+// slabs are retrieved from storage passed to encode.
 func (a *ArrayMetaSlab) Encode() ([]byte, error) {
-	headerSize := 8 + a.orderedHeaders.Len()*8
+	return a.encodeSlab()
+}
 
-	buf := make([]byte, headerSize)
+// encode encodes meta slab header followed by encoded data of all child slabs.
+// If elements of data slabs are stored in overflow slabs, encoded data of
+// each data slab is preceded by its overflow slabs (see encodeOverflowSlabs).
+// Slab size of each child is the length of child's encoded data.
+func (a *ArrayMetaSlab) encode(storage SlabStorage) ([]byte, error) {
+	headerSize := int(a.headerSize()) + a.orderedHeaders.Len()*arrayMetaSlabChildHeaderSize
+
+	// Encode child slabs first so encoded size is known for meta child slabs
+	children := make([][]byte, 0, a.orderedHeaders.Len())
+	overflows := make([][]byte, 0, a.orderedHeaders.Len())
+	hasOverflow := false
+	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
+		slab, err := a.getSlab(storage, e.Value.(*ArraySlabHeader))
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		if meta, ok := slab.(*ArrayMetaSlab); ok {
+			b, err = meta.encode(storage)
+		} else {
+			b, err = slab.Encode()
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, b)
 
-	// Write metaslab id (4 bytes)
-	binary.BigEndian.PutUint32(buf, uint32(a.id))
+		if dataSlab, ok := slab.(*ArraySlab); ok {
+			overflow, count, err := encodeOverflowSlabs(storage, dataSlab)
+			if err != nil {
+				return nil, err
+			}
+			overflows = append(overflows, overflow)
+			hasOverflow = hasOverflow || count > 0
+		}
+	}
 
-	// Write number of slabs (4 bytes)
-	binary.BigEndian.PutUint32(buf[4:], uint32(a.orderedHeaders.Len()))
+	if hasOverflow {
+		for i, overflow := range overflows {
+			children[i] = append(overflow, children[i]...)
+		}
+	}
+
+	buf := make([]byte, headerSize)
+	a.encodeHeader(buf)
+
+	if hasOverflow {
+		slabCount := binary.BigEndian.Uint32(buf[16:])
+		binary.BigEndian.PutUint32(buf[16:], slabCount|arrayMetaSlabOverflowFlag)
+	}
 
-	// For each slab, write slab id (4 bytes) and slab size (4 bytes)
+	// For each slab, write slab index (8 bytes) and slab size (4 bytes)
+	offset := int(a.headerSize())
 	i := 0
 	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
 		header := e.Value.(*ArraySlabHeader)
-		binary.BigEndian.PutUint32(buf[8+i*8:], uint32(header.id))
-		binary.BigEndian.PutUint32(buf[8+i*8+4:], uint32(header.size))
+		binary.BigEndian.PutUint64(buf[offset:], header.id.Index)
+		binary.BigEndian.PutUint32(buf[offset+8:], uint32(len(children[i])))
+		offset += arrayMetaSlabChildHeaderSize
 		i++
 	}
 
-	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
-		header := e.Value.(*ArraySlabHeader)
-		b, err := header.slab.Encode()
-		if err != nil {
-			return nil, err
-		}
+	for _, b := range children {
 		buf = append(buf, b...)
 	}
 
 	return buf, nil
 }
 
+// encodeHeader writes meta slab address (8 bytes), index (8 bytes) and
+// number of child slabs with flags (4 bytes) to buf, followed by parent
+// address (8 bytes) and index (8 bytes) if array is nested.
+func (a *ArrayMetaSlab) encodeHeader(buf []byte) {
+	copy(buf, a.header.id.Address[:])
+	binary.BigEndian.PutUint64(buf[8:], a.header.id.Index)
+
+	slabCount := uint32(a.orderedHeaders.Len())
+	if a.internal {
+		slabCount |= arrayMetaSlabInternalFlag
+	}
+	if a.isNested() {
+		slabCount |= arrayMetaSlabNestedFlag
+	}
+	binary.BigEndian.PutUint32(buf[16:], slabCount)
+
+	if a.isNested() {
+		copy(buf[20:], a.parent.Address[:])
+		binary.BigEndian.PutUint64(buf[28:], a.parent.Index)
+	}
+}
+
+// decodeHeader decodes meta slab header written by encodeHeader,
+// and returns number of child slabs.
+func (a *ArrayMetaSlab) decodeHeader(data []byte) (uint32, error) {
+	if len(data) < int(a.headerSize()) {
+		return 0, errors.New("too short for array meta slab")
+	}
+
+	var address Address
+	copy(address[:], data)
+	a.header.id = NewStorageID(address, binary.BigEndian.Uint64(data[8:]))
+
+	slabCount := binary.BigEndian.Uint32(data[16:])
+	a.internal = slabCount&arrayMetaSlabInternalFlag != 0
+	nested := slabCount&arrayMetaSlabNestedFlag != 0
+	slabCount &^= arrayMetaSlabInternalFlag | arrayMetaSlabNestedFlag | arrayMetaSlabOverflowFlag
+
+	if nested {
+		if len(data) < arrayMetaSlabHeaderSize+16 {
+			return 0, errors.New("too short for array meta slab")
+		}
+		copy(a.parent.Address[:], data[20:])
+		a.parent.Index = binary.BigEndian.Uint64(data[28:])
+		if !a.isNested() {
+			return 0, errors.New("nested array meta slab without parent")
+		}
+	}
+
+	return slabCount, nil
+}
+
+// Decode decodes meta slab encoded on its own (see decodeSlab).
 func (a *ArrayMetaSlab) Decode(data []byte) error {
-	if len(data) < 8 {
-		return errors.New("too short for array meta slab")
+	return a.decodeSlab(data)
+}
+
+// decode decodes meta slab and all its child slabs encoded by encode,
+// and stores decoded child slabs and overflow slabs in storage.
+// If lazy is true, data slabs keep their encoded data and
+// their elements are decoded on first access.
+func (a *ArrayMetaSlab) decode(storage SlabStorage, data []byte, lazy bool) error {
+	slabCount, err := a.decodeHeader(data)
+	if err != nil {
+		return err
 	}
+	address := a.header.id.Address
+	hasOverflow := binary.BigEndian.Uint32(data[16:])&arrayMetaSlabOverflowFlag != 0
 
-	a.id = StorageID(binary.BigEndian.Uint32(data[:4]))
+	if len(data) < int(a.headerSize())+int(slabCount)*arrayMetaSlabChildHeaderSize {
+		return errors.New("too short for array meta slab")
+	}
 
-	slabCount := binary.BigEndian.Uint32(data[4:])
-	if slabCount == 0 {
-		return nil
+	type childSlabData struct {
+		index uint64
+		size  uint32
 	}
 
-	slabData := make([][2]uint32, slabCount)
+	slabData := make([]childSlabData, slabCount)
 
-	index := 8
+	index := int(a.headerSize())
 	for i := 0; i < int(slabCount); i++ {
-		id := binary.BigEndian.Uint32(data[index:])
-		size := binary.BigEndian.Uint32(data[index+4:])
-		index += 8
-		slabData[i] = [2]uint32{id, size}
+		slabData[i].index = binary.BigEndian.Uint64(data[index:])
+		slabData[i].size = binary.BigEndian.Uint32(data[index+8:])
+		index += arrayMetaSlabChildHeaderSize
 	}
 
 	for _, sd := range slabData {
-		slab := &ArraySlab{
-			header: &ArraySlabHeader{
-				id: StorageID(sd[0]),
-			},
+		if len(data) < index+int(sd.size) {
+			return errors.New("too short for array meta slab")
 		}
 
-		err := slab.Decode(data[index : index+int(sd[1])])
-		if err != nil {
-			return err
+		header := &ArraySlabHeader{id: NewStorageID(address, sd.index)}
+		childData := data[index : index+int(sd.size)]
+
+		if hasOverflow {
+			childData, err = decodeOverflowSlabs(storage, address, childData)
+			if err != nil {
+				return err
+			}
 		}
 
-		index = index + int(sd[1])
+		if a.internal {
+			meta := &ArrayMetaSlab{header: header}
 
-		slab.header.slab = slab
-		slab.header.size = sd[1]
-		slab.header.count = uint32(len(slab.elements))
+			err := meta.decode(storage, childData, lazy)
+			if err != nil {
+				return err
+			}
+
+			storage.Store(meta)
+		} else if lazy {
+			slab, err := newLazyArraySlab(header, childData)
+			if err != nil {
+				return err
+			}
+
+			storage.Store(slab)
+		} else {
+			slab := &ArraySlab{header: header}
+
+			err := slab.Decode(childData)
+			if err != nil {
+				return err
+			}
 
-		a.orderedHeaders.PushBack(slab.header)
+			header.size = uint32(len(childData))
+			header.count = uint32(len(slab.elements))
+
+			storage.Store(slab)
+		}
+
+		index = index + int(sd.size)
+
+		h := *header
+		a.orderedHeaders.PushBack(&h)
 	}
 
+	a.updateHeader()
+
 	return nil
 }
 
+// ByteSize returns encoded size of meta slab header and child headers,
+// not including child slabs.
 func (a *ArrayMetaSlab) ByteSize() uint32 {
-	var size uint32
-	size = uint32(8) + uint32(a.orderedHeaders.Len())*8
+	return a.header.size
+}
+
+// encodeSlab encodes meta slab header followed by the header of each child
+// slab, without child slabs. It is used to store meta slab on its own.
+func (a *ArrayMetaSlab) encodeSlab() ([]byte, error) {
+	buf := make([]byte, int(a.headerSize())+a.orderedHeaders.Len()*arrayMetaSlabStoredChildHeaderSize)
+	a.encodeHeader(buf)
+
+	// For each slab, write slab index (8 bytes), element count (4 bytes)
+	// and slab size (4 bytes)
+	offset := int(a.headerSize())
 	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
 		header := e.Value.(*ArraySlabHeader)
-		size += header.size
+		binary.BigEndian.PutUint64(buf[offset:], header.id.Index)
+		binary.BigEndian.PutUint32(buf[offset+8:], header.count)
+		binary.BigEndian.PutUint32(buf[offset+12:], header.size)
+		offset += arrayMetaSlabStoredChildHeaderSize
 	}
-	return size
+
+	return buf, nil
 }
 
-func (a *ArrayMetaSlab) Get(index uint32) (Serializable, error) {
-	if a.orderedHeaders.Len() == 0 {
-		return nil, fmt.Errorf("out of bounds")
+// decodeSlab decodes meta slab encoded by encodeSlab.
+// Child slabs are retrieved from storage on access.
+func (a *ArrayMetaSlab) decodeSlab(data []byte) error {
+	slabCount, err := a.decodeHeader(data)
+	if err != nil {
+		return err
+	}
+	address := a.header.id.Address
+
+	if len(data) != int(a.headerSize())+int(slabCount)*arrayMetaSlabStoredChildHeaderSize {
+		return errors.New("wrong byte size for array meta slab")
+	}
+
+	offset := int(a.headerSize())
+	for i := 0; i < int(slabCount); i++ {
+		a.orderedHeaders.PushBack(&ArraySlabHeader{
+			id:    NewStorageID(address, binary.BigEndian.Uint64(data[offset:])),
+			count: binary.BigEndian.Uint32(data[offset+8:]),
+			size:  binary.BigEndian.Uint32(data[offset+12:]),
+		})
+		offset += arrayMetaSlabStoredChildHeaderSize
+	}
+
+	a.updateHeader()
+
+	return nil
+}
+
+// childAt returns list element of child slab containing element at index,
+// and element index within that child slab.
+func (a *ArrayMetaSlab) childAt(index uint32) (*list.Element, uint32, error) {
+	// Find first child with cumulative count greater than index
+	i := sort.Search(len(a.cumulativeCounts), func(i int) bool {
+		return a.cumulativeCounts[i] > index
+	})
+	if i == len(a.cumulativeCounts) {
+		return nil, 0, fmt.Errorf("out of bounds")
 	}
 
 	startIndex := uint32(0)
-	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
-		h := e.Value.(*ArraySlabHeader)
-		if index >= startIndex && index < startIndex+uint32(h.count) {
-			return h.slab.Get(index - startIndex)
-		}
-		startIndex += uint32(h.count)
+	if i > 0 {
+		startIndex = a.cumulativeCounts[i-1]
+	}
+	return a.children[i], index - startIndex, nil
+}
+
+func (a *ArrayMetaSlab) Get(storage SlabStorage, index uint32) (Serializable, error) {
+	e, childIndex, err := a.childAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	slab, err := a.getSlab(storage, e.Value.(*ArraySlabHeader))
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("out of bounds")
+	return slab.Get(storage, childIndex)
 }
 
-func (a *ArrayMetaSlab) Append(v Serializable) error {
+func (a *ArrayMetaSlab) Append(storage SlabStorage, v Serializable) error {
 	lastHeader := a.orderedHeaders.Back()
 
+	if a.internal {
+		lastSlab, err := a.getSlabForUpdate(storage, lastHeader.Value.(*ArraySlabHeader))
+		if err != nil {
+			return err
+		}
+
+		err = lastSlab.Append(storage, v)
+		if err != nil {
+			return err
+		}
+
+		a.storeSlab(storage, lastHeader, lastSlab)
+
+		return a.rebalance(storage, lastHeader)
+	}
+
 	// Create new slab if
 	// - there isn't any slab, or
 	// - last slab size will exceed maxThreshold with new element
@@ -337,177 +833,316 @@ func (a *ArrayMetaSlab) Append(v Serializable) error {
 	if lastHeader == nil ||
 		lastHeader.Value.(*ArraySlabHeader).size+v.ByteSize() > maxThreshold {
 
-		header := &ArraySlabHeader{id: generateStorageID()}
+		id, err := storage.GenerateStorageID(a.header.id.Address)
+		if err != nil {
+			return err
+		}
+
+		header := &ArraySlabHeader{id: id}
 
 		slab := &ArraySlab{header: header}
 
-		header.slab = slab
 		header.size = slab.headerSize()
 
-		a.orderedHeaders.PushBack(slab.header)
+		err = slab.Append(storage, v)
+		if err != nil {
+			return err
+		}
+
+		a.storeSlab(storage, a.orderedHeaders.PushBack(nil), slab)
+
+		a.updateHeader()
+		return nil
+	}
+
+	lastSlab, err := a.getSlabForUpdate(storage, lastHeader.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
+	}
 
-		return slab.Append(v)
+	err = lastSlab.Append(storage, v)
+	if err != nil {
+		return err
 	}
 
-	lastSlab := lastHeader.Value.(*ArraySlabHeader).slab
-	return lastSlab.Append(v)
+	a.storeSlab(storage, lastHeader, lastSlab)
+
+	a.updateHeader()
+	return nil
 }
 
-func (a *ArrayMetaSlab) Remove(index uint32) error {
-	slabIndex := uint32(0)
-	startIndex := uint32(0)
-	var headerElement *list.Element
+func (a *ArrayMetaSlab) Remove(storage SlabStorage, index uint32) error {
+	e, childIndex, err := a.childAt(index)
+	if err != nil {
+		return err
+	}
 
-	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
-		h := e.Value.(*ArraySlabHeader)
-		if index >= startIndex && index < startIndex+uint32(h.count) {
-			headerElement = e
-			slabIndex = index - startIndex
-			break
-		}
-		startIndex += uint32(h.count)
+	slab, err := a.getSlabForUpdate(storage, e.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
 	}
 
-	if headerElement == nil {
-		return fmt.Errorf("out of bounds")
+	err = slab.Remove(storage, childIndex)
+	if err != nil {
+		return err
+	}
+
+	a.storeSlab(storage, e, slab)
+
+	return a.rebalance(storage, e)
+}
+
+func (a *ArrayMetaSlab) Insert(storage SlabStorage, index uint32, v Serializable) error {
+	if index == a.header.count {
+		return a.Append(storage, v)
 	}
 
-	slab := headerElement.Value.(*ArraySlabHeader).slab
-	err := slab.Remove(slabIndex)
+	e, childIndex, err := a.childAt(index)
 	if err != nil {
 		return err
 	}
 
-	if slab.header.size < minThreshold {
-		return a.merge(headerElement)
+	slab, err := a.getSlabForUpdate(storage, e.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
 	}
 
+	err = slab.Insert(storage, childIndex, v)
+	if err != nil {
+		return err
+	}
+
+	a.storeSlab(storage, e, slab)
+
+	return a.rebalance(storage, e)
+}
+
+func (a *ArrayMetaSlab) Set(storage SlabStorage, index uint32, v Serializable) error {
+	e, childIndex, err := a.childAt(index)
+	if err != nil {
+		return err
+	}
+
+	slab, err := a.getSlabForUpdate(storage, e.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
+	}
+
+	err = slab.Set(storage, childIndex, v)
+	if err != nil {
+		return err
+	}
+
+	a.storeSlab(storage, e, slab)
+
+	return a.rebalance(storage, e)
+}
+
+// isUnderflow returns true if child slab should be merged with a sibling.
+// Meta slab with only one child is merged so its child can be merged with siblings.
+func (a *ArrayMetaSlab) isUnderflow(header *ArraySlabHeader) bool {
+	if a.internal {
+		return header.size < minThreshold || header.size < arrayMetaSlabHeaderSize+2*arrayMetaSlabChildHeaderSize
+	}
+	return header.size < minThreshold
+}
+
+// rebalance splits or merges modified child slab if its size
+// exceeds maxThreshold or falls below minThreshold.
+func (a *ArrayMetaSlab) rebalance(storage SlabStorage, headerElement *list.Element) error {
+	header := headerElement.Value.(*ArraySlabHeader)
+
+	var err error
+	if header.size > maxThreshold {
+		err = a.split(storage, headerElement)
+	} else if a.isUnderflow(header) {
+		err = a.merge(storage, headerElement)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.updateHeader()
 	return nil
 }
 
-func (a *ArrayMetaSlab) Insert(index uint32, v Serializable) error {
-	startIndex := uint32(0)
-	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
-		h := e.Value.(*ArraySlabHeader)
-		if index >= startIndex && index < startIndex+uint32(h.count) {
-			err := h.slab.Insert(index-startIndex, v)
-			if err != nil {
-				return err
-			}
-			if h.size > uint32(maxThreshold) {
-				a.split(e)
-			}
-			return nil
-		}
-		startIndex += uint32(h.count)
+// updateRoot adjusts tree height after root slab is modified, and stores
+// root slab. Other meta slabs are stored by their parent after they're
+// modified.
+func (a *ArrayMetaSlab) updateRoot(storage SlabStorage) error {
+	var err error
+	if a.header.size > maxThreshold {
+		err = a.splitRoot(storage)
+	} else if a.orderedHeaders.Len() == 1 && a.internal && a.canCollapse() {
+		err = a.collapseRoot(storage)
+	}
+	if err != nil {
+		return err
 	}
+
+	storage.Store(a)
 	return nil
 }
 
-func (a *ArrayMetaSlab) Set(index uint32, v Serializable) error {
-	startIndex := uint32(0)
-	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
-		h := e.Value.(*ArraySlabHeader)
-		if index >= startIndex && index < startIndex+uint32(h.count) {
-			err := h.slab.Set(index-startIndex, v)
-			if err != nil {
-				return err
-			}
-			if h.size > uint32(maxThreshold) {
-				a.split(e)
-			} else if h.size < minThreshold {
-				a.merge(e)
-			}
-			return nil
-		}
-		startIndex += uint32(h.count)
+// splitRoot moves all children of root to a new meta slab and splits it,
+// increasing tree height by one. Root keeps its StorageID.
+func (a *ArrayMetaSlab) splitRoot(storage SlabStorage) error {
+	child, err := newArrayMetaSlab(storage, a.header.id.Address)
+	if err != nil {
+		return err
 	}
+	child.internal = a.internal
+	child.orderedHeaders.PushBackList(&a.orderedHeaders)
+	child.updateHeader()
+
+	a.internal = true
+	a.orderedHeaders.Init()
+	a.storeSlab(storage, a.orderedHeaders.PushBack(nil), child)
+
+	err = a.split(storage, a.orderedHeaders.Front())
+	if err != nil {
+		return err
+	}
+
+	a.updateHeader()
 	return nil
 }
 
-func (a *ArrayMetaSlab) merge(headerElement *list.Element) error {
+// canCollapse returns true if children of root's only child fit in root.
+// Root of nested array has larger header than its child, so they might not.
+func (a *ArrayMetaSlab) canCollapse() bool {
+	child := a.orderedHeaders.Front().Value.(*ArraySlabHeader)
+	return a.headerSize()+child.size-arrayMetaSlabHeaderSize <= maxThreshold
+}
 
-	if a.orderedHeaders.Len() == 1 {
-		return nil
+// collapseRoot moves children of root's only child to root,
+// decreasing tree height by one.
+func (a *ArrayMetaSlab) collapseRoot(storage SlabStorage) error {
+	slab, err := a.getSlab(storage, a.orderedHeaders.Front().Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
 	}
+	child := slab.(*ArrayMetaSlab)
 
-	header := headerElement.Value.(*ArraySlabHeader)
-	slab := header.slab
+	a.internal = child.internal
+	a.orderedHeaders.Init()
+	a.orderedHeaders.PushBackList(&child.orderedHeaders)
 
-	if headerElement.Prev() == nil {
+	storage.Remove(child.ID())
 
-		// First slab merges with next slab
-		nextHeaderElement := headerElement.Next()
-		nextSlab := nextHeaderElement.Value.(*ArraySlabHeader).slab
+	a.updateHeader()
+	return nil
+}
 
-		// Merge with next slab
-		slab.Merge(nextSlab)
+func (a *ArrayMetaSlab) Split(storage SlabStorage) (Segmentable, error) {
+	if a.orderedHeaders.Len() < 2 {
+		// Can't split meta slab with one child
+		return nil, nil
+	}
 
-		// Remove merged slab header
-		a.orderedHeaders.Remove(nextHeaderElement)
+	// All child headers have the same encoded size, so keep the first half
+	// (rounded up) in this slab and move the rest to a new meta slab.
+	breakPoint := (a.orderedHeaders.Len() + 1) / 2
 
-		if header.size > maxThreshold {
-			return a.split(headerElement)
-		}
+	e := a.orderedHeaders.Front()
+	for i := 0; i < breakPoint; i++ {
+		e = e.Next()
+	}
 
-		return nil
+	newSlab, err := newArrayMetaSlab(storage, a.header.id.Address)
+	if err != nil {
+		return nil, err
+	}
+	newSlab.internal = a.internal
+	for e != nil {
+		next := e.Next()
+		newSlab.orderedHeaders.PushBack(a.orderedHeaders.Remove(e))
+		e = next
 	}
 
-	if headerElement.Next() == nil {
+	a.updateHeader()
+	newSlab.updateHeader()
 
-		// Last slab merges with prev slab
-		prevHeaderElement := headerElement.Prev()
-		prevSlab := prevHeaderElement.Value.(*ArraySlabHeader).slab
+	return newSlab, nil
+}
 
-		prevSlab.Merge(slab)
+func (a *ArrayMetaSlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*ArrayMetaSlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into array meta slab", s)
+	}
+	a.orderedHeaders.PushBackList(&slab2.orderedHeaders)
+	a.updateHeader()
+	return nil
+}
 
-		// Remove merged (last) slab header
-		a.orderedHeaders.Remove(headerElement)
+// mergeSlabs merges slab of rightElement into slab of leftElement,
+// and removes merged slab from this meta slab and storage.
+func (a *ArrayMetaSlab) mergeSlabs(storage SlabStorage, leftElement, rightElement *list.Element) error {
+	leftSlab, err := a.getSlabForUpdate(storage, leftElement.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
+	}
 
-		if prevSlab.header.size > maxThreshold {
-			return a.split(prevHeaderElement)
-		}
+	rightSlab, err := a.getSlab(storage, rightElement.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
+	}
 
-		return nil
+	err = leftSlab.Merge(rightSlab)
+	if err != nil {
+		return err
 	}
 
-	prevHeaderElement := headerElement.Prev()
-	prevHeader := prevHeaderElement.Value.(*ArraySlabHeader)
+	a.storeSlab(storage, leftElement, leftSlab)
 
-	nextHeaderElement := headerElement.Next()
-	nextHeader := nextHeaderElement.Value.(*ArraySlabHeader)
+	// Remove merged slab header
+	a.orderedHeaders.Remove(rightElement)
 
-	if prevHeader.size <= nextHeader.size {
-		// Merge with previous slab
-		prevHeader.slab.Merge(slab)
+	storage.Remove(rightSlab.ID())
 
-		// remove merged slab header
-		a.orderedHeaders.Remove(headerElement)
+	if leftSlab.Header().size > maxThreshold {
+		return a.split(storage, leftElement)
+	}
 
-		if prevHeader.size > maxThreshold {
-			return a.split(prevHeaderElement)
-		}
+	return nil
+}
+
+func (a *ArrayMetaSlab) merge(storage SlabStorage, headerElement *list.Element) error {
+
+	if a.orderedHeaders.Len() == 1 {
 		return nil
+	}
 
-	} else {
+	if headerElement.Prev() == nil {
+		// First slab merges with next slab
+		return a.mergeSlabs(storage, headerElement, headerElement.Next())
+	}
 
-		// Merge with next slab
-		slab.Merge(nextHeader.slab)
+	if headerElement.Next() == nil {
+		// Last slab merges with prev slab
+		return a.mergeSlabs(storage, headerElement.Prev(), headerElement)
+	}
 
-		// Remove merged slab header
-		a.orderedHeaders.Remove(nextHeaderElement)
+	prevHeader := headerElement.Prev().Value.(*ArraySlabHeader)
+	nextHeader := headerElement.Next().Value.(*ArraySlabHeader)
 
-		if header.size > maxThreshold {
-			return a.split(headerElement)
-		}
-		return nil
+	if prevHeader.size <= nextHeader.size {
+		// Merge with previous slab
+		return a.mergeSlabs(storage, headerElement.Prev(), headerElement)
 	}
+
+	// Merge with next slab
+	return a.mergeSlabs(storage, headerElement, headerElement.Next())
 }
 
-func (a *ArrayMetaSlab) split(headerElement *list.Element) error {
-	header := headerElement.Value.(*ArraySlabHeader)
+func (a *ArrayMetaSlab) split(storage SlabStorage, headerElement *list.Element) error {
+	slab, err := a.getSlabForUpdate(storage, headerElement.Value.(*ArraySlabHeader))
+	if err != nil {
+		return err
+	}
 
-	newSlab, err := header.slab.Split()
+	newSlab, err := slab.Split(storage)
 	if err != nil {
 		return err
 	}
@@ -515,23 +1150,96 @@ func (a *ArrayMetaSlab) split(headerElement *list.Element) error {
 		return nil
 	}
 
-	a.orderedHeaders.InsertAfter(newSlab.header, headerElement)
+	a.storeSlab(storage, headerElement, slab)
+
+	newElement := a.orderedHeaders.InsertAfter(nil, headerElement)
+	a.storeSlab(storage, newElement, newSlab.(ArrayNode))
+
+	// Slabs with elements of different sizes might still exceed maxThreshold
+	if newSlab.(ArrayNode).Header().size > maxThreshold {
+		err = a.split(storage, newElement)
+		if err != nil {
+			return err
+		}
+	}
+	if slab.Header().size > maxThreshold {
+		return a.split(storage, headerElement)
+	}
 	return nil
 }
 
+// Iterate calls fn for each element in order until fn returns false or error.
+func (a *ArrayMetaSlab) Iterate(storage SlabStorage, fn func(Serializable) (bool, error)) error {
+	_, err := a.iterate(storage, fn)
+	return err
+}
+
+func (a *ArrayMetaSlab) iterate(storage SlabStorage, fn func(Serializable) (bool, error)) (bool, error) {
+	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
+		node, err := a.getSlab(storage, e.Value.(*ArraySlabHeader))
+		if err != nil {
+			return false, err
+		}
+
+		switch slab := node.(type) {
+		case *ArrayMetaSlab:
+			resume, err := slab.iterate(storage, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
+
+		case *ArraySlab:
+			if err := slab.load(); err != nil {
+				return false, err
+			}
+
+			// Copy elements so fn can modify the array without affecting iteration
+			elements := append([]Serializable(nil), slab.elements...)
+			for _, element := range elements {
+				resume, err := fn(element)
+				if err != nil || !resume {
+					return resume, err
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
 // Print is intended for debugging purpose only
-func (a *ArrayMetaSlab) Print() {
+func (a *ArrayMetaSlab) Print(storage SlabStorage) {
 	fmt.Println("============= array slabs ================")
+	a.print(storage, 0)
+	fmt.Println("==========================================")
+}
+
+func (a *ArrayMetaSlab) print(storage SlabStorage, level int) {
+	indent := strings.Repeat("  ", level)
 	i := 0
 	for e := a.orderedHeaders.Front(); e != nil; e = e.Next() {
 		h := e.Value.(*ArraySlabHeader)
-		fmt.Printf("slab %d, id %d, count %d, size %d\n", i, h.id, h.count, h.size)
-		fmt.Printf("[")
-		for _, e := range h.slab.elements {
-			fmt.Printf("%[1]v (%[1]T), ", e.GetValue())
+		node, err := a.getSlab(storage, h)
+		if err != nil {
+			fmt.Printf("%sslab %d, id %s, error %v\n", indent, i, h.id, err)
+			i++
+			continue
+		}
+		switch slab := node.(type) {
+		case *ArrayMetaSlab:
+			fmt.Printf("%smeta slab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			slab.print(storage, level+1)
+		case *ArraySlab:
+			fmt.Printf("%sslab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			if err := slab.load(); err != nil {
+				fmt.Printf("%serror %v\n", indent, err)
+				break
+			}
+			fmt.Printf("%s[", indent)
+			for _, e := range slab.elements {
+				fmt.Printf("%[1]v (%[1]T), ", e.GetValue())
+			}
+			fmt.Printf("]\n")
 		}
-		fmt.Printf("]\n")
 		i++
 	}
-	fmt.Println("==========================================")
 }
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+var testAddress = Address{0, 0, 0, 0, 0, 0, 0, 1}
+
+func newTestArrayValue(t *testing.T, storage SlabStorage, values []Value) *ArrayValue {
+	array, err := NewArrayValue(storage, testAddress, values)
+	require.NoError(t, err)
+	return array
+}
+
 func TestNewArray(t *testing.T) {
 	// TODO:
 	// - create non-empty array , serialize it, deserialize it, verify array content
@@ -14,14 +26,14 @@ func TestNewArray(t *testing.T) {
 	t.Parallel()
 
 	t.Run("empty", func(t *testing.T) {
-		array := NewArrayValue(nil)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
 
 		b, err := array.GetSerizable().Encode()
 		require.NoError(t, err)
-		assert.Equal(t, len(b), 8)                 // meta slab id (4 bytes) + slab count (4 bytes)
-		assert.Equal(t, []byte{0, 0, 0, 0}, b[4:]) // slab count is 0
+		assert.Equal(t, len(b), 20)                 // meta slab id (16 bytes) + slab count (4 bytes)
+		assert.Equal(t, []byte{0, 0, 0, 0}, b[16:]) // slab count is 0
 
-		array2, err := NewArrayValueFromEncodedData(b)
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 		require.NoError(t, err)
 		assert.Equal(t, uint32(0), array2.Size())
 	})
@@ -32,15 +44,15 @@ func TestNewArray(t *testing.T) {
 			values[i] = UInt32Value(i)
 		}
 
-		array := NewArrayValue(values)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
 
 		b, err := array.GetSerizable().Encode()
 		require.NoError(t, err)
-		assert.Equal(t, len(b), 8+8+19)                // meta slab id (4 bytes) + slab count (4 bytes) + slab 1 id (4 bytes) + slab 1 size (4 bytes) + slab 1 (19 bytes)
-		assert.Equal(t, []byte{0, 0, 0, 1}, b[4:8])    // slab count
-		assert.Equal(t, []byte{0, 0, 0, 19}, b[12:16]) // slab 1 size
+		assert.Equal(t, len(b), 20+12+19)              // meta slab id (16 bytes) + slab count (4 bytes) + slab 1 index (8 bytes) + slab 1 size (4 bytes) + slab 1 (19 bytes)
+		assert.Equal(t, []byte{0, 0, 0, 1}, b[16:20])  // slab count
+		assert.Equal(t, []byte{0, 0, 0, 19}, b[28:32]) // slab 1 size
 
-		array2, err := NewArrayValueFromEncodedData(b)
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 		require.NoError(t, err)
 		assert.Equal(t, uint32(len(values)), array2.Size())
 
@@ -57,12 +69,12 @@ func TestNewArray(t *testing.T) {
 			values[i] = UInt32Value(i)
 		}
 
-		array := NewArrayValue(values)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
 
 		b, err := array.GetSerizable().Encode()
 		require.NoError(t, err)
 
-		array2, err := NewArrayValueFromEncodedData(b)
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 		require.NoError(t, err)
 		assert.Equal(t, uint32(len(values)), array2.Size())
 
@@ -81,7 +93,7 @@ func TestArrayAppend(t *testing.T) {
 		values[i] = UInt32Value(i)
 	}
 
-	array := NewArrayValue(nil)
+	array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
 
 	const arraySize = uint32(20)
 	for i := 0; i < len(values); i++ {
@@ -91,7 +103,7 @@ func TestArrayAppend(t *testing.T) {
 	b, err := array.GetSerizable().Encode()
 	require.NoError(t, err)
 
-	array2, err := NewArrayValueFromEncodedData(b)
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 	require.NoError(t, err)
 	assert.Equal(t, arraySize, array2.Size())
 
@@ -104,7 +116,7 @@ func TestArrayAppend(t *testing.T) {
 
 func TestArrayRemove(t *testing.T) {
 	t.Run("fail", func(t *testing.T) {
-		array := NewArrayValue(nil)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
 		err := array.Remove(0)
 		require.Error(t, err)
 	})
@@ -115,7 +127,7 @@ func TestArrayRemove(t *testing.T) {
 			values[i] = UInt32Value(i)
 		}
 
-		array := NewArrayValue(values)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
 		size := array.Size()
 		for i := 0; i < len(values); i++ {
 			err := array.Remove(0)
@@ -128,7 +140,7 @@ func TestArrayRemove(t *testing.T) {
 		b, err := array.GetSerizable().Encode()
 		require.NoError(t, err)
 
-		array2, err := NewArrayValueFromEncodedData(b)
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 		require.NoError(t, err)
 		assert.Equal(t, uint32(0), array2.Size())
 	})
@@ -139,7 +151,7 @@ func TestArrayRemove(t *testing.T) {
 			values[i] = UInt32Value(i)
 		}
 
-		array := NewArrayValue(values)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
 		assert.True(t, array.metaSlab.orderedHeaders.Len() == 2)
 
 		var err error
@@ -162,7 +174,7 @@ func TestArrayRemove(t *testing.T) {
 
 func TestArrayInsert(t *testing.T) {
 
-	array := NewArrayValue([]Value{UInt32Value(2)})
+	array := newTestArrayValue(t, NewBasicSlabStorage(), []Value{UInt32Value(2)})
 
 	err := array.Insert(0, UInt32Value(0))
 	require.NoError(t, err)
@@ -176,7 +188,7 @@ func TestArrayInsert(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, len(b) > 8)
 
-	array2, err := NewArrayValueFromEncodedData(b)
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 	require.NoError(t, err)
 	assert.Equal(t, uint32(3), array2.Size())
 
@@ -188,7 +200,7 @@ func TestArrayInsert(t *testing.T) {
 }
 
 func TestArraySet(t *testing.T) {
-	array := NewArrayValue([]Value{UInt32Value(0), UInt32Value(1), UInt32Value(2)})
+	array := newTestArrayValue(t, NewBasicSlabStorage(), []Value{UInt32Value(0), UInt32Value(1), UInt32Value(2)})
 
 	array.Set(0, UInt32Value(3))
 	array.Set(1, UInt32Value(4))
@@ -200,7 +212,7 @@ func TestArraySet(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, len(b) > 8)
 
-	array2, err := NewArrayValueFromEncodedData(b)
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
 	require.NoError(t, err)
 	assert.Equal(t, uint32(3), array2.Size())
 
@@ -210,3 +222,742 @@ func TestArraySet(t *testing.T) {
 		assert.Equal(t, UInt32Value(i+3), v)
 	}
 }
+
+// verifyArrayTree checks that all data slabs are at the same depth,
+// no slab exceeds maxThreshold, cached headers match slab content,
+// and storage holds exactly the slabs of the array and its nested arrays.
+func verifyArrayTree(t *testing.T, array *ArrayValue, values []Value) {
+	slabIDs := make(map[StorageID]bool)
+
+	verifyArraySlabs(t, array, slabIDs)
+
+	if storage, ok := array.storage.SlabStorage.(*BasicSlabStorage); ok {
+		assert.Equal(t, len(slabIDs), len(storage.slabs))
+		for id := range storage.slabs {
+			assert.True(t, slabIDs[id], "slab %d in storage isn't in array", id)
+		}
+	}
+
+	require.Equal(t, uint32(len(values)), array.Size())
+	for i := 0; i < len(values); i++ {
+		v, err := array.Get(uint32(i))
+		require.NoError(t, err)
+		requireEqualElement(t, values[i], v)
+	}
+}
+
+// requireEqualElement checks that element v equals expected value.
+// Nested containers are loaded by another handle, so they're compared by ID.
+func requireEqualElement(t *testing.T, expected Value, v Value) {
+	switch expected := expected.(type) {
+	case *ArrayValue:
+		require.IsType(t, expected, v)
+		require.Equal(t, expected.ID(), v.(*ArrayValue).ID())
+	case *CompositeValue:
+		require.IsType(t, expected, v)
+		require.Equal(t, expected.ID(), v.(*CompositeValue).ID())
+	case SomeValue:
+		require.IsType(t, expected, v)
+		requireEqualElement(t, expected.Value, v.(SomeValue).Value)
+	default:
+		require.Equal(t, expected, v)
+	}
+}
+
+// verifyArraySlabs verifies slabs of array and its nested arrays,
+// adding their StorageIDs to slabIDs.
+func verifyArraySlabs(t *testing.T, array *ArrayValue, slabIDs map[StorageID]bool) {
+	leafDepth := -1
+
+	var verify func(node ArrayNode, depth int)
+	verify = func(node ArrayNode, depth int) {
+		header := node.Header()
+		slabIDs[header.id] = true
+		assert.True(t, header.size <= maxThreshold, "slab %d size %d exceeds %d", header.id, header.size, maxThreshold)
+
+		switch slab := node.(type) {
+		case *ArraySlab:
+			require.NoError(t, slab.load())
+
+			if leafDepth == -1 {
+				leafDepth = depth
+			}
+			assert.Equal(t, leafDepth, depth)
+			assert.Equal(t, uint32(len(slab.elements)), header.count)
+
+			size := slab.headerSize()
+			for _, e := range slab.elements {
+				size += e.ByteSize()
+			}
+			assert.Equal(t, size, header.size)
+
+			verifyNestedArrays(t, array, slab.elements, slabIDs)
+
+		case *ArrayMetaSlab:
+			count := uint32(0)
+			for e := slab.orderedHeaders.Front(); e != nil; e = e.Next() {
+				h := e.Value.(*ArraySlabHeader)
+				child, err := slab.getSlab(array.storage, h)
+				require.NoError(t, err)
+				assert.Equal(t, h, child.Header())
+				verify(child, depth+1)
+				count += h.count
+			}
+			assert.Equal(t, count, header.count)
+			assert.Equal(t, slab.headerSize()+uint32(slab.orderedHeaders.Len())*arrayMetaSlabChildHeaderSize, header.size)
+		}
+	}
+
+	root, err := array.root()
+	require.NoError(t, err)
+
+	verify(root, 0)
+}
+
+// verifyNestedArrays verifies arrays nested in elements of array,
+// adding StorageIDs of their slabs to slabIDs.
+func verifyNestedArrays(t *testing.T, array *ArrayValue, elements []Serializable, slabIDs map[StorageID]bool) {
+	for _, e := range elements {
+		switch e := e.(type) {
+		case *OverflowID:
+			verifyOverflowSlabs(t, array.storage, e.id, slabIDs)
+
+		case *SomeSerializable:
+			verifyNestedArrays(t, array, []Serializable{e.inner}, slabIDs)
+
+		case *StorageID:
+			slab, found, err := array.storage.Retrieve(*e)
+			require.NoError(t, err)
+			require.True(t, found)
+			if _, ok := slab.(*ArrayMetaSlab); !ok {
+				// Referenced composite isn't part of array
+				continue
+			}
+			child, err := NewArrayValueFromStorage(array.storage, *e)
+			require.NoError(t, err)
+			assert.Equal(t, array.ID(), child.metaSlab.parent)
+			verifyArraySlabs(t, child, slabIDs)
+
+		case *InlineArraySerializable:
+			assert.True(t, e.ByteSize() <= maxInlineArraySize, "inlined array %s size %d exceeds %d", e.id, e.ByteSize(), maxInlineArraySize)
+			assert.Equal(t, uint32(len(e.slab.elements)), e.slab.header.count)
+			assert.False(t, slabIDs[e.id], "inlined array %s has slab", e.id)
+
+			b, err := e.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, int(e.ByteSize()), len(b))
+
+			verifyNestedArrays(t, array, e.slab.elements, slabIDs)
+		}
+	}
+}
+
+func TestArrayMetaSlabTree(t *testing.T) {
+
+	const arraySize = 2000
+
+	values := make([]Value, arraySize)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+	assert.True(t, array.metaSlab.hasMetaChildren())
+	verifyArrayTree(t, array, values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	assert.Equal(t, array.metaSlab.ID(), array2.metaSlab.ID())
+	verifyArrayTree(t, array2, values)
+
+	// Insert in the middle of the array
+	for i := 0; i < 500; i++ {
+		index := uint32(len(values) / 2)
+		v := UInt32Value(arraySize + i)
+
+		err := array.Insert(index, v)
+		require.NoError(t, err)
+
+		values = append(values, nil)
+		copy(values[index+1:], values[index:])
+		values[index] = v
+	}
+	verifyArrayTree(t, array, values)
+
+	// Remove from the front of the array until it is almost empty
+	for len(values) > 3 {
+		err := array.Remove(0)
+		require.NoError(t, err)
+		values = values[1:]
+	}
+	assert.False(t, array.metaSlab.hasMetaChildren())
+	verifyArrayTree(t, array, values)
+
+	b, err = array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array3, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	verifyArrayTree(t, array3, values)
+}
+
+func TestArrayMixedIntegers(t *testing.T) {
+
+	integers := mixedIntegerValues()
+
+	values := make([]Value, 500)
+	for i := 0; i < len(values); i++ {
+		values[i] = integers[i%len(integers)]
+	}
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+	assert.True(t, array.metaSlab.orderedHeaders.Len() > 1)
+	verifyArrayTree(t, array, values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+
+	// Remove elements of different sizes
+	for i := len(values) - 1; i >= 0; i -= 3 {
+		require.NoError(t, array2.Remove(uint32(i)))
+		values = append(values[:i], values[i+1:]...)
+	}
+	verifyArrayTree(t, array2, values)
+}
+
+func TestArrayVariableSizeElements(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+
+	// Elements are from 1 to 72 bytes
+	randomValue := func() Value {
+		n := r.Intn(71)
+		if r.Intn(2) == 0 {
+			return StringValue(strings.Repeat("s", n))
+		}
+		return BytesValue(make([]byte, n))
+	}
+
+	var values []Value
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
+
+	for i := 0; i < 500; i++ {
+		v := randomValue()
+		require.NoError(t, array.Append(v))
+		values = append(values, v)
+	}
+	verifyArrayTree(t, array, values)
+
+	for i := 0; i < 500; i++ {
+		index := r.Intn(len(values) + 1)
+		v := randomValue()
+		require.NoError(t, array.Insert(uint32(index), v))
+
+		values = append(values, nil)
+		copy(values[index+1:], values[index:])
+		values[index] = v
+	}
+	verifyArrayTree(t, array, values)
+
+	for i := 0; i < 500; i++ {
+		index := r.Intn(len(values))
+		v := randomValue()
+		require.NoError(t, array.Set(uint32(index), v))
+		values[index] = v
+	}
+	verifyArrayTree(t, array, values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+
+	for len(values) > 10 {
+		index := r.Intn(len(values))
+		require.NoError(t, array2.Remove(uint32(index)))
+		values = append(values[:index], values[index+1:]...)
+	}
+	verifyArrayTree(t, array2, values)
+}
+
+func newTestNestedArrays(t *testing.T, storage SlabStorage, count int) []Value {
+	values := make([]Value, count)
+	for i := 0; i < count; i++ {
+		childValues := make([]Value, i*10)
+		for j := 0; j < len(childValues); j++ {
+			childValues[j] = UInt32Value(i*1000 + j)
+		}
+		values[i] = newTestArrayValue(t, storage, childValues)
+	}
+	return values
+}
+
+func TestArrayNested(t *testing.T) {
+
+	t.Run("get and modify", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 30)
+		values = append(values, UInt32Value(1))
+
+		array := newTestArrayValue(t, storage, values)
+		assert.True(t, array.metaSlab.orderedHeaders.Len() > 1)
+		verifyArrayTree(t, array, values)
+
+		// Nested array is stored as StorageID
+		element, err := array.metaSlab.Get(array.storage, 5)
+		require.NoError(t, err)
+		require.IsType(t, &StorageID{}, element)
+		assert.Equal(t, values[5].(*ArrayValue).ID(), *element.(*StorageID))
+
+		// Changes to nested array are visible through parent array
+		v, err := array.Get(5)
+		require.NoError(t, err)
+		child := v.(*ArrayValue)
+		assert.Equal(t, uint32(50), child.Size())
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, child.Append(UInt32Value(i)))
+		}
+
+		v, err = array.Get(5)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(150), v.(*ArrayValue).Size())
+		verifyArrayTree(t, array, values)
+
+		// Arrays nested in nested array
+		grandchild := newTestArrayValue(t, storage, []Value{UInt32Value(0)})
+		require.NoError(t, child.Insert(0, grandchild))
+		verifyArrayTree(t, array, values)
+
+		v, err = child.Get(0)
+		require.NoError(t, err)
+		assert.Equal(t, grandchild.ID(), v.(*ArrayValue).ID())
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 10)
+		array := newTestArrayValue(t, storage, values)
+
+		i := 0
+		err := array.Iterate(func(v Value) (bool, error) {
+			child, ok := v.(*ArrayValue)
+			require.True(t, ok)
+			assert.Equal(t, uint32(i*10), child.Size())
+			i++
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, len(values), i)
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := []Value{UInt32Value(0)}
+		array := newTestArrayValue(t, storage, values)
+
+		// Small child would be inlined, large child referenced by StorageID
+		children := newTestNestedArrays(t, storage, 30)
+		for _, i := range []int{1, 29} {
+			child := children[i].(*ArrayValue)
+			require.Error(t, array.Insert(2, child))
+			require.Error(t, array.Set(1, child))
+
+			// Child isn't taken by array, so it can still be nested
+			assert.Nil(t, child.inlined)
+			root, err := child.root()
+			require.NoError(t, err)
+			assert.False(t, root.isNested())
+			assert.Equal(t, uint32(i*10), child.Size())
+			verifyArraySlabs(t, child, make(map[StorageID]bool))
+		}
+		assert.Equal(t, uint32(1), array.Size())
+
+		for _, child := range children {
+			require.NoError(t, array.Append(child))
+			values = append(values, child)
+		}
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 10)
+		array := newTestArrayValue(t, storage, values)
+
+		committed := NewBasicSlabStorage()
+		for _, v := range values {
+			require.NoError(t, v.(*ArrayValue).Commit(committed))
+		}
+		require.NoError(t, array.Commit(committed))
+
+		// Nested array loaded through parent array is committed with parent array
+		v, err := array.Get(3)
+		require.NoError(t, err)
+		require.NoError(t, v.(*ArrayValue).Append(StringValue("new")))
+		require.NoError(t, array.Commit(committed))
+
+		array2, err := NewArrayValueFromStorage(committed, array.metaSlab.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+
+		v, err = array2.Get(3)
+		require.NoError(t, err)
+		child := v.(*ArrayValue)
+		require.Equal(t, uint32(31), child.Size())
+		last, err := child.Get(30)
+		require.NoError(t, err)
+		assert.Equal(t, StringValue("new"), last)
+	})
+
+	t.Run("file storage", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		values := newTestNestedArrays(t, storage, 10)
+		array := newTestArrayValue(t, storage, values)
+		id := array.metaSlab.ID()
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+		require.Equal(t, uint32(len(values)), array.Size())
+
+		for i := range values {
+			v, err := array.Get(uint32(i))
+			require.NoError(t, err)
+			child := v.(*ArrayValue)
+			assert.Equal(t, values[i].(*ArrayValue).ID(), child.ID())
+			require.Equal(t, uint32(i*10), child.Size())
+			if i > 0 {
+				e, err := child.Get(0)
+				require.NoError(t, err)
+				assert.Equal(t, UInt32Value(i*1000), e)
+			}
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 10)
+		values = append(values, UInt32Value(1))
+		array := newTestArrayValue(t, storage, values)
+
+		// Arrays nested in nested array, with overflow element
+		v, err := array.Get(5)
+		require.NoError(t, err)
+		child := v.(*ArrayValue)
+		grandchildValues := make([]Value, 100)
+		for i := range grandchildValues {
+			grandchildValues[i] = UInt32Value(i)
+		}
+		require.NoError(t, child.Append(newTestArrayValue(t, storage, grandchildValues)))
+		require.NoError(t, child.Append(StringValue(strings.Repeat("a", 200))))
+		verifyArrayTree(t, array, values)
+
+		// Slabs of removed and replaced nested arrays are removed
+		require.NoError(t, array.Remove(5))
+		values = append(values[:5], values[6:]...)
+		verifyArrayTree(t, array, values)
+
+		require.NoError(t, array.Set(3, UInt32Value(3)))
+		values[3] = UInt32Value(3)
+		verifyArrayTree(t, array, values)
+
+		for array.Size() > 0 {
+			require.NoError(t, array.Remove(0))
+		}
+		verifyArrayTree(t, array, nil)
+	})
+
+	t.Run("owned by one parent", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 10)
+		array := newTestArrayValue(t, storage, values)
+		array2 := newTestArrayValue(t, storage, nil)
+
+		// Nested array can't be added to another array, or again to the same array
+		require.Error(t, array2.Append(values[9]))
+		require.Error(t, array.Append(values[9]))
+		assert.Equal(t, uint32(0), array2.Size())
+		assert.Equal(t, uint32(len(values)), array.Size())
+
+		// Array holding nested arrays can be added to another array once
+		require.NoError(t, array2.Append(array))
+		require.Error(t, array2.Append(array))
+		assert.Equal(t, uint32(1), array2.Size())
+		verifyArrayTree(t, array2, []Value{array})
+	})
+
+	t.Run("parent in file storage", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		values := newTestNestedArrays(t, storage, 10)
+		array := newTestArrayValue(t, storage, values)
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		child, err := NewArrayValueFromStorage(storage, values[9].(*ArrayValue).ID())
+		require.NoError(t, err)
+		assert.Equal(t, array.ID(), child.metaSlab.parent)
+
+		array2 := newTestArrayValue(t, storage, nil)
+		require.Error(t, array2.Append(child))
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newTestNestedArrays(t, storage, 10)
+		a := values[9].(*ArrayValue)
+		b := values[8].(*ArrayValue)
+		c := values[7].(*ArrayValue)
+		require.NoError(t, a.Append(b))
+		require.NoError(t, b.Append(c))
+
+		// Array can't be nested in array nested in it
+		require.Error(t, b.Append(a))
+		require.Error(t, c.Append(a))
+		require.Error(t, c.Append(b))
+		assert.Equal(t, uint32(81), b.Size())
+		assert.Equal(t, uint32(70), c.Size())
+
+		// Parents of inlined array are found through its parent
+		inlined := newTestArrayValue(t, storage, nil)
+		require.NoError(t, c.Append(inlined))
+		v, err := c.Get(c.Size() - 1)
+		require.NoError(t, err)
+		inlined = v.(*ArrayValue)
+		require.NotNil(t, inlined.inlined)
+		require.Error(t, inlined.Append(a))
+		require.Error(t, inlined.Append(c))
+		assert.Equal(t, uint32(0), inlined.Size())
+
+		// Inlined array holding nested array is moved to its own slabs,
+		// so nested array references stored parent
+		d := values[6].(*ArrayValue)
+		require.NoError(t, inlined.Append(d))
+		assert.Nil(t, inlined.inlined)
+		requireNestedElement(t, c, c.Size()-1, inlined.ID(), false)
+		require.Error(t, d.Append(a))
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
+
+		// Nested array must be in the same storage
+		other, err := NewArrayValue(NewBasicSlabStorage(), Address{2}, nil)
+		require.NoError(t, err)
+		require.Error(t, array.Append(other))
+
+		require.Error(t, array.Append(array))
+		assert.Equal(t, uint32(0), array.Size())
+	})
+}
+
+var benchmarkArraySizes = []int{1_000, 10_000, 100_000, 1_000_000}
+
+func newBenchmarkArray(b *testing.B, size int) *ArrayValue {
+	array, err := NewArrayValue(NewBasicSlabStorage(), testAddress, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < size; i++ {
+		err := array.Append(UInt32Value(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return array
+}
+
+func BenchmarkArrayGet(b *testing.B) {
+	for _, size := range benchmarkArraySizes {
+		array := newBenchmarkArray(b, size)
+
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := array.Get(uint32(i % size))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkArraySet(b *testing.B) {
+	for _, size := range benchmarkArraySizes {
+		array := newBenchmarkArray(b, size)
+
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := array.Set(uint32(i%size), UInt32Value(i))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLazyArray(t *testing.T) {
+
+	values := make([]Value, 2000)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	loadedSlabCount := func(storage *BasicSlabStorage) int {
+		count := 0
+		for _, slab := range storage.slabs {
+			if s, ok := slab.(*ArraySlab); ok && len(s.elements) > 0 {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("get", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		array2, err := NewLazyArrayValueFromEncodedData(storage, b)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(len(values)), array2.Size())
+		assert.Equal(t, 0, loadedSlabCount(storage))
+
+		v, err := array2.Get(1000)
+		require.NoError(t, err)
+		assert.Equal(t, UInt32Value(1000), v)
+		assert.Equal(t, 1, loadedSlabCount(storage))
+
+		// Encoding reuses data of slabs that aren't loaded
+		b2, err := array2.GetSerizable().Encode()
+		require.NoError(t, err)
+		assert.Equal(t, b, b2)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		values := append([]Value(nil), values...)
+
+		array2, err := NewLazyArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+
+		for i := 0; i < 300; i++ {
+			err := array2.Remove(0)
+			require.NoError(t, err)
+			values = values[1:]
+		}
+
+		err = array2.Set(0, UInt32Value(0))
+		require.NoError(t, err)
+		values[0] = UInt32Value(0)
+
+		err = array2.Insert(10, UInt32Value(10000))
+		require.NoError(t, err)
+		values = append(values[:10], append([]Value{UInt32Value(10000)}, values[10:]...)...)
+
+		verifyArrayTree(t, array2, values)
+
+		b2, err := array2.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		array3, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b2)
+		require.NoError(t, err)
+		verifyArrayTree(t, array3, values)
+	})
+}
+
+func TestArrayCommit(t *testing.T) {
+
+	values := make([]Value, 2000)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	storage := NewBasicSlabStorage()
+	array := newTestArrayValue(t, storage, values)
+
+	committed := NewBasicSlabStorage()
+
+	// First commit writes all slabs
+	err := array.Commit(committed)
+	require.NoError(t, err)
+	assert.Equal(t, len(storage.slabs), len(committed.slabs))
+	assert.Equal(t, 0, len(array.storage.stored))
+
+	array2, err := NewArrayValueFromStorage(committed, array.metaSlab.ID())
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+
+	// Updating one element only writes slabs on the path from root to data slab
+	err = array.Set(1000, UInt32Value(0))
+	require.NoError(t, err)
+	values[1000] = UInt32Value(0)
+
+	depth := 1
+	for meta := array.metaSlab; meta.internal; depth++ {
+		slab, err := meta.getSlab(array.storage, meta.orderedHeaders.Front().Value.(*ArraySlabHeader))
+		require.NoError(t, err)
+		meta = slab.(*ArrayMetaSlab)
+	}
+	assert.Equal(t, depth+1, len(array.storage.stored))
+	assert.Equal(t, 0, len(array.storage.removed))
+
+	// Committed slabs aren't modified until commit
+	v, err := array2.Get(1000)
+	require.NoError(t, err)
+	assert.Equal(t, UInt32Value(1000), v)
+
+	err = array.Commit(committed)
+	require.NoError(t, err)
+
+	array2, err = NewArrayValueFromStorage(committed, array.metaSlab.ID())
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+
+	// Removing elements removes merged slabs
+	for i := 0; i < 1500; i++ {
+		err := array.Remove(0)
+		require.NoError(t, err)
+	}
+	values = values[1500:]
+	assert.True(t, len(array.storage.removed) > 0)
+
+	err = array.Commit(committed)
+	require.NoError(t, err)
+	assert.Equal(t, len(storage.slabs), len(committed.slabs))
+
+	array2, err = NewArrayValueFromStorage(committed, array.metaSlab.ID())
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+)
+
+const (
+	cborMajorSimpleValue = 0xe0
+
+	cborFalse = 0xf4
+	cborTrue  = 0xf5
+	cborNil   = 0xf6
+)
+
+type BoolSerializable struct {
+	v BoolValue
+}
+
+// Encode encodes BoolValue as CBOR true or false.
+func (s *BoolSerializable) Encode() ([]byte, error) {
+	if s.v {
+		return []byte{cborTrue}, nil
+	}
+	return []byte{cborFalse}, nil
+}
+
+func (s *BoolSerializable) Decode(b []byte) error {
+	if len(b) < 1 {
+		return errors.New("too short for BoolValue type")
+	}
+	switch b[0] {
+	case cborFalse:
+		s.v = false
+	case cborTrue:
+		s.v = true
+	default:
+		return errors.New("not BoolValue type")
+	}
+	return nil
+}
+
+func (s *BoolSerializable) ByteSize() uint32 {
+	return 1
+}
+
+func (s *BoolSerializable) IsConstantSized() bool { return true }
+
+func (s *BoolSerializable) GetValue() Value {
+	return s.v
+}
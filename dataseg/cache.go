@@ -0,0 +1,199 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cacheEntry is a slab cached by CachingSlabStorage.
+type cacheEntry struct {
+	slab  Slab
+	size  uint64
+	dirty bool
+}
+
+// CachingSlabStorage is a SlabStorage caching slabs of base storage in
+// memory, so that slabs retrieved repeatedly aren't decoded again.
+//
+// Stored slabs are dirty until Flush writes them to base storage, and
+// removed slabs are removed from base storage on Flush. Clean slabs are
+// evicted in least recently used order when total size of cached slabs
+// (measured by Slab.ByteSize) exceeds budget. Dirty slabs are never evicted,
+// so cache can exceed budget until it's flushed.
+//
+// It is safe for concurrent use if base storage is.
+type CachingSlabStorage struct {
+	base   SlabStorage
+	budget uint64
+
+	mu      sync.Mutex
+	entries map[StorageID]*list.Element
+	lru     list.List // front is most recently used *cacheEntry
+	size    uint64
+	removed map[StorageID]bool
+
+	hits   uint64
+	misses uint64
+}
+
+var _ SlabStorage = &CachingSlabStorage{}
+
+// NewCachingSlabStorage returns storage caching up to budget bytes
+// of clean slabs retrieved from base storage.
+func NewCachingSlabStorage(base SlabStorage, budget uint64) *CachingSlabStorage {
+	return &CachingSlabStorage{
+		base:    base,
+		budget:  budget,
+		entries: make(map[StorageID]*list.Element),
+		removed: make(map[StorageID]bool),
+	}
+}
+
+func (s *CachingSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[id]; ok {
+		s.hits++
+		s.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).slab, true, nil
+	}
+
+	if s.removed[id] {
+		return nil, false, nil
+	}
+
+	s.misses++
+
+	slab, found, err := s.base.Retrieve(id)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	// Cached slab is modified in place, so it must not be shared with base
+	slab = slab.Clone()
+
+	s.put(slab, false)
+	s.evict()
+
+	return slab, true, nil
+}
+
+func (s *CachingSlabStorage) Store(slab Slab) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.removed, slab.ID())
+	s.put(slab, true)
+	s.evict()
+}
+
+func (s *CachingSlabStorage) Remove(id StorageID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[id]; ok {
+		s.drop(e)
+	}
+	s.removed[id] = true
+}
+
+// GenerateStorageID allocates StorageID from base storage. StorageIDs of
+// dirty slabs not yet written to base storage are skipped.
+func (s *CachingSlabStorage) GenerateStorageID(address Address) (StorageID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		id, err := s.base.GenerateStorageID(address)
+		if err != nil {
+			return StorageID{}, err
+		}
+		if e, ok := s.entries[id]; !ok || !e.Value.(*cacheEntry).dirty {
+			return id, nil
+		}
+	}
+}
+
+// Flush writes copies of dirty slabs to base storage and removes slabs
+// removed since last Flush from base storage. Flushed slabs stay cached
+// until they are evicted, and their later modifications reach base storage
+// only on next Flush.
+func (s *CachingSlabStorage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirty := make(map[StorageID]bool)
+	for id, e := range s.entries {
+		if e.Value.(*cacheEntry).dirty {
+			dirty[id] = true
+		}
+	}
+
+	for _, id := range sortedStorageIDs(dirty) {
+		entry := s.entries[id].Value.(*cacheEntry)
+		s.base.Store(entry.slab.Clone())
+		entry.dirty = false
+	}
+	for _, id := range sortedStorageIDs(s.removed) {
+		s.base.Remove(id)
+	}
+
+	s.removed = make(map[StorageID]bool)
+	s.evict()
+}
+
+// Hits returns number of Retrieve calls which found slab in cache.
+func (s *CachingSlabStorage) Hits() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+// Misses returns number of Retrieve calls which retrieved slab from base storage.
+func (s *CachingSlabStorage) Misses() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.misses
+}
+
+// Size returns total size of cached slabs.
+func (s *CachingSlabStorage) Size() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// put caches slab as the most recently used, replacing cached slab with the same id.
+// Size of slab is measured again, since cached slabs are modified in place.
+func (s *CachingSlabStorage) put(slab Slab, dirty bool) {
+	entry := &cacheEntry{slab: slab, size: uint64(slab.ByteSize()), dirty: dirty}
+
+	if e, ok := s.entries[slab.ID()]; ok {
+		s.size -= e.Value.(*cacheEntry).size
+		e.Value = entry
+		s.lru.MoveToFront(e)
+	} else {
+		s.entries[slab.ID()] = s.lru.PushFront(entry)
+	}
+	s.size += entry.size
+}
+
+func (s *CachingSlabStorage) drop(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	s.lru.Remove(e)
+	delete(s.entries, entry.slab.ID())
+	s.size -= entry.size
+}
+
+// evict drops least recently used clean slabs until cache size is within budget.
+func (s *CachingSlabStorage) evict() {
+	e := s.lru.Back()
+	for s.size > s.budget && e != nil {
+		prev := e.Prev()
+		if !e.Value.(*cacheEntry).dirty {
+			s.drop(e)
+		}
+		e = prev
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingSlabStorage(t *testing.T) {
+
+	const arraySize = 2000
+
+	values := make([]Value, arraySize)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	t.Run("dirty slabs aren't evicted", func(t *testing.T) {
+		base := NewBasicSlabStorage()
+
+		// Budget is smaller than any slab
+		storage := NewCachingSlabStorage(base, 1)
+
+		array := newTestArrayValue(t, storage, values)
+		verifyArrayTree(t, array, values)
+
+		// Slabs aren't written to base storage before Flush
+		assert.Equal(t, 0, len(base.slabs))
+		assert.Greater(t, storage.Size(), uint64(1))
+
+		storage.Flush()
+
+		// All slabs are clean and evicted
+		assert.Equal(t, uint64(0), storage.Size())
+		assert.Equal(t, 0, len(storage.entries))
+
+		array, err := NewArrayValueFromStorage(base, array.metaSlab.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("removed slabs", func(t *testing.T) {
+		base := NewBasicSlabStorage()
+		storage := NewCachingSlabStorage(base, 1<<20)
+
+		array := newTestArrayValue(t, storage, values)
+		storage.Flush()
+
+		slabCount := len(base.slabs)
+
+		for i := 0; i < arraySize/2; i++ {
+			require.NoError(t, array.Remove(0))
+		}
+
+		// Removed slabs aren't retrieved from base storage
+		verifyArrayTree(t, array, values[arraySize/2:])
+		assert.Equal(t, slabCount, len(base.slabs))
+
+		storage.Flush()
+
+		assert.Less(t, len(base.slabs), slabCount)
+
+		array, err := NewArrayValueFromStorage(base, array.metaSlab.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values[arraySize/2:])
+	})
+
+	t.Run("modified after flush", func(t *testing.T) {
+		base := NewBasicSlabStorage()
+		storage := NewCachingSlabStorage(base, 1<<20)
+
+		array := newTestArrayValue(t, storage, values)
+		storage.Flush()
+
+		newValues := append([]Value(nil), values...)
+		for i := 0; i < arraySize; i += 100 {
+			require.NoError(t, array.Set(uint32(i), UInt32Value(0)))
+			newValues[i] = UInt32Value(0)
+		}
+		verifyArrayTree(t, array, newValues)
+
+		// Modifications reach base storage only on Flush
+		flushed, err := NewArrayValueFromStorage(base, array.metaSlab.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, flushed, values)
+
+		storage.Flush()
+
+		flushed, err = NewArrayValueFromStorage(base, array.metaSlab.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, flushed, newValues)
+	})
+
+	t.Run("hits and misses", func(t *testing.T) {
+		base, err := OpenFileSlabStorage(filepath.Join(t.TempDir(), "slabs"))
+		require.NoError(t, err)
+		defer base.Close()
+
+		array := newTestArrayValue(t, base, values)
+
+		storage := NewCachingSlabStorage(base, 1<<20)
+
+		array, err = NewArrayValueFromStorage(storage, array.metaSlab.ID())
+		require.NoError(t, err)
+
+		for i := uint32(0); i < arraySize; i++ {
+			_, err := array.Get(i)
+			require.NoError(t, err)
+		}
+
+		// Each slab is retrieved from base storage once
+		slabCount := uint64(len(storage.entries))
+		assert.Equal(t, slabCount, storage.Misses())
+		assert.Equal(t, uint64(len(base.index)), slabCount)
+
+		hits := storage.Hits()
+		assert.Greater(t, hits, uint64(0))
+
+		verifyArrayTree(t, array, values)
+
+		assert.Equal(t, slabCount, storage.Misses())
+		assert.Greater(t, storage.Hits(), hits)
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		base := NewBasicSlabStorage()
+
+		array := newTestArrayValue(t, base, values)
+
+		const budget = maxThreshold * 8
+		storage := NewCachingSlabStorage(base, budget)
+
+		array, err := NewArrayValueFromStorage(storage, array.metaSlab.ID())
+		require.NoError(t, err)
+
+		for i := uint32(0); i < arraySize; i++ {
+			v, err := array.Get(i)
+			require.NoError(t, err)
+			require.Equal(t, values[i], v)
+
+			assert.LessOrEqual(t, storage.Size(), uint64(budget))
+		}
+
+		misses := storage.Misses()
+
+		// Elements in the most recently used slab are cached
+		_, err = array.Get(arraySize - 1)
+		require.NoError(t, err)
+		assert.Equal(t, misses, storage.Misses())
+
+		// Elements in least recently used slab are evicted
+		_, err = array.Get(0)
+		require.NoError(t, err)
+		assert.Greater(t, storage.Misses(), misses)
+	})
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DictionaryCollisionSlab implements Slab interface.
+// It holds entries with the same key hash, which can't be separated by
+// masks. Entries are kept in a linear list and keys are compared by
+// encoded key.
+//
+// If entries exceed maxThreshold, the last entries are moved to the next
+// collision slab in chain, so collision slabs don't exceed maxThreshold
+// unless they hold one entry. Only the first slab in chain is a child of
+// meta slab, and header count of each slab in chain includes entries of
+// the following slabs. The following slabs are retrieved from storage
+// passed by the value accessing the slab, like in DictionaryMetaSlab.
+type DictionaryCollisionSlab struct {
+	header  *DictionarySlabHeader
+	hash    string
+	entries []dictionaryEntry
+	next    StorageID // next slab in chain, or zero StorageID if this is the last slab
+}
+
+// newDictionaryCollisionSlab creates collision slab with entries of data slab,
+// which must have the same key hash. Collision slab has the same StorageID
+// and mask as data slab.
+func newDictionaryCollisionSlab(storage SlabStorage, slab *DictionarySlab) (*DictionaryCollisionSlab, error) {
+	header := *slab.header
+
+	c := &DictionaryCollisionSlab{
+		header:  &header,
+		hash:    slab.entries[0].hash,
+		entries: append([]dictionaryEntry(nil), slab.entries...),
+	}
+	c.header.size = c.headerSize()
+	for _, e := range c.entries {
+		if e.hash != c.hash {
+			return nil, errors.New("can't create collision slab with different key hashes")
+		}
+		c.header.size += e.byteSize()
+	}
+
+	return c, c.spill(storage)
+}
+
+func (c *DictionaryCollisionSlab) Header() *DictionarySlabHeader {
+	return c.header
+}
+
+func (c *DictionaryCollisionSlab) headerSize() uint32 {
+	// map head (1 byte) + entry count (4 bytes) + next slab index (8 bytes)
+	return 13
+}
+
+// find returns index of entry with key, or -1 if it isn't found.
+func (c *DictionaryCollisionSlab) find(key Serializable) (int, error) {
+	for i, e := range c.entries {
+		cmp, err := compareKeys(e.key, key)
+		if err != nil {
+			return 0, err
+		}
+		if cmp == 0 {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func (c *DictionaryCollisionSlab) hasNext() bool {
+	return c.next != StorageID{}
+}
+
+// nextSlab retrieves the next slab in chain from storage.
+func (c *DictionaryCollisionSlab) nextSlab(storage SlabStorage) (*DictionaryCollisionSlab, error) {
+	slab, found, err := storage.Retrieve(c.next)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("dictionary collision slab %s not found", c.next)
+	}
+	next, ok := slab.(*DictionaryCollisionSlab)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not dictionary collision slab", c.next, slab)
+	}
+	return next, nil
+}
+
+// nextSlabForUpdate retrieves the next slab in chain which is about to be
+// modified, so that snapshots can preserve its current version.
+func (c *DictionaryCollisionSlab) nextSlabForUpdate(storage SlabStorage) (*DictionaryCollisionSlab, error) {
+	next, err := c.nextSlab(storage)
+	if err != nil {
+		return nil, err
+	}
+	if tracked, ok := storage.(*trackedSlabStorage); ok {
+		tracked.beforeUpdate(next)
+	}
+	return next, nil
+}
+
+func (c *DictionaryCollisionSlab) Get(storage SlabStorage, hash string, key Serializable) (Serializable, bool, error) {
+	if hash != c.hash {
+		return nil, false, nil
+	}
+
+	slab := c
+	for {
+		i, err := slab.find(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if i >= 0 {
+			return slab.entries[i].value, true, nil
+		}
+		if !slab.hasNext() {
+			return nil, false, nil
+		}
+		slab, err = slab.nextSlab(storage)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// Set replaces value of entry with the same key, or appends entry to
+// the last slab in chain. Entry must have the same key hash as collision slab.
+// It returns true if entry is inserted.
+func (c *DictionaryCollisionSlab) Set(storage SlabStorage, entry dictionaryEntry) (bool, error) {
+	if entry.hash != c.hash {
+		return false, errors.New("can't set entry with different key hash in collision slab")
+	}
+
+	i, err := c.find(entry.key)
+	if err != nil {
+		return false, err
+	}
+
+	if i >= 0 {
+		oldSize := c.entries[i].byteSize()
+		c.entries[i].value = entry.value
+		c.header.size = c.header.size - oldSize + entry.byteSize()
+		return false, c.spill(storage)
+	}
+
+	if c.hasNext() {
+		next, err := c.nextSlabForUpdate(storage)
+		if err != nil {
+			return false, err
+		}
+		inserted, err := next.Set(storage, entry)
+		if err != nil {
+			return false, err
+		}
+		storage.Store(next)
+		c.header.count = uint32(len(c.entries)) + next.header.count
+		return inserted, nil
+	}
+
+	c.entries = append(c.entries, entry)
+	c.header.count++
+	c.header.size += entry.byteSize()
+	return true, c.spill(storage)
+}
+
+// Remove removes entry with key. It returns true if entry is removed.
+func (c *DictionaryCollisionSlab) Remove(storage SlabStorage, hash string, key Serializable) (bool, error) {
+	if hash != c.hash {
+		return false, nil
+	}
+
+	i, err := c.find(key)
+	if err != nil {
+		return false, err
+	}
+
+	if i >= 0 {
+		size := c.entries[i].byteSize()
+
+		copy(c.entries[i:], c.entries[i+1:])
+		c.entries[len(c.entries)-1] = dictionaryEntry{}
+		c.entries = c.entries[:len(c.entries)-1]
+
+		c.header.size -= size
+	} else {
+		if !c.hasNext() {
+			return false, nil
+		}
+		next, err := c.nextSlabForUpdate(storage)
+		if err != nil {
+			return false, err
+		}
+		removed, err := next.Remove(storage, hash, key)
+		if err != nil || !removed {
+			return false, err
+		}
+		storage.Store(next)
+	}
+
+	c.header.count--
+	return true, c.mergeNext(storage)
+}
+
+// spill moves the last entries to the next slab in chain while slab
+// exceeds maxThreshold. The next slab is created if it doesn't exist.
+func (c *DictionaryCollisionSlab) spill(storage SlabStorage) error {
+	if c.header.size <= maxThreshold || len(c.entries) < 2 {
+		return nil
+	}
+
+	var next *DictionaryCollisionSlab
+	if c.hasNext() {
+		var err error
+		next, err = c.nextSlabForUpdate(storage)
+		if err != nil {
+			return err
+		}
+	} else {
+		id, err := storage.GenerateStorageID(c.header.id.Address)
+		if err != nil {
+			return err
+		}
+		next = &DictionaryCollisionSlab{
+			header: &DictionarySlabHeader{id: id, mask: c.header.mask},
+			hash:   c.hash,
+		}
+		next.header.size = next.headerSize()
+		c.next = id
+	}
+
+	i := len(c.entries)
+	size := c.header.size
+	for size > maxThreshold && i > 1 {
+		i--
+		size -= c.entries[i].byteSize()
+	}
+
+	next.entries = append(append([]dictionaryEntry(nil), c.entries[i:]...), next.entries...)
+	next.header.count += uint32(len(c.entries) - i)
+	next.header.size += c.header.size - size
+
+	for j := i; j < len(c.entries); j++ {
+		c.entries[j] = dictionaryEntry{}
+	}
+	c.entries = c.entries[:i]
+	c.header.size = size
+
+	err := next.spill(storage)
+	if err != nil {
+		return err
+	}
+	storage.Store(next)
+	return nil
+}
+
+// mergeNext moves entries of the next slab in chain to this slab
+// and removes the next slab, if merged slab doesn't exceed maxThreshold
+// or either slab is empty.
+func (c *DictionaryCollisionSlab) mergeNext(storage SlabStorage) error {
+	if !c.hasNext() {
+		return nil
+	}
+
+	next, err := c.nextSlab(storage)
+	if err != nil {
+		return err
+	}
+
+	if len(c.entries) > 0 && len(next.entries) > 0 &&
+		c.header.size+next.header.size-c.headerSize() > maxThreshold {
+		return nil
+	}
+
+	c.entries = append(c.entries, next.entries...)
+	c.header.size += next.header.size - c.headerSize()
+	c.next = next.next
+
+	storage.Remove(next.ID())
+	return nil
+}
+
+// chainEntries returns entries of all slabs in chain and the size of data slab
+// holding them.
+func (c *DictionaryCollisionSlab) chainEntries(storage SlabStorage) ([]dictionaryEntry, uint32, error) {
+	entries := append([]dictionaryEntry(nil), c.entries...)
+	size := c.header.size - c.headerSize()
+
+	slab := c
+	for slab.hasNext() {
+		var err error
+		slab, err = slab.nextSlab(storage)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, slab.entries...)
+		size += slab.header.size - slab.headerSize()
+	}
+
+	return entries, size + (&DictionarySlab{}).headerSize(), nil
+}
+
+// removeChain removes all slabs following this slab in chain from storage.
+func (c *DictionaryCollisionSlab) removeChain(storage SlabStorage) error {
+	slab := c
+	for slab.hasNext() {
+		var err error
+		slab, err = slab.nextSlab(storage)
+		if err != nil {
+			return err
+		}
+		storage.Remove(slab.ID())
+	}
+	c.next = StorageID{}
+	return nil
+}
+
+// Split extends slab mask by the bit of colliding key hash following slab
+// mask, and returns empty data slab accepting the other bit. It is used to
+// separate keys with different hash accepted by collision slab mask.
+// It returns nil if slab mask has all bits of colliding key hash.
+func (c *DictionaryCollisionSlab) Split(storage SlabStorage) (Segmentable, error) {
+	if c.header.mask.index >= uint32(len(c.hash))*8 {
+		return nil, nil
+	}
+
+	id, err := storage.GenerateStorageID(c.header.id.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	bit := inputBit(c.hash, int(c.header.mask.index))
+
+	newSlab := &DictionarySlab{
+		header: &DictionarySlabHeader{
+			id:   id,
+			mask: c.header.mask.child(1 - bit),
+		},
+	}
+	newSlab.header.size = newSlab.headerSize()
+
+	c.header.mask = c.header.mask.child(bit)
+	return newSlab, nil
+}
+
+// Merge isn't supported, collision slab is converted to data slab instead.
+func (c *DictionaryCollisionSlab) Merge(s Segmentable) error {
+	return fmt.Errorf("can't merge %T into dictionary collision slab", s)
+}
+
+func (c *DictionaryCollisionSlab) Clone() Slab {
+	header := *c.header
+	return &DictionaryCollisionSlab{
+		header:  &header,
+		hash:    c.hash,
+		entries: append([]dictionaryEntry(nil), c.entries...),
+		next:    c.next,
+	}
+}
+
+func (c *DictionaryCollisionSlab) ID() StorageID {
+	return c.header.id
+}
+
+// encodeEntries encodes entries as CBOR map followed by index of
+// the next slab in chain (8 bytes), which is 0 for the last slab.
+func (c *DictionaryCollisionSlab) encodeEntries() ([]byte, error) {
+	buf, err := encodeDictionaryEntries(c.entries, c.ByteSize())
+	if err != nil {
+		return nil, err
+	}
+
+	var index [8]byte
+	binary.BigEndian.PutUint64(index[:], c.next.Index)
+	return append(buf, index[:]...), nil
+}
+
+// decodeEntries decodes entries encoded by encodeEntries
+// and returns the remaining data.
+func (c *DictionaryCollisionSlab) decodeEntries(data []byte) ([]byte, error) {
+	entries, data, err := decodeDictionaryEntries(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, errors.New("too short for dictionary collision slab")
+	}
+
+	c.entries = entries
+	c.header.size = c.headerSize()
+	for _, e := range entries {
+		if e.hash != entries[0].hash {
+			return nil, errors.New("dictionary collision slab has different key hashes")
+		}
+		c.header.size += e.byteSize()
+	}
+	if len(entries) > 0 {
+		c.hash = entries[0].hash
+	}
+
+	c.next = StorageID{}
+	if index := binary.BigEndian.Uint64(data); index != 0 {
+		c.next = NewStorageID(c.header.id.Address, index)
+	}
+	return data[8:], nil
+}
+
+// Encode encodes slab on its own (see encodeSlab), because the following
+// slabs in chain are retrieved from storage passed to encode.
+func (c *DictionaryCollisionSlab) Encode() ([]byte, error) {
+	return c.encodeSlab()
+}
+
+// encode encodes entries of this slab followed by the following slabs in chain.
+// Slab mask is encoded by parent meta slab.
+func (c *DictionaryCollisionSlab) encode(storage SlabStorage) ([]byte, error) {
+	buf, err := c.encodeEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.hasNext() {
+		next, err := c.nextSlab(storage)
+		if err != nil {
+			return nil, err
+		}
+		b, err := next.encode(storage)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+// Decode decodes slab encoded on its own (see decodeSlab).
+func (c *DictionaryCollisionSlab) Decode(data []byte) error {
+	return c.decodeSlab(data)
+}
+
+// decode decodes slab and the following slabs in chain encoded by encode,
+// and stores decoded following slabs in storage.
+func (c *DictionaryCollisionSlab) decode(storage SlabStorage, data []byte) error {
+	data, err := c.decodeEntries(data)
+	if err != nil {
+		return err
+	}
+
+	c.header.count = uint32(len(c.entries))
+
+	if !c.hasNext() {
+		if len(data) > 0 {
+			return errors.New("wrong byte size for dictionary collision slab")
+		}
+		return nil
+	}
+
+	next := &DictionaryCollisionSlab{
+		header: &DictionarySlabHeader{id: c.next, mask: c.header.mask},
+	}
+	err = next.decode(storage, data)
+	if err != nil {
+		return err
+	}
+	if next.hash != c.hash {
+		return errors.New("dictionary collision slabs in chain have different key hashes")
+	}
+
+	storage.Store(next)
+
+	c.header.count += next.header.count
+	return nil
+}
+
+// encodeSlab encodes slab mask and entry count of all slabs in chain (4 bytes),
+// followed by entries of this slab. It is used to store slab on its own.
+func (c *DictionaryCollisionSlab) encodeSlab() ([]byte, error) {
+	data, err := c.encodeEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	maskSize := int(c.header.mask.encodedSize())
+
+	buf := make([]byte, maskSize+4, maskSize+4+len(data))
+	c.header.mask.encode(buf)
+	binary.BigEndian.PutUint32(buf[maskSize:], c.header.count)
+	return append(buf, data...), nil
+}
+
+// decodeSlab decodes slab encoded by encodeSlab.
+// The following slabs in chain are retrieved from storage on access.
+func (c *DictionaryCollisionSlab) decodeSlab(data []byte) error {
+	mask, n, err := decodeMask(data)
+	if err != nil {
+		return err
+	}
+	if len(data) < n+4 {
+		return errors.New("too short for dictionary collision slab")
+	}
+	c.header.mask = mask
+	c.header.count = binary.BigEndian.Uint32(data[n:])
+
+	data, err = c.decodeEntries(data[n+4:])
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return errors.New("wrong byte size for dictionary collision slab")
+	}
+	return nil
+}
+
+func (c *DictionaryCollisionSlab) ByteSize() uint32 {
+	return c.header.size
+}
+
+func (c *DictionaryCollisionSlab) IsConstantSized() bool { return false }
+
+// GetValue returns nil because collision slab holds only entries whose keys
+// have the same hash. DictionaryValue is returned by GetValue of Serializable
+// returned by its GetSerizable.
+func (c *DictionaryCollisionSlab) GetValue() Value {
+	return nil
+}
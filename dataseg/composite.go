@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// CompositeKind is the kind of composite type, with the same values
+// as common.CompositeKind of Cadence.
+type CompositeKind uint8
+
+const (
+	CompositeKindUnknown CompositeKind = iota
+	CompositeKindStructure
+	CompositeKindResource
+	CompositeKindContract
+	CompositeKindEvent
+	CompositeKindEnum
+)
+
+func (k CompositeKind) String() string {
+	switch k {
+	case CompositeKindStructure:
+		return "structure"
+	case CompositeKindResource:
+		return "resource"
+	case CompositeKindContract:
+		return "contract"
+	case CompositeKindEvent:
+		return "event"
+	case CompositeKindEnum:
+		return "enum"
+	default:
+		return fmt.Sprintf("CompositeKind(%d)", uint8(k))
+	}
+}
+
+// CompositeSlab implements Slab interface. It is the root slab of
+// CompositeValue holding type ID, kind and fields.
+//
+// Fields are held in the slab in order of encoded name while the slab
+// fits in maxThreshold. Fields of larger composite are moved to an
+// ordered map tree, and the slab holds StorageID of its root slab.
+// Fields aren't moved back to the slab when the composite shrinks.
+type CompositeSlab struct {
+	id     StorageID
+	typeID string
+	kind   CompositeKind
+
+	// fields is data slab of fields held in this slab, or nil if
+	// fields are in ordered map with root slab fieldMap.
+	fields   *OrderedMapSlab
+	fieldMap *StorageID
+}
+
+func newCompositeSlab(id StorageID, typeID string, kind CompositeKind) *CompositeSlab {
+	fields := &OrderedMapSlab{header: &OrderedMapSlabHeader{id: id}}
+	fields.header.size = fields.headerSize()
+
+	return &CompositeSlab{
+		id:     id,
+		typeID: typeID,
+		kind:   kind,
+		fields: fields,
+	}
+}
+
+func (m *CompositeSlab) ID() StorageID {
+	return m.id
+}
+
+// GetValue returns nil because composite slab is shared by CompositeValues
+// loaded from storage. CompositeValue is returned by GetValue of
+// Serializable returned by its GetSerizable.
+func (m *CompositeSlab) GetValue() Value {
+	return nil
+}
+
+func (m *CompositeSlab) IsConstantSized() bool { return false }
+
+func (m *CompositeSlab) headerSize() uint32 {
+	// tag head (2 bytes) + address (8 bytes) + index (8 bytes) + type ID + kind (1 byte)
+	return 18 + cborHeadSize(uint64(len(m.typeID))) + uint32(len(m.typeID)) + 1
+}
+
+// ByteSize returns encoded size of composite slab, not including
+// slabs of field map.
+func (m *CompositeSlab) ByteSize() uint32 {
+	if m.fields == nil {
+		return m.headerSize() + m.fieldMap.ByteSize()
+	}
+	return m.headerSize() + m.fields.ByteSize()
+}
+
+func (m *CompositeSlab) Clone() Slab {
+	slab := &CompositeSlab{
+		id:     m.id,
+		typeID: m.typeID,
+		kind:   m.kind,
+	}
+	if m.fields != nil {
+		slab.fields = m.fields.Clone().(*OrderedMapSlab)
+	} else {
+		id := *m.fieldMap
+		slab.fieldMap = &id
+	}
+	return slab
+}
+
+// Split returns nil because composite slab isn't split. Fields are moved
+// to ordered map tree instead when slab grows past maxThreshold.
+func (m *CompositeSlab) Split(storage SlabStorage) (Segmentable, error) {
+	return nil, nil
+}
+
+func (m *CompositeSlab) Merge(s Segmentable) error {
+	return errors.New("composite slab can't be merged")
+}
+
+// getFieldMap retrieves root slab of field map from storage.
+func (m *CompositeSlab) getFieldMap(storage SlabStorage) (*OrderedMapMetaSlab, error) {
+	slab, found, err := storage.Retrieve(*m.fieldMap)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("ordered map slab %s not found", *m.fieldMap)
+	}
+	metaSlab, ok := slab.(*OrderedMapMetaSlab)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not ordered map meta slab", *m.fieldMap, slab)
+	}
+	return metaSlab, nil
+}
+
+// encodeSlab encodes composite slab as head of CBOR tag cborTagCompositeValue,
+// address (8 bytes), index (8 bytes), type ID as CBOR text string and
+// kind (1 byte), followed by either fields encoded as ordered map slab
+// or StorageID of field map. It is used to store composite slab on its own.
+func (m *CompositeSlab) encodeSlab() ([]byte, error) {
+	buf := make([]byte, m.headerSize(), m.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagCompositeValue
+	copy(buf[2:], m.id.Address[:])
+	binary.BigEndian.PutUint64(buf[10:], m.id.Index)
+
+	index := 18
+	index += encodeCBORHead(buf[index:], cborMajorTextString, uint64(len(m.typeID)))
+	index += copy(buf[index:], m.typeID)
+	buf[index] = byte(m.kind)
+
+	var b []byte
+	var err error
+	if m.fields == nil {
+		b, err = m.fieldMap.Encode()
+	} else {
+		b, err = m.fields.Encode()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf, b...), nil
+}
+
+// decodeSlab decodes composite slab encoded by encodeSlab, and returns
+// data following StorageID of field map.
+func (m *CompositeSlab) decodeSlab(data []byte) ([]byte, error) {
+	if len(data) < 18 {
+		return nil, errors.New("too short for composite slab")
+	}
+
+	if data[0] != 0xd8 || data[1] != cborTagCompositeValue {
+		return nil, errors.New("not composite slab")
+	}
+
+	var address Address
+	copy(address[:], data[2:])
+	m.id = NewStorageID(address, binary.BigEndian.Uint64(data[10:]))
+	data = data[18:]
+
+	typeID, err := decodeCBORString(data, cborMajorTextString)
+	if err != nil {
+		return nil, err
+	}
+	m.typeID = string(typeID)
+	data = data[cborHeadSize(uint64(len(typeID)))+uint32(len(typeID)):]
+
+	if len(data) < 2 {
+		return nil, errors.New("too short for composite slab")
+	}
+	m.kind = CompositeKind(data[0])
+	data = data[1:]
+
+	if data[0] == 0xd8 {
+		id := &StorageID{}
+		err = id.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		m.fields = nil
+		m.fieldMap = id
+		return data[id.ByteSize():], nil
+	}
+
+	m.fields = &OrderedMapSlab{header: &OrderedMapSlabHeader{id: m.id}}
+	m.fieldMap = nil
+	return nil, m.fields.Decode(data)
+}
+
+// Encode encodes composite slab on its own (see encodeSlab), because
+// slabs of field map are retrieved from storage passed to encode.
+func (m *CompositeSlab) Encode() ([]byte, error) {
+	return m.encodeSlab()
+}
+
+// encode encodes composite slab followed by encoded data of field map
+// tree if fields aren't held in composite slab.
+func (m *CompositeSlab) encode(storage SlabStorage) ([]byte, error) {
+	buf, err := m.encodeSlab()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.fields != nil {
+		return buf, nil
+	}
+
+	metaSlab, err := m.getFieldMap(storage)
+	if err != nil {
+		return nil, err
+	}
+	b, err := metaSlab.encode(storage)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, b...), nil
+}
+
+// Decode decodes composite slab holding its fields. Composite slab decoded
+// without storage (e.g. by decodeSerializable) can't have field map.
+func (m *CompositeSlab) Decode(data []byte) error {
+	_, err := m.decodeSlab(data)
+	if err != nil {
+		return err
+	}
+
+	if m.fields == nil {
+		return fmt.Errorf("field map of composite %s can't be decoded without storage", m.id)
+	}
+	return nil
+}
+
+// decode decodes composite slab and field map tree encoded by encode,
+// and stores decoded slabs of field map in storage.
+func (m *CompositeSlab) decode(storage SlabStorage, data []byte) error {
+	rest, err := m.decodeSlab(data)
+	if err != nil {
+		return err
+	}
+
+	if m.fields != nil {
+		return nil
+	}
+
+	metaSlab := &OrderedMapMetaSlab{header: &OrderedMapSlabHeader{}}
+	err = metaSlab.decode(storage, rest)
+	if err != nil {
+		return err
+	}
+	if metaSlab.ID() != *m.fieldMap {
+		return fmt.Errorf("field map of composite %s has wrong id %s", m.id, metaSlab.ID())
+	}
+
+	storage.Store(metaSlab)
+	return nil
+}
+
+// fieldMapValue returns OrderedMapValue of fields of large composite,
+// which uses storage of this composite to track changes.
+func (v *CompositeValue) fieldMapValue(slab *CompositeSlab) *OrderedMapValue {
+	// Root slab is retrieved from storage by ordered map on access
+	metaSlab := &OrderedMapMetaSlab{header: &OrderedMapSlabHeader{id: *slab.fieldMap}}
+	return &OrderedMapValue{slabContainer: v.slabContainer, metaSlab: metaSlab}
+}
+
+// moveFields moves fields held in composite slab to a new ordered map
+// tree with slabs owned by address of composite.
+func (v *CompositeValue) moveFields(slab *CompositeSlab) error {
+	metaSlab, err := newOrderedMapMetaSlab(v.storage, slab.id.Address)
+	if err != nil {
+		return err
+	}
+
+	v.storage.Store(metaSlab)
+
+	for _, e := range slab.fields.entries {
+		_, err := metaSlab.Set(v.storage, e)
+		if err != nil {
+			return err
+		}
+		err = metaSlab.updateRoot(v.storage)
+		if err != nil {
+			return err
+		}
+	}
+
+	id := metaSlab.ID()
+	slab.fieldMap = &id
+	slab.fields = nil
+	return nil
+}
+
+// compositeReference returns StorageID referencing composite nested in
+// another container. Nested composite isn't owned by the container, so it
+// isn't removed with the container, and it must be stored in storage of
+// the container.
+func compositeReference(storage SlabStorage, composite *CompositeValue) (*StorageID, error) {
+	id := composite.ID()
+	_, err := retrieveRootSlab(storage, id, compositeKind)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// field returns Serializable stored as value of field. Nested ArrayValue
+// is stored as StorageID of its root slab like in ArrayValue.element, so
+// it must be stored in the same storage as this composite. Nested array is
+// owned by this composite after it's set, so it can't be set as another
+// field or added to an array. Nested CompositeValue is referenced by
+// StorageID (see compositeReference). SomeValue holding ArrayValue or
+// CompositeValue holds StorageID of the container.
+func (v *CompositeValue) field(value Value) (Serializable, error) {
+	switch child := value.(type) {
+	case *CompositeValue:
+		if child.ID() == v.ID() {
+			return nil, errors.New("can't set composite as its own field")
+		}
+		return compositeReference(v.storage, child)
+
+	case *ArrayValue:
+		if child.inlined != nil {
+			return nil, fmt.Errorf("array %s is already nested in another array", child.ID())
+		}
+
+		if child.storage.readOnly() {
+			return nil, fmt.Errorf("array %s is in snapshot", child.ID())
+		}
+
+		// Root slab of nested array is stored with this composite's changes
+		id := child.ID()
+		nested, err := NewArrayValueFromStorage(v.storage, id)
+		if err != nil {
+			return nil, err
+		}
+		root, err := nested.rootForUpdate()
+		if err != nil {
+			return nil, err
+		}
+		if root.isNested() {
+			return nil, fmt.Errorf("array %s is already nested in %s", id, root.parent)
+		}
+		err = root.setParent(v.storage, v.ID())
+		if err != nil {
+			return nil, err
+		}
+		return &id, nil
+
+	case StorageID:
+		return nil, fmt.Errorf("value referenced by StorageID %s must be loaded from storage", child)
+
+	case SomeValue:
+		if !holdsContainer(child) {
+			return child.GetSerizable(), nil
+		}
+		inner, err := v.field(child.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &SomeSerializable{inner: inner}, nil
+
+	default:
+		return value.GetSerizable(), nil
+	}
+}
+
+// fieldValue returns Value of field stored as s. Nested ArrayValue and
+// CompositeValue are loaded from storage of this composite, so that their
+// changes are committed with this composite.
+func (v *CompositeValue) fieldValue(s Serializable) (Value, error) {
+	switch s := s.(type) {
+	case *StorageID:
+		return containerValue(v.storage, *s)
+	case *SomeSerializable:
+		inner, err := v.fieldValue(s.inner)
+		if err != nil {
+			return nil, err
+		}
+		return SomeValue{Value: inner}, nil
+	default:
+		return s.GetValue(), nil
+	}
+}
+
+// removeField removes slabs of nested array which is removed from
+// composite or replaced. Referenced composites aren't removed.
+func (v *CompositeValue) removeField(s Serializable) error {
+	if some, ok := s.(*SomeSerializable); ok {
+		return v.removeField(some.inner)
+	}
+	id, ok := s.(*StorageID)
+	if !ok {
+		return nil
+	}
+	slab, found, err := v.storage.Retrieve(*id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("slab %s not found", *id)
+	}
+	if _, ok := slab.(*ArrayMetaSlab); !ok {
+		return nil
+	}
+	return removeNestedArray(v.storage, *id)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCompositeTypeID = "A.0000000000000001.Test.Vault"
+
+func newTestCompositeValue(t *testing.T, storage SlabStorage, fields map[string]Value) *CompositeValue {
+	composite, err := NewCompositeValue(storage, testAddress, testCompositeTypeID, CompositeKindResource, fields)
+	require.NoError(t, err)
+	return composite
+}
+
+func verifyComposite(t *testing.T, composite *CompositeValue, fields map[string]Value) {
+	slab, err := composite.root()
+	require.NoError(t, err)
+
+	assert.Equal(t, testCompositeTypeID, composite.TypeID())
+	assert.Equal(t, CompositeKindResource, composite.Kind())
+
+	b, err := slab.encodeSlab()
+	require.NoError(t, err)
+	assert.Equal(t, int(slab.ByteSize()), len(b))
+
+	entries := make(map[Value]Value, len(fields))
+	for name, value := range fields {
+		entries[StringValue(name)] = value
+	}
+
+	if slab.fields != nil {
+		assert.True(t, slab.ByteSize() <= maxThreshold, "composite slab size %d exceeds %d", slab.ByteSize(), maxThreshold)
+		assert.Equal(t, uint32(len(slab.fields.entries)), slab.fields.header.count)
+
+		if storage, ok := composite.storage.SlabStorage.(*BasicSlabStorage); ok {
+			assert.Equal(t, 1, len(storage.slabs))
+		}
+	} else {
+		// Field map shares storage of composite slab, so storage holds
+		// one more slab than field map tree
+		fieldMap := composite.fieldMapValue(slab)
+		verifyOrderedMapTree(t, &OrderedMapValue{
+			slabContainer: newSlabContainer(composite.storage),
+			metaSlab:      fieldMap.metaSlab,
+		}, entries)
+	}
+
+	require.Equal(t, uint32(len(fields)), composite.FieldCount())
+	for name, value := range fields {
+		v, found, err := composite.GetField(name)
+		require.NoError(t, err)
+		require.True(t, found, "field %s not found", name)
+		require.Equal(t, value, v)
+	}
+
+	keys := sortedKeys(t, entries)
+
+	i := 0
+	err = composite.IterateFields(func(name string, value Value) (bool, error) {
+		require.Less(t, i, len(keys))
+		assert.Equal(t, keys[i], StringValue(name))
+		assert.Equal(t, fields[name], value)
+		i++
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(fields), i)
+}
+
+func testCompositeFields(count int) map[string]Value {
+	fields := make(map[string]Value, count)
+	for i := 0; i < count; i++ {
+		fields[fmt.Sprintf("field%d", i)] = UInt64Value(i)
+	}
+	return fields
+}
+
+func TestCompositeFields(t *testing.T) {
+
+	t.Run("small", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		fields := map[string]Value{
+			"balance": UFix64Value(150_000_000),
+			"uuid":    UInt64Value(1),
+		}
+		composite := newTestCompositeValue(t, storage, fields)
+		assert.NotNil(t, composite.slab.fields)
+		verifyComposite(t, composite, fields)
+
+		// Replace value
+		require.NoError(t, composite.SetField("balance", UFix64Value(0)))
+		fields["balance"] = UFix64Value(0)
+		verifyComposite(t, composite, fields)
+
+		_, found, err := composite.GetField("owner")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		removed, err := composite.RemoveField("owner")
+		require.NoError(t, err)
+		assert.False(t, removed)
+
+		removed, err = composite.RemoveField("uuid")
+		require.NoError(t, err)
+		assert.True(t, removed)
+		delete(fields, "uuid")
+		verifyComposite(t, composite, fields)
+	})
+
+	t.Run("large", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		composite := newTestCompositeValue(t, storage, nil)
+		id := composite.ID()
+
+		fields := make(map[string]Value)
+		for i := 0; composite.slab.fields != nil; i++ {
+			name := fmt.Sprintf("field%d", i)
+			require.NoError(t, composite.SetField(name, UInt64Value(i)))
+			fields[name] = UInt64Value(i)
+		}
+		assert.Equal(t, id, composite.ID())
+		verifyComposite(t, composite, fields)
+
+		// Fields are split among slabs
+		fields = testCompositeFields(500)
+		for name, value := range fields {
+			require.NoError(t, composite.SetField(name, value))
+		}
+		metaSlab, err := composite.fieldMapValue(composite.slab).root()
+		require.NoError(t, err)
+		assert.True(t, metaSlab.orderedHeaders.Len() > 1)
+		verifyComposite(t, composite, fields)
+
+		// Fields stay in their own slabs when composite shrinks
+		for name := range fields {
+			removed, err := composite.RemoveField(name)
+			require.NoError(t, err)
+			assert.True(t, removed)
+			delete(fields, name)
+		}
+		assert.Nil(t, composite.slab.fields)
+		verifyComposite(t, composite, fields)
+	})
+}
+
+func TestCompositeEncodeDecode(t *testing.T) {
+
+	test := func(t *testing.T, fields map[string]Value) {
+
+		t.Run("encoded data", func(t *testing.T) {
+			composite := newTestCompositeValue(t, NewBasicSlabStorage(), fields)
+
+			b, err := composite.GetSerizable().Encode()
+			require.NoError(t, err)
+
+			composite2, err := NewCompositeValueFromEncodedData(NewBasicSlabStorage(), b)
+			require.NoError(t, err)
+			assert.Equal(t, composite.ID(), composite2.ID())
+			verifyComposite(t, composite2, fields)
+
+			b2, err := composite2.GetSerizable().Encode()
+			require.NoError(t, err)
+			assert.Equal(t, b, b2)
+		})
+
+		t.Run("file storage", func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "slabs")
+			storage := openTestFileSlabStorage(t, path)
+
+			composite := newTestCompositeValue(t, storage, fields)
+			id := composite.ID()
+			require.NoError(t, storage.Close())
+
+			storage = openTestFileSlabStorage(t, path)
+			defer storage.Close()
+
+			composite, err := NewCompositeValueFromStorage(storage, id)
+			require.NoError(t, err)
+			verifyComposite(t, composite, fields)
+
+			// Loaded composite can be modified
+			require.NoError(t, composite.SetField("field0", BoolValue(true)))
+			v, found, err := composite.GetField("field0")
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, BoolValue(true), v)
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		test(t, nil)
+	})
+
+	t.Run("small", func(t *testing.T) {
+		test(t, testCompositeFields(2))
+	})
+
+	t.Run("large", func(t *testing.T) {
+		test(t, testCompositeFields(500))
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		// Fields are split among slabs, so split points
+		// depend on order in which fields are set
+		fields := testCompositeFields(500)
+
+		var encoded [][]byte
+		for i := 0; i < 2; i++ {
+			composite := newTestCompositeValue(t, NewBasicSlabStorage(), fields)
+			b, err := composite.GetSerizable().Encode()
+			require.NoError(t, err)
+			encoded = append(encoded, b)
+		}
+		assert.Equal(t, encoded[0], encoded[1])
+	})
+
+	t.Run("serializable", func(t *testing.T) {
+		composite := newTestCompositeValue(t, NewBasicSlabStorage(), map[string]Value{"uuid": UInt64Value(1)})
+		require.NotNil(t, composite.slab.fields)
+
+		b, err := composite.GetSerizable().Encode()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xd8, cborTagCompositeValue}, b[:2])
+
+		s, rest, err := decodeSerializable(b)
+		require.NoError(t, err)
+		assert.Empty(t, rest)
+		slab, ok := s.(*CompositeSlab)
+		require.True(t, ok)
+		assert.Equal(t, composite.ID(), slab.ID())
+		assert.Equal(t, uint32(1), slab.fields.header.count)
+	})
+}
+
+func TestCompositeNested(t *testing.T) {
+
+	t.Run("array field", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		array := newTestArrayValue(t, storage, []Value{UInt32Value(1), UInt32Value(2)})
+		composite := newTestCompositeValue(t, storage, map[string]Value{"items": array})
+
+		v, found, err := composite.GetField("items")
+		require.NoError(t, err)
+		require.True(t, found)
+		items := v.(*ArrayValue)
+		assert.Equal(t, array.ID(), items.ID())
+		assert.Equal(t, composite.ID(), items.metaSlab.parent)
+
+		// Changes of nested array are visible through composite
+		require.NoError(t, items.Append(UInt32Value(3)))
+		v, _, err = composite.GetField("items")
+		require.NoError(t, err)
+		assert.Equal(t, uint32(3), v.(*ArrayValue).Size())
+
+		// Nested array is owned by composite
+		array2 := newTestArrayValue(t, storage, nil)
+		require.Error(t, array2.Append(array))
+		require.Error(t, composite.SetField("items2", array))
+
+		// Replaced array is removed
+		require.NoError(t, composite.SetField("items", UInt32Value(0)))
+		_, found, err = storage.Retrieve(array.ID())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("composite field", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		inner := newTestCompositeValue(t, storage, testCompositeFields(2))
+		composite := newTestCompositeValue(t, storage, map[string]Value{"inner": inner})
+
+		v, found, err := composite.GetField("inner")
+		require.NoError(t, err)
+		require.True(t, found)
+		verifyComposite(t, v.(*CompositeValue), testCompositeFields(2))
+
+		require.Error(t, composite.SetField("self", composite))
+
+		// Referenced composite isn't removed with field
+		removed, err := composite.RemoveField("inner")
+		require.NoError(t, err)
+		assert.True(t, removed)
+		verifyComposite(t, inner, testCompositeFields(2))
+	})
+
+	t.Run("large composite", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		fields := testCompositeFields(500)
+		composite := newTestCompositeValue(t, storage, fields)
+		assert.Nil(t, composite.slab.fields)
+
+		array := newTestArrayValue(t, storage, []Value{UInt32Value(1)})
+		require.NoError(t, composite.SetField("items", array))
+
+		count := 0
+		err := composite.IterateFields(func(name string, value Value) (bool, error) {
+			if name == "items" {
+				assert.Equal(t, array.ID(), value.(*ArrayValue).ID())
+			} else {
+				assert.Equal(t, fields[name], value)
+			}
+			count++
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, len(fields)+1, count)
+
+		removed, err := composite.RemoveField("items")
+		require.NoError(t, err)
+		assert.True(t, removed)
+		_, found, err := storage.Retrieve(array.ID())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("array element", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		composite := newTestCompositeValue(t, storage, testCompositeFields(2))
+		array := newTestArrayValue(t, storage, []Value{composite, UInt32Value(1)})
+		id := array.ID()
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+
+		v, err := array.Get(0)
+		require.NoError(t, err)
+		assert.Equal(t, composite.ID(), v.(*CompositeValue).ID())
+		verifyComposite(t, v.(*CompositeValue), testCompositeFields(2))
+
+		// Referenced composite isn't removed with element
+		require.NoError(t, array.Remove(0))
+		_, found, err := storage.Retrieve(composite.ID())
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+}
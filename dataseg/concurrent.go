@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentSlabStorage is a SlabStorage which is safe for concurrent use.
+// Retrieve can run concurrently, while Store and Remove are exclusive.
+// StorageIDs are allocated atomically per address.
+//
+// ArrayValue reads are safe to run concurrently with each other and with
+// modifications of other arrays, but an array must not be read while
+// it is being modified.
+type ConcurrentSlabStorage struct {
+	mu    sync.RWMutex
+	slabs map[StorageID]Slab
+
+	// storageIndex maps Address to *uint64 holding last allocated index
+	storageIndex sync.Map
+}
+
+var _ SlabStorage = &ConcurrentSlabStorage{}
+
+func NewConcurrentSlabStorage() *ConcurrentSlabStorage {
+	return &ConcurrentSlabStorage{slabs: make(map[StorageID]Slab)}
+}
+
+func (s *ConcurrentSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	slab, ok := s.slabs[id]
+	return slab, ok, nil
+}
+
+func (s *ConcurrentSlabStorage) Store(slab Slab) {
+	id := slab.ID()
+
+	s.mu.Lock()
+	s.slabs[id] = slab
+	s.mu.Unlock()
+
+	// Don't reuse index of slabs stored with externally allocated StorageID
+	index := s.index(id.Address)
+	for {
+		current := atomic.LoadUint64(index)
+		if id.Index <= current || atomic.CompareAndSwapUint64(index, current, id.Index) {
+			break
+		}
+	}
+}
+
+func (s *ConcurrentSlabStorage) Remove(id StorageID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.slabs, id)
+}
+
+func (s *ConcurrentSlabStorage) GenerateStorageID(address Address) (StorageID, error) {
+	index := atomic.AddUint64(s.index(address), 1)
+	return NewStorageID(address, index), nil
+}
+
+func (s *ConcurrentSlabStorage) index(address Address) *uint64 {
+	index, _ := s.storageIndex.LoadOrStore(address, new(uint64))
+	return index.(*uint64)
+}
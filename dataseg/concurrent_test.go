@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentSlabStorageGenerateStorageID(t *testing.T) {
+
+	const goroutines = 8
+	const idsPerGoroutine = 1000
+
+	storage := NewConcurrentSlabStorage()
+
+	ids := make(chan StorageID, goroutines*idsPerGoroutine)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < idsPerGoroutine; j++ {
+				id, err := storage.GenerateStorageID(testAddress)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[StorageID]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate storage id %s", id)
+		seen[id] = true
+	}
+	assert.Equal(t, goroutines*idsPerGoroutine, len(seen))
+
+	id, err := storage.GenerateStorageID(testAddress)
+	require.NoError(t, err)
+	assert.Equal(t, NewStorageID(testAddress, goroutines*idsPerGoroutine+1), id)
+}
+
+// TestConcurrentArrayReads reads arrays from multiple goroutines while
+// another goroutine modifies a different array in the same storage.
+// Run with -race to detect data races.
+func TestConcurrentArrayReads(t *testing.T) {
+
+	const readers = 8
+	const arraySize = 2000
+
+	values := make([]Value, arraySize)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	storage := NewConcurrentSlabStorage()
+
+	array := newTestArrayValue(t, storage, values)
+
+	// Lazily loaded array decodes data slabs during concurrent reads
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	lazyStorage := NewConcurrentSlabStorage()
+	lazyArray, err := NewLazyArrayValueFromEncodedData(lazyStorage, b)
+	require.NoError(t, err)
+
+	writeArray := newTestArrayValue(t, storage, nil)
+
+	var wg sync.WaitGroup
+
+	for _, a := range []*ArrayValue{array, lazyArray} {
+		for i := 0; i < readers; i++ {
+			wg.Add(1)
+			go func(a *ArrayValue, offset int) {
+				defer wg.Done()
+
+				for j := 0; j < arraySize; j++ {
+					index := uint32((j + offset*arraySize/readers) % arraySize)
+					v, err := a.Get(index)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if v != values[index] {
+						t.Errorf("Get(%d) returned %v, want %v", index, v, values[index])
+						return
+					}
+				}
+
+				count := 0
+				err := a.Iterate(func(v Value) (bool, error) {
+					if v != values[count] {
+						t.Errorf("element %d is %v, want %v", count, v, values[count])
+						return false, nil
+					}
+					count++
+					return true, nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				if count != arraySize {
+					t.Errorf("iterated %d elements, want %d", count, arraySize)
+				}
+			}(a, i)
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < arraySize; i++ {
+			err := writeArray.Append(UInt32Value(i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		for i := 0; i < arraySize/2; i++ {
+			err := writeArray.Remove(0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	verifyArrayTree(t, array, values)
+	verifyArrayTree(t, writeArray, values[arraySize/2:])
+}
+
+// TestConcurrentHandleReads reads the same array, dictionary and composite
+// through separate handles loaded from the same storage, which share slabs.
+// Run with -race to detect data races.
+func TestConcurrentHandleReads(t *testing.T) {
+
+	const readers = 8
+	const size = 2000
+
+	values := make([]Value, size)
+	entries := make(map[Value]Value)
+	fields := make(map[string]Value)
+	for i := 0; i < size; i++ {
+		values[i] = UInt32Value(i)
+		entries[UInt32Value(i)] = UInt32Value(i)
+		fields[fmt.Sprintf("field%d", i)] = UInt32Value(i)
+	}
+
+	storage := NewConcurrentSlabStorage()
+
+	array := newTestArrayValue(t, storage, values)
+	dictionary := newTestDictionaryValue(t, storage, entries)
+	composite := newTestCompositeValue(t, storage, fields)
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			array, err := NewArrayValueFromStorage(storage, array.ID())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < size; j++ {
+				v, err := array.Get(uint32(j))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if v != values[j] {
+					t.Errorf("Get(%d) returned %v, want %v", j, v, values[j])
+					return
+				}
+			}
+			_, err = array.GetSerizable().Encode()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			dictionary, err := NewDictionaryValueFromStorage(storage, dictionary.metaSlab.ID())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			count := 0
+			err = dictionary.Iterate(func(key Value, value Value) (bool, error) {
+				if value != entries[key] {
+					t.Errorf("value of %v is %v, want %v", key, value, entries[key])
+					return false, nil
+				}
+				count++
+				return true, nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if count != size {
+				t.Errorf("iterated %d entries, want %d", count, size)
+				return
+			}
+			_, err = dictionary.GetSerizable().Encode()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			composite, err := NewCompositeValueFromStorage(storage, composite.ID())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for name, want := range fields {
+				v, found, err := composite.GetField(name)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !found || v != want {
+					t.Errorf("field %s is %v, want %v", name, v, want)
+					return
+				}
+			}
+			_, err = composite.GetSerizable().Encode()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	verifyArrayTree(t, array, values)
+	verifyDictionaryTree(t, dictionary, entries)
+}
@@ -0,0 +1,145 @@
+package main
+
+import "fmt"
+
+// rootSlab is the root slab of a container value stored in slabs.
+// Slabs are shared by all values loaded from the same storage, so they
+// don't reference values or storage. Values pass storage tracking their
+// changes to root slab instead, which retrieves child slabs from it.
+type rootSlab interface {
+	Slab
+
+	// encode encodes root slab with all its child slabs retrieved from storage.
+	encode(storage SlabStorage) ([]byte, error)
+}
+
+// containerKind describes root slab of a kind of container value.
+type containerKind struct {
+	// name and rootName are names of container and its root slab type in errors
+	name     string
+	rootName string
+
+	// asRoot returns slab as root slab of the container,
+	// or false if slab isn't of root slab type.
+	asRoot func(Slab) (rootSlab, bool)
+}
+
+var (
+	arrayKind = &containerKind{
+		name:     "array",
+		rootName: "array meta slab",
+		asRoot:   func(slab Slab) (rootSlab, bool) { root, ok := slab.(*ArrayMetaSlab); return root, ok },
+	}
+	dictionaryKind = &containerKind{
+		name:     "dictionary",
+		rootName: "dictionary meta slab",
+		asRoot:   func(slab Slab) (rootSlab, bool) { root, ok := slab.(*DictionaryMetaSlab); return root, ok },
+	}
+	setKind = &containerKind{
+		name:     "set",
+		rootName: "dictionary meta slab",
+		asRoot:   dictionaryKind.asRoot,
+	}
+	orderedMapKind = &containerKind{
+		name:     "ordered map",
+		rootName: "ordered map meta slab",
+		asRoot:   func(slab Slab) (rootSlab, bool) { root, ok := slab.(*OrderedMapMetaSlab); return root, ok },
+	}
+	compositeKind = &containerKind{
+		name:     "composite",
+		rootName: "composite slab",
+		asRoot:   func(slab Slab) (rootSlab, bool) { root, ok := slab.(*CompositeSlab); return root, ok },
+	}
+)
+
+// retrieveRootSlab retrieves root slab of container kind with id from storage.
+func retrieveRootSlab(storage SlabStorage, id StorageID, kind *containerKind) (rootSlab, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s slab %s not found", kind.name, id)
+	}
+
+	root, ok := kind.asRoot(slab)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not %s", id, slab, kind.rootName)
+	}
+	return root, nil
+}
+
+// slabContainer is embedded in container values stored in slabs.
+type slabContainer struct {
+	// storage tracks slabs changed since last commit
+	storage *trackedSlabStorage
+}
+
+func newSlabContainer(storage SlabStorage) slabContainer {
+	return slabContainer{storage: newTrackedSlabStorage(storage)}
+}
+
+// retrieveRoot retrieves root slab of container value with id, so that
+// the value always operates on the version of root slab visible in
+// storage (e.g. after rollback).
+func (c *slabContainer) retrieveRoot(id StorageID, kind *containerKind) (rootSlab, error) {
+	return retrieveRootSlab(c.storage, id, kind)
+}
+
+// Commit writes copies of slabs created or modified since last commit
+// to storage, and removes slabs deleted since last commit from storage.
+func (c *slabContainer) Commit(storage SlabStorage) error {
+	return c.storage.commit(storage)
+}
+
+// containerValue loads container value nested in another container,
+// ArrayValue or CompositeValue with root slab id, from storage.
+func containerValue(storage SlabStorage, id StorageID) (Value, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("slab %s not found", id)
+	}
+
+	switch slab.(type) {
+	case *ArrayMetaSlab:
+		return NewArrayValueFromStorage(storage, id)
+	case *CompositeSlab:
+		return NewCompositeValueFromStorage(storage, id)
+	default:
+		return nil, fmt.Errorf("slab %s is %T, not root slab of array or composite", id, slab)
+	}
+}
+
+// containerSerializable is Serializable returned by GetSerizable of
+// container value stored in slabs. It encodes root slab of the value
+// with all its child slabs retrieved from storage of the value.
+type containerSerializable struct {
+	root    rootSlab
+	storage SlabStorage
+	value   Value
+}
+
+var _ Serializable = &containerSerializable{}
+
+func (s *containerSerializable) Encode() ([]byte, error) {
+	return s.root.encode(s.storage)
+}
+
+// Decode returns error because container value is decoded with storage
+// holding its slabs, e.g. by NewArrayValueFromEncodedData.
+func (s *containerSerializable) Decode([]byte) error {
+	return fmt.Errorf("slab %s can't be decoded without storage", s.root.ID())
+}
+
+func (s *containerSerializable) ByteSize() uint32 {
+	return s.root.ByteSize()
+}
+
+func (s *containerSerializable) IsConstantSized() bool { return false }
+
+func (s *containerSerializable) GetValue() Value {
+	return s.value
+}
@@ -0,0 +1,1511 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dictionaryMetaSlabChildHeaderSize is the encoded size of each child slab
+// in meta slab, not including its mask: slab index (8 bytes) +
+// slab size (4 bytes) + slab kind (1 byte).
+// Child slabs have the same owner address as meta slab.
+const dictionaryMetaSlabChildHeaderSize = 13
+
+// dictionaryMetaSlabStoredChildHeaderSize is the size of each child slab
+// in meta slab encoded on its own, not including its mask: slab index (8 bytes) +
+// entry count (4 bytes) + slab size (4 bytes).
+const dictionaryMetaSlabStoredChildHeaderSize = 16
+
+const (
+	dictionaryChildSlab          = 0
+	dictionaryChildMetaSlab      = 1
+	dictionaryChildCollisionSlab = 2
+)
+
+// DictionarySlabHeader holds cached slab info. Meta slabs keep a copy of
+// the header of each child slab, which is updated when child slab is stored.
+type DictionarySlabHeader struct {
+	id    StorageID
+	mask  Mask   // keys with hash accepted by mask are in slab
+	count uint32 // number of entries in slab (or in all slabs under a meta slab)
+	size  uint32 // sum of all entry size + map header size (or meta slab header size)
+}
+
+// DictionaryNode is a node of the dictionary trie, either a data slab
+// (DictionarySlab), a meta slab (DictionaryMetaSlab) or a collision slab
+// (DictionaryCollisionSlab) holding keys with the same hash.
+type DictionaryNode interface {
+	Slab
+
+	Header() *DictionarySlabHeader
+
+	// Entries are accessed through storage of dictionary, which retrieves
+	// child slabs of meta slabs and following slabs of collision slabs.
+	// Data slabs don't use it.
+	Get(storage SlabStorage, hash string, key Serializable) (Serializable, bool, error)
+	Set(storage SlabStorage, entry dictionaryEntry) (bool, error)
+	Remove(storage SlabStorage, hash string, key Serializable) (bool, error)
+}
+
+// dictionaryEntry is a key and value pair with hash of encoded key.
+// Entries of SetValue have no value.
+type dictionaryEntry struct {
+	hash  string
+	key   Serializable
+	value Serializable
+}
+
+func (e dictionaryEntry) byteSize() uint32 {
+	if e.value == nil {
+		return e.key.ByteSize()
+	}
+	return e.key.ByteSize() + e.value.ByteSize()
+}
+
+// compareEntries compares entries by key hash, then by encoded key.
+func compareEntries(e1, e2 dictionaryEntry) (int, error) {
+	if e1.hash != e2.hash {
+		if e1.hash < e2.hash {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return compareKeys(e1.key, e2.key)
+}
+
+// hashKey returns hash of encoded key. Slabs are partitioned
+// by masks of key hashes, so keys are evenly distributed among slabs.
+func hashKey(key Serializable) (string, error) {
+	data, err := key.Encode()
+	if err != nil {
+		return "", err
+	}
+	return hashKeyData(data), nil
+}
+
+// hashKeyData returns SHA-256 hash of encoded key.
+// Tests replace it to produce colliding key hashes.
+var hashKeyData = func(data []byte) string {
+	hash := sha256.Sum256(data)
+	return string(hash[:])
+}
+
+// compareKeys compares encoded keys, which are ordered if their hashes are the same.
+func compareKeys(key1, key2 Serializable) (int, error) {
+	data1, err := key1.Encode()
+	if err != nil {
+		return 0, err
+	}
+	data2, err := key2.Encode()
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(data1, data2), nil
+}
+
+// sortEntriesByKey sorts entries by encoded key.
+func sortEntriesByKey(entries []dictionaryEntry) error {
+	keys := make([][]byte, len(entries))
+	for i, e := range entries {
+		data, err := e.key.Encode()
+		if err != nil {
+			return err
+		}
+		keys[i] = data
+	}
+	sort.Sort(entriesByKey{entries: entries, keys: keys})
+	return nil
+}
+
+type entriesByKey struct {
+	entries []dictionaryEntry
+	keys    [][]byte
+}
+
+func (s entriesByKey) Len() int           { return len(s.entries) }
+func (s entriesByKey) Less(i, j int) bool { return bytes.Compare(s.keys[i], s.keys[j]) < 0 }
+func (s entriesByKey) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+// DictionarySlab implements Slab interface.
+// Entries are ordered by key hash, then by encoded key.
+type DictionarySlab struct {
+	header  *DictionarySlabHeader
+	entries []dictionaryEntry
+}
+
+// DictionaryMetaSlab implements Slab interface.
+// The root DictionaryMetaSlab of DictionaryValue or SetValue accepts all
+// keys. Masks of child slabs partition keys accepted by meta slab mask,
+// and children are ordered by mask. Children can be data slabs or meta
+// slabs. Child slabs are retrieved from storage passed by the value
+// accessing the slab, like in ArrayMetaSlab.
+type DictionaryMetaSlab struct {
+	header         *DictionarySlabHeader
+	orderedHeaders list.List
+}
+
+func (d *DictionarySlab) Header() *DictionarySlabHeader {
+	return d.header
+}
+
+func (d *DictionarySlab) headerSize() uint32 {
+	// map head (1 byte) + entry count (4 bytes)
+	return 5
+}
+
+// find returns index of entry with key, or index where entry with key
+// should be inserted if it isn't found.
+func (d *DictionarySlab) find(hash string, key Serializable) (int, bool, error) {
+	var err error
+	i := sort.Search(len(d.entries), func(i int) bool {
+		if d.entries[i].hash != hash {
+			return d.entries[i].hash > hash
+		}
+		c, compareErr := compareKeys(d.entries[i].key, key)
+		if compareErr != nil {
+			err = compareErr
+		}
+		return c >= 0
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if i == len(d.entries) || d.entries[i].hash != hash {
+		return i, false, nil
+	}
+
+	c, err := compareKeys(d.entries[i].key, key)
+	if err != nil {
+		return 0, false, err
+	}
+	return i, c == 0, nil
+}
+
+func (d *DictionarySlab) Get(storage SlabStorage, hash string, key Serializable) (Serializable, bool, error) {
+	i, found, err := d.find(hash, key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	return d.entries[i].value, true, nil
+}
+
+// Set inserts entry, or replaces value of entry with the same key.
+// It returns true if entry is inserted.
+func (d *DictionarySlab) Set(storage SlabStorage, entry dictionaryEntry) (bool, error) {
+	i, found, err := d.find(entry.hash, entry.key)
+	if err != nil {
+		return false, err
+	}
+
+	if found {
+		oldSize := d.entries[i].byteSize()
+		d.entries[i].value = entry.value
+		d.header.size = d.header.size - oldSize + entry.byteSize()
+		return false, nil
+	}
+
+	d.entries = append(d.entries, dictionaryEntry{})
+	copy(d.entries[i+1:], d.entries[i:])
+	d.entries[i] = entry
+
+	d.header.count++
+	d.header.size += entry.byteSize()
+	return true, nil
+}
+
+// Remove removes entry with key. It returns true if entry is removed.
+func (d *DictionarySlab) Remove(storage SlabStorage, hash string, key Serializable) (bool, error) {
+	i, found, err := d.find(hash, key)
+	if err != nil || !found {
+		return false, err
+	}
+
+	size := d.entries[i].byteSize()
+
+	copy(d.entries[i:], d.entries[i+1:])
+	d.entries[len(d.entries)-1] = dictionaryEntry{}
+	d.entries = d.entries[:len(d.entries)-1]
+
+	d.header.count--
+	d.header.size -= size
+	return true, nil
+}
+
+// Split moves entries to a new slab by the first bit where key hashes differ.
+// If all key hashes have the same bit after slab mask, slab mask is extended
+// by that bit and the new slab accepting the other bit is empty, so that
+// slabs still accept all keys accepted by the original slab.
+// It returns nil if entries can't be split, which is the case if all
+// entries have the same key hash.
+func (d *DictionarySlab) Split(storage SlabStorage) (Segmentable, error) {
+	if len(d.entries) < 2 {
+		// Can't split slab with one entry
+		return nil, nil
+	}
+
+	first := d.entries[0].hash
+	last := d.entries[len(d.entries)-1].hash
+
+	left, right, err := NewSplitMasks(d.header.mask, first, last)
+	if errors.Is(err, ErrMaskInputsNotSeparable) {
+		// Keys have the same hash, parent converts slab to collision slab
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := storage.GenerateStorageID(d.header.id.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if left.index-1 > d.header.mask.index {
+		// Extend mask by the bit all keys have
+		bit := inputBit(first, int(d.header.mask.index))
+
+		newSlab := &DictionarySlab{
+			header: &DictionarySlabHeader{
+				id:   id,
+				mask: d.header.mask.child(1 - bit),
+				size: d.headerSize(),
+			},
+		}
+		d.header.mask = d.header.mask.child(bit)
+		return newSlab, nil
+	}
+
+	// Entries are ordered by hash, so entries accepted by right mask follow
+	// entries accepted by left mask.
+	i := sort.Search(len(d.entries), func(i int) bool {
+		return inputBit(d.entries[i].hash, int(right.index-1)) == 1
+	})
+
+	entries := make([]dictionaryEntry, len(d.entries)-i)
+	copy(entries, d.entries[i:])
+
+	newSlab := &DictionarySlab{
+		header: &DictionarySlabHeader{
+			id:    id,
+			mask:  right,
+			count: uint32(len(entries)),
+			size:  d.headerSize(),
+		},
+		entries: entries,
+	}
+	for _, e := range entries {
+		newSlab.header.size += e.byteSize()
+	}
+
+	for j := i; j < len(d.entries); j++ {
+		d.entries[j] = dictionaryEntry{}
+	}
+	d.entries = d.entries[:i]
+	d.header.mask = left
+	d.header.count = uint32(len(d.entries))
+	d.header.size -= newSlab.header.size - d.headerSize()
+
+	return newSlab, nil
+}
+
+// Merge moves entries of sibling slab to this slab.
+// Merged slab accepts keys accepted by both slabs.
+func (d *DictionarySlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*DictionarySlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into dictionary slab", s)
+	}
+	if !d.header.mask.isSibling(slab2.header.mask) {
+		return errors.New("can't merge dictionary slabs which aren't siblings")
+	}
+
+	if slab2.header.mask.less(d.header.mask) {
+		d.entries = append(append([]dictionaryEntry(nil), slab2.entries...), d.entries...)
+	} else {
+		d.entries = append(d.entries, slab2.entries...)
+	}
+
+	d.header.mask = d.header.mask.parent()
+	d.header.count += slab2.header.count
+	d.header.size += slab2.header.size - d.headerSize()
+	return nil
+}
+
+func (d *DictionarySlab) Clone() Slab {
+	header := *d.header
+	return &DictionarySlab{
+		header:  &header,
+		entries: append([]dictionaryEntry(nil), d.entries...),
+	}
+}
+
+func (d *DictionarySlab) ID() StorageID {
+	return d.header.id
+}
+
+// Encode encodes entries as CBOR map with keys and values in order.
+// Slab mask is encoded by parent meta slab.
+func (d *DictionarySlab) Encode() ([]byte, error) {
+	return encodeDictionaryEntries(d.entries, d.ByteSize())
+}
+
+func (d *DictionarySlab) Decode(data []byte) error {
+	entries, rest, err := decodeDictionaryEntries(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("wrong byte size for dictionary slab")
+	}
+
+	d.entries = entries
+	d.header.count = uint32(len(entries))
+	d.header.size = uint32(len(data))
+	return nil
+}
+
+// encodeDictionaryEntries encodes entries as CBOR map with keys and values in order.
+// Entries without value (of SetValue) are encoded as CBOR array of keys.
+func encodeDictionaryEntries(entries []dictionaryEntry, size uint32) ([]byte, error) {
+	buf := make([]byte, 5, size)
+
+	keysOnly := len(entries) > 0 && entries[0].value == nil
+
+	// Map head, or array head if entries have no value
+	buf[0] = 0xa0 | byte(26)
+	if keysOnly {
+		buf[0] = 0x80 | byte(26)
+	}
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(entries)))
+
+	for _, e := range entries {
+		b, err := e.key.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+
+		if keysOnly {
+			continue
+		}
+
+		b, err = e.value.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+// decodeDictionaryEntries decodes entries encoded by encodeDictionaryEntries
+// and returns the remaining data.
+func decodeDictionaryEntries(data []byte) ([]dictionaryEntry, []byte, error) {
+	if len(data) < 5 {
+		return nil, nil, errors.New("wrong byte size for dictionary slab")
+	}
+	if data[0] != 0xa0|byte(26) && data[0] != 0x80|byte(26) {
+		return nil, nil, errors.New("wrong data for dictionary slab")
+	}
+
+	keysOnly := data[0] == 0x80|byte(26)
+
+	count := binary.BigEndian.Uint32(data[1:])
+
+	data = data[5:]
+	entries := make([]dictionaryEntry, count)
+	for i := 0; i < int(count); i++ {
+		var err error
+		var e dictionaryEntry
+
+		e.key, data, err = decodeSerializable(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !keysOnly {
+			e.value, data, err = decodeSerializable(data)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		e.hash, err = hashKey(e.key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entries[i] = e
+	}
+
+	return entries, data, nil
+}
+
+// encodeSlab encodes slab mask followed by encoded entries.
+// It is used to store slab on its own.
+func (d *DictionarySlab) encodeSlab() ([]byte, error) {
+	data, err := d.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, d.header.mask.encodedSize(), int(d.header.mask.encodedSize())+len(data))
+	d.header.mask.encode(buf)
+	return append(buf, data...), nil
+}
+
+// decodeSlab decodes slab encoded by encodeSlab.
+func (d *DictionarySlab) decodeSlab(data []byte) error {
+	mask, n, err := decodeMask(data)
+	if err != nil {
+		return err
+	}
+	d.header.mask = mask
+	return d.Decode(data[n:])
+}
+
+func (d *DictionarySlab) ByteSize() uint32 {
+	return d.header.size
+}
+
+func (d *DictionarySlab) IsConstantSized() bool { return false }
+
+// GetValue returns nil because data slab holds only entries with the same
+// mask prefix. DictionaryValue is returned by GetValue of Serializable
+// returned by its GetSerizable.
+func (d *DictionarySlab) GetValue() Value {
+	return nil
+}
+
+func newDictionaryMetaSlab(storage SlabStorage, address Address, mask Mask) (*DictionaryMetaSlab, error) {
+	id, err := storage.GenerateStorageID(address)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &DictionaryMetaSlab{header: &DictionarySlabHeader{id: id, mask: mask}}
+	meta.header.size = meta.headerSize()
+	return meta, nil
+}
+
+// GetValue returns nil because meta slab is shared by values loaded from
+// storage. DictionaryValue and SetValue are returned by GetValue of
+// Serializable returned by their GetSerizable.
+func (d *DictionaryMetaSlab) GetValue() Value {
+	return nil
+}
+
+func (d *DictionaryMetaSlab) IsConstantSized() bool { return false }
+
+func (d *DictionaryMetaSlab) ID() StorageID {
+	return d.header.id
+}
+
+func (d *DictionaryMetaSlab) Header() *DictionarySlabHeader {
+	return d.header
+}
+
+func (d *DictionaryMetaSlab) headerSize() uint32 {
+	// address (8 bytes) + index (8 bytes) + slab count (4 bytes)
+	return 20
+}
+
+func (d *DictionaryMetaSlab) Clone() Slab {
+	header := *d.header
+	meta := &DictionaryMetaSlab{header: &header}
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		h := *e.Value.(*DictionarySlabHeader)
+		meta.orderedHeaders.PushBack(&h)
+	}
+	meta.updateHeader()
+	return meta
+}
+
+// getSlab retrieves child slab with given header from storage.
+func (d *DictionaryMetaSlab) getSlab(storage SlabStorage, header *DictionarySlabHeader) (DictionaryNode, error) {
+	slab, found, err := storage.Retrieve(header.id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("dictionary slab %s not found", header.id)
+	}
+	node, ok := slab.(DictionaryNode)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not dictionary slab", header.id, slab)
+	}
+	return node, nil
+}
+
+// getSlabForUpdate retrieves child slab which is about to be modified,
+// so that snapshots can preserve its current version.
+func (d *DictionaryMetaSlab) getSlabForUpdate(storage SlabStorage, header *DictionarySlabHeader) (DictionaryNode, error) {
+	slab, err := d.getSlab(storage, header)
+	if err != nil {
+		return nil, err
+	}
+	if tracked, ok := storage.(*trackedSlabStorage); ok {
+		tracked.beforeUpdate(slab)
+	}
+	return slab, nil
+}
+
+// storeSlab stores modified child slab and updates its header at headerElement.
+func (d *DictionaryMetaSlab) storeSlab(storage SlabStorage, headerElement *list.Element, slab DictionaryNode) {
+	header := *slab.Header()
+	headerElement.Value = &header
+	storage.Store(slab)
+}
+
+// updateHeader recomputes entry count and meta slab size from child headers.
+// It must be called whenever children are modified.
+func (d *DictionaryMetaSlab) updateHeader() {
+	count := uint32(0)
+	size := d.headerSize()
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*DictionarySlabHeader)
+		count += header.count
+		size += dictionaryMetaSlabChildHeaderSize + header.mask.encodedSize()
+	}
+	d.header.count = count
+	d.header.size = size
+}
+
+func (d *DictionaryMetaSlab) GetCount() uint32 {
+	return d.header.count
+}
+
+// childFor returns list element of child slab accepting key hash.
+func (d *DictionaryMetaSlab) childFor(hash string) (*list.Element, error) {
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		member, err := e.Value.(*DictionarySlabHeader).mask.IsMember(hash)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("dictionary slab for key hash %x not found", hash)
+}
+
+func (d *DictionaryMetaSlab) Get(storage SlabStorage, hash string, key Serializable) (Serializable, bool, error) {
+	if d.orderedHeaders.Len() == 0 {
+		return nil, false, nil
+	}
+
+	e, err := d.childFor(hash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	slab, err := d.getSlab(storage, e.Value.(*DictionarySlabHeader))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return slab.Get(storage, hash, key)
+}
+
+func (d *DictionaryMetaSlab) Set(storage SlabStorage, entry dictionaryEntry) (bool, error) {
+	if d.orderedHeaders.Len() == 0 {
+		// Create the first data slab accepting all keys accepted by meta slab
+		id, err := storage.GenerateStorageID(d.header.id.Address)
+		if err != nil {
+			return false, err
+		}
+		slab := &DictionarySlab{header: &DictionarySlabHeader{id: id, mask: d.header.mask}}
+		slab.header.size = slab.headerSize()
+
+		d.storeSlab(storage, d.orderedHeaders.PushBack(nil), slab)
+	}
+
+	e, err := d.childFor(entry.hash)
+	if err != nil {
+		return false, err
+	}
+
+	slab, err := d.getSlabForUpdate(storage, e.Value.(*DictionarySlabHeader))
+	if err != nil {
+		return false, err
+	}
+
+	// Split collision slab until key is accepted by a data slab
+	for {
+		collision, ok := slab.(*DictionaryCollisionSlab)
+		if !ok || collision.hash == entry.hash {
+			break
+		}
+
+		err = d.separate(storage, e, collision)
+		if err != nil {
+			return false, err
+		}
+
+		e, err = d.childFor(entry.hash)
+		if err != nil {
+			return false, err
+		}
+
+		slab, err = d.getSlabForUpdate(storage, e.Value.(*DictionarySlabHeader))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	inserted, err := slab.Set(storage, entry)
+	if err != nil {
+		return false, err
+	}
+
+	d.storeSlab(storage, e, slab)
+
+	return inserted, d.rebalance(storage, e, slab)
+}
+
+func (d *DictionaryMetaSlab) Remove(storage SlabStorage, hash string, key Serializable) (bool, error) {
+	if d.orderedHeaders.Len() == 0 {
+		return false, nil
+	}
+
+	e, err := d.childFor(hash)
+	if err != nil {
+		return false, err
+	}
+
+	slab, err := d.getSlabForUpdate(storage, e.Value.(*DictionarySlabHeader))
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := slab.Remove(storage, hash, key)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	d.storeSlab(storage, e, slab)
+
+	return true, d.rebalance(storage, e, slab)
+}
+
+// isUnderflow returns true if child slab should be merged with its sibling.
+// Meta slab with only one child is replaced by its child.
+func (d *DictionaryMetaSlab) isUnderflow(slab DictionaryNode) bool {
+	if meta, ok := slab.(*DictionaryMetaSlab); ok && meta.orderedHeaders.Len() < 2 {
+		return true
+	}
+	return slab.Header().size < minThreshold
+}
+
+// rebalance splits or merges modified child slab if its size
+// exceeds maxThreshold or falls below minThreshold.
+func (d *DictionaryMetaSlab) rebalance(storage SlabStorage, headerElement *list.Element, slab DictionaryNode) error {
+	err := d.rebalanceChild(storage, headerElement, slab)
+	if err != nil {
+		return err
+	}
+	d.updateHeader()
+	return nil
+}
+
+func (d *DictionaryMetaSlab) rebalanceChild(storage SlabStorage, headerElement *list.Element, slab DictionaryNode) error {
+	if collision, ok := slab.(*DictionaryCollisionSlab); ok {
+		return d.rebalanceCollisionSlab(storage, headerElement, collision)
+	}
+	if slab.Header().size > maxThreshold {
+		return d.split(storage, headerElement, slab)
+	}
+	return d.merge(storage, headerElement, slab)
+}
+
+// updateRoot adjusts trie height after root slab is modified, and stores
+// root slab. Other meta slabs are stored by their parent after they're
+// modified.
+func (d *DictionaryMetaSlab) updateRoot(storage SlabStorage) error {
+	if d.header.size > maxThreshold {
+		err := d.splitRoot(storage)
+		if err != nil {
+			return err
+		}
+	} else if d.orderedHeaders.Len() == 1 {
+		err := d.collapseRoot(storage)
+		if err != nil {
+			return err
+		}
+	}
+
+	storage.Store(d)
+	return nil
+}
+
+// splitRoot moves all children of root to a new meta slab and splits it.
+// Root keeps its StorageID.
+func (d *DictionaryMetaSlab) splitRoot(storage SlabStorage) error {
+	child, err := newDictionaryMetaSlab(storage, d.header.id.Address, d.header.mask)
+	if err != nil {
+		return err
+	}
+	child.orderedHeaders.PushBackList(&d.orderedHeaders)
+	child.updateHeader()
+
+	d.orderedHeaders.Init()
+	e := d.orderedHeaders.PushBack(nil)
+	d.storeSlab(storage, e, child)
+
+	err = d.split(storage, e, child)
+	if err != nil {
+		return err
+	}
+
+	d.updateHeader()
+	return nil
+}
+
+// collapseRoot moves children of root's only child to root
+// if the child is a meta slab.
+func (d *DictionaryMetaSlab) collapseRoot(storage SlabStorage) error {
+	slab, err := d.getSlab(storage, d.orderedHeaders.Front().Value.(*DictionarySlabHeader))
+	if err != nil {
+		return err
+	}
+	child, ok := slab.(*DictionaryMetaSlab)
+	if !ok {
+		return nil
+	}
+
+	d.orderedHeaders.Init()
+	d.orderedHeaders.PushBackList(&child.orderedHeaders)
+
+	storage.Remove(child.ID())
+
+	d.updateHeader()
+	return nil
+}
+
+// Split moves children accepting the bit following meta slab mask
+// to a new meta slab. Children of meta slab with at least two children
+// have masks longer than meta slab mask, so both slabs have children.
+func (d *DictionaryMetaSlab) Split(storage SlabStorage) (Segmentable, error) {
+	if d.orderedHeaders.Len() < 2 {
+		// Can't split meta slab with one child
+		return nil, nil
+	}
+
+	left := d.header.mask.child(0)
+	right := d.header.mask.child(1)
+
+	newSlab, err := newDictionaryMetaSlab(storage, d.header.id.Address, right)
+	if err != nil {
+		return nil, err
+	}
+
+	// Children are ordered by mask, so children accepted by right mask
+	// follow children accepted by left mask.
+	e := d.orderedHeaders.Front()
+	for e != nil && !right.contains(e.Value.(*DictionarySlabHeader).mask) {
+		e = e.Next()
+	}
+	for e != nil {
+		next := e.Next()
+		newSlab.orderedHeaders.PushBack(d.orderedHeaders.Remove(e))
+		e = next
+	}
+
+	d.header.mask = left
+	d.updateHeader()
+	newSlab.updateHeader()
+
+	return newSlab, nil
+}
+
+// Merge moves children of sibling meta slab to this meta slab.
+func (d *DictionaryMetaSlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*DictionaryMetaSlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into dictionary meta slab", s)
+	}
+	if !d.header.mask.isSibling(slab2.header.mask) {
+		return errors.New("can't merge dictionary meta slabs which aren't siblings")
+	}
+
+	if slab2.header.mask.less(d.header.mask) {
+		d.orderedHeaders.PushFrontList(&slab2.orderedHeaders)
+	} else {
+		d.orderedHeaders.PushBackList(&slab2.orderedHeaders)
+	}
+
+	d.header.mask = d.header.mask.parent()
+	d.updateHeader()
+	return nil
+}
+
+// split splits child slab at headerElement, and rebalances both slabs
+// since either can still exceed maxThreshold.
+func (d *DictionaryMetaSlab) split(storage SlabStorage, headerElement *list.Element, slab DictionaryNode) error {
+	newSegment, err := slab.Split(storage)
+	if err != nil {
+		return err
+	}
+	if newSegment == nil {
+		if data, ok := slab.(*DictionarySlab); ok && len(data.entries) > 1 {
+			// Entries have the same key hash
+			return d.convertToCollisionSlab(storage, headerElement, data)
+		}
+		return nil
+	}
+	newSlab := newSegment.(DictionaryNode)
+
+	d.storeSlab(storage, headerElement, slab)
+
+	var newElement *list.Element
+	if newSlab.Header().mask.less(slab.Header().mask) {
+		newElement = d.orderedHeaders.InsertBefore(nil, headerElement)
+	} else {
+		newElement = d.orderedHeaders.InsertAfter(nil, headerElement)
+	}
+	d.storeSlab(storage, newElement, newSlab)
+
+	err = d.rebalanceChild(storage, headerElement, slab)
+	if err != nil {
+		return err
+	}
+	return d.rebalanceChild(storage, newElement, newSlab)
+}
+
+// convertToCollisionSlab replaces data slab holding entries with the same
+// key hash with a collision slab, since masks can't separate its entries.
+func (d *DictionaryMetaSlab) convertToCollisionSlab(storage SlabStorage, headerElement *list.Element, slab *DictionarySlab) error {
+	collision, err := newDictionaryCollisionSlab(storage, slab)
+	if err != nil {
+		return err
+	}
+	d.storeSlab(storage, headerElement, collision)
+	return nil
+}
+
+// rebalanceCollisionSlab replaces collision slab with a data slab if entries
+// of all slabs in chain don't exceed maxThreshold. Data slab can then be
+// merged with its sibling.
+func (d *DictionaryMetaSlab) rebalanceCollisionSlab(storage SlabStorage, headerElement *list.Element, slab *DictionaryCollisionSlab) error {
+	entries, size, err := slab.chainEntries(storage)
+	if err != nil {
+		return err
+	}
+	if size > maxThreshold {
+		return nil
+	}
+
+	// Entries of data slab with the same hash are ordered by encoded key
+	err = sortEntriesByKey(entries)
+	if err != nil {
+		return err
+	}
+
+	err = slab.removeChain(storage)
+	if err != nil {
+		return err
+	}
+
+	header := *slab.header
+	header.size = size
+
+	data := &DictionarySlab{header: &header, entries: entries}
+	d.storeSlab(storage, headerElement, data)
+
+	return d.merge(storage, headerElement, data)
+}
+
+// separate splits collision slab at headerElement by the next bit of its
+// key hash, so that keys with different hash accepted by collision slab
+// mask are accepted by the new empty data slab or further splits.
+func (d *DictionaryMetaSlab) separate(storage SlabStorage, headerElement *list.Element, slab *DictionaryCollisionSlab) error {
+	newSegment, err := slab.Split(storage)
+	if err != nil {
+		return err
+	}
+	if newSegment == nil {
+		return errors.New("can't split dictionary collision slab with all bits of key hash")
+	}
+	newSlab := newSegment.(DictionaryNode)
+
+	d.storeSlab(storage, headerElement, slab)
+
+	if newSlab.Header().mask.less(slab.header.mask) {
+		d.storeSlab(storage, d.orderedHeaders.InsertBefore(nil, headerElement), newSlab)
+	} else {
+		d.storeSlab(storage, d.orderedHeaders.InsertAfter(nil, headerElement), newSlab)
+	}
+	return nil
+}
+
+// merge replaces child meta slab having one child with its child,
+// or merges child slab with its sibling if either slab underflows and
+// merged slab doesn't exceed maxThreshold. Sibling of child slab can be
+// split further or have different slab type, in which case child slab
+// isn't merged.
+func (d *DictionaryMetaSlab) merge(storage SlabStorage, headerElement *list.Element, slab DictionaryNode) error {
+	if meta, ok := slab.(*DictionaryMetaSlab); ok && meta.orderedHeaders.Len() == 1 {
+		// Only child accepts the same keys as meta slab
+		header := *meta.orderedHeaders.Front().Value.(*DictionarySlabHeader)
+		headerElement.Value = &header
+
+		storage.Remove(meta.ID())
+
+		child, err := d.getSlab(storage, &header)
+		if err != nil {
+			return err
+		}
+
+		// Child can be merged with its new sibling
+		return d.rebalanceChild(storage, headerElement, child)
+	}
+
+	mask := slab.Header().mask
+
+	var siblingElement *list.Element
+	if prev := headerElement.Prev(); prev != nil && prev.Value.(*DictionarySlabHeader).mask.isSibling(mask) {
+		siblingElement = prev
+	} else if next := headerElement.Next(); next != nil && next.Value.(*DictionarySlabHeader).mask.isSibling(mask) {
+		siblingElement = next
+	} else {
+		return nil
+	}
+
+	if !d.isUnderflow(slab) && siblingElement.Value.(*DictionarySlabHeader).size >= minThreshold {
+		return nil
+	}
+
+	sibling, err := d.getSlabForUpdate(storage, siblingElement.Value.(*DictionarySlabHeader))
+	if err != nil {
+		return err
+	}
+
+	switch slab := slab.(type) {
+	case *DictionarySlab:
+		if _, ok := sibling.(*DictionarySlab); !ok {
+			return nil
+		}
+		if slab.header.size+sibling.Header().size-slab.headerSize() > maxThreshold {
+			return nil
+		}
+	case *DictionaryMetaSlab:
+		if _, ok := sibling.(*DictionaryMetaSlab); !ok {
+			return nil
+		}
+		if slab.header.size+sibling.Header().size-slab.headerSize() > maxThreshold {
+			return nil
+		}
+	}
+
+	err = slab.Merge(sibling)
+	if err != nil {
+		return err
+	}
+
+	d.storeSlab(storage, headerElement, slab)
+
+	d.orderedHeaders.Remove(siblingElement)
+	storage.Remove(sibling.ID())
+
+	// Merged slab can be merged with its new sibling
+	return d.rebalanceChild(storage, headerElement, slab)
+}
+
+// Encode encodes meta slab on its own (see encodeSlab), because child
+// slabs are retrieved from storage passed to encode.
+func (d *DictionaryMetaSlab) Encode() ([]byte, error) {
+	return d.encodeSlab()
+}
+
+// encode encodes meta slab header followed by encoded data of all child slabs.
+// Slab size of each child is the length of child's encoded data.
+func (d *DictionaryMetaSlab) encode(storage SlabStorage) ([]byte, error) {
+	buf := make([]byte, d.headerSize(), d.ByteSize())
+
+	// Write metaslab address (8 bytes) and index (8 bytes)
+	copy(buf, d.header.id.Address[:])
+	binary.BigEndian.PutUint64(buf[8:], d.header.id.Index)
+
+	// Write number of slabs (4 bytes)
+	binary.BigEndian.PutUint32(buf[16:], uint32(d.orderedHeaders.Len()))
+
+	children := make([][]byte, 0, d.orderedHeaders.Len())
+
+	// For each slab, write slab index (8 bytes), slab size (4 bytes),
+	// slab kind (1 byte) and mask
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*DictionarySlabHeader)
+
+		slab, err := d.getSlab(storage, header)
+		if err != nil {
+			return nil, err
+		}
+
+		var b []byte
+		kind := byte(dictionaryChildSlab)
+		switch slab := slab.(type) {
+		case *DictionaryMetaSlab:
+			kind = dictionaryChildMetaSlab
+			b, err = slab.encode(storage)
+		case *DictionaryCollisionSlab:
+			kind = dictionaryChildCollisionSlab
+			b, err = slab.encode(storage)
+		default:
+			b, err = slab.Encode()
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, b)
+
+		childHeader := make([]byte, dictionaryMetaSlabChildHeaderSize+header.mask.encodedSize())
+		binary.BigEndian.PutUint64(childHeader, header.id.Index)
+		binary.BigEndian.PutUint32(childHeader[8:], uint32(len(b)))
+		childHeader[12] = kind
+		header.mask.encode(childHeader[dictionaryMetaSlabChildHeaderSize:])
+
+		buf = append(buf, childHeader...)
+	}
+
+	for _, b := range children {
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+// Decode decodes meta slab encoded on its own (see decodeSlab).
+func (d *DictionaryMetaSlab) Decode(data []byte) error {
+	return d.decodeSlab(data)
+}
+
+// decode decodes meta slab and all its child slabs encoded by encode,
+// and stores decoded child slabs in storage.
+func (d *DictionaryMetaSlab) decode(storage SlabStorage, data []byte) error {
+	if len(data) < int(d.headerSize()) {
+		return errors.New("too short for dictionary meta slab")
+	}
+
+	var address Address
+	copy(address[:], data)
+	d.header.id = NewStorageID(address, binary.BigEndian.Uint64(data[8:]))
+
+	slabCount := binary.BigEndian.Uint32(data[16:])
+
+	type childSlabData struct {
+		index uint64
+		size  uint32
+		kind  byte
+		mask  Mask
+	}
+
+	slabData := make([]childSlabData, slabCount)
+
+	index := int(d.headerSize())
+	for i := 0; i < int(slabCount); i++ {
+		if len(data) < index+dictionaryMetaSlabChildHeaderSize {
+			return errors.New("too short for dictionary meta slab")
+		}
+		slabData[i].index = binary.BigEndian.Uint64(data[index:])
+		slabData[i].size = binary.BigEndian.Uint32(data[index+8:])
+		slabData[i].kind = data[index+12]
+		index += dictionaryMetaSlabChildHeaderSize
+
+		mask, n, err := decodeMask(data[index:])
+		if err != nil {
+			return err
+		}
+		slabData[i].mask = mask
+		index += n
+	}
+
+	for _, sd := range slabData {
+		if len(data) < index+int(sd.size) {
+			return errors.New("too short for dictionary meta slab")
+		}
+
+		header := &DictionarySlabHeader{id: NewStorageID(address, sd.index), mask: sd.mask}
+
+		childData := data[index : index+int(sd.size)]
+
+		var slab DictionaryNode
+		var err error
+		switch sd.kind {
+		case dictionaryChildMetaSlab:
+			meta := &DictionaryMetaSlab{header: header}
+			err = meta.decode(storage, childData)
+			slab = meta
+		case dictionaryChildSlab:
+			slab = &DictionarySlab{header: header}
+			err = slab.Decode(childData)
+		case dictionaryChildCollisionSlab:
+			collision := &DictionaryCollisionSlab{header: header}
+			err = collision.decode(storage, childData)
+			slab = collision
+		default:
+			return fmt.Errorf("unknown dictionary slab kind %d", sd.kind)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Decoded meta slab id and mask are the same as in child header
+		header.id = NewStorageID(address, sd.index)
+		header.mask = sd.mask
+
+		storage.Store(slab)
+
+		index += int(sd.size)
+
+		h := *header
+		d.orderedHeaders.PushBack(&h)
+	}
+
+	d.updateHeader()
+
+	return nil
+}
+
+// encodeSlab encodes meta slab header followed by the header of each child
+// slab, without child slabs. It is used to store meta slab on its own.
+func (d *DictionaryMetaSlab) encodeSlab() ([]byte, error) {
+	buf := make([]byte, d.headerSize()+d.header.mask.encodedSize())
+
+	// Write metaslab address (8 bytes), index (8 bytes),
+	// number of slabs (4 bytes) and mask
+	copy(buf, d.header.id.Address[:])
+	binary.BigEndian.PutUint64(buf[8:], d.header.id.Index)
+	binary.BigEndian.PutUint32(buf[16:], uint32(d.orderedHeaders.Len()))
+	d.header.mask.encode(buf[d.headerSize():])
+
+	// For each slab, write slab index (8 bytes), entry count (4 bytes),
+	// slab size (4 bytes) and mask
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*DictionarySlabHeader)
+
+		childHeader := make([]byte, dictionaryMetaSlabStoredChildHeaderSize+header.mask.encodedSize())
+		binary.BigEndian.PutUint64(childHeader, header.id.Index)
+		binary.BigEndian.PutUint32(childHeader[8:], header.count)
+		binary.BigEndian.PutUint32(childHeader[12:], header.size)
+		header.mask.encode(childHeader[dictionaryMetaSlabStoredChildHeaderSize:])
+
+		buf = append(buf, childHeader...)
+	}
+
+	return buf, nil
+}
+
+// decodeSlab decodes meta slab encoded by encodeSlab.
+// Child slabs are retrieved from storage on access.
+func (d *DictionaryMetaSlab) decodeSlab(data []byte) error {
+	if len(data) < int(d.headerSize()) {
+		return errors.New("too short for dictionary meta slab")
+	}
+
+	var address Address
+	copy(address[:], data)
+	d.header.id = NewStorageID(address, binary.BigEndian.Uint64(data[8:]))
+
+	slabCount := binary.BigEndian.Uint32(data[16:])
+
+	mask, n, err := decodeMask(data[d.headerSize():])
+	if err != nil {
+		return err
+	}
+	d.header.mask = mask
+
+	offset := int(d.headerSize()) + n
+	for i := 0; i < int(slabCount); i++ {
+		if len(data) < offset+dictionaryMetaSlabStoredChildHeaderSize {
+			return errors.New("too short for dictionary meta slab")
+		}
+
+		header := &DictionarySlabHeader{
+			id:    NewStorageID(address, binary.BigEndian.Uint64(data[offset:])),
+			count: binary.BigEndian.Uint32(data[offset+8:]),
+			size:  binary.BigEndian.Uint32(data[offset+12:]),
+		}
+		offset += dictionaryMetaSlabStoredChildHeaderSize
+
+		header.mask, n, err = decodeMask(data[offset:])
+		if err != nil {
+			return err
+		}
+		offset += n
+
+		d.orderedHeaders.PushBack(header)
+	}
+
+	if offset != len(data) {
+		return errors.New("wrong byte size for dictionary meta slab")
+	}
+
+	d.updateHeader()
+
+	return nil
+}
+
+// ByteSize returns encoded size of meta slab header and child headers,
+// not including child slabs.
+func (d *DictionaryMetaSlab) ByteSize() uint32 {
+	return d.header.size
+}
+
+// Iterate calls fn for each entry in order of key hash
+// until fn returns false or error.
+func (d *DictionaryMetaSlab) Iterate(storage SlabStorage, fn func(key Serializable, value Serializable) (bool, error)) error {
+	_, err := d.iterate(storage, fn)
+	return err
+}
+
+func (d *DictionaryMetaSlab) iterate(storage SlabStorage, fn func(key Serializable, value Serializable) (bool, error)) (bool, error) {
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		node, err := d.getSlab(storage, e.Value.(*DictionarySlabHeader))
+		if err != nil {
+			return false, err
+		}
+
+		switch slab := node.(type) {
+		case *DictionaryMetaSlab:
+			resume, err := slab.iterate(storage, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
+
+		case *DictionarySlab:
+			// Copy entries so fn can modify the dictionary without affecting iteration
+			entries := append([]dictionaryEntry(nil), slab.entries...)
+			for _, entry := range entries {
+				resume, err := fn(entry.key, entry.value)
+				if err != nil || !resume {
+					return resume, err
+				}
+			}
+
+		case *DictionaryCollisionSlab:
+			entries, _, err := slab.chainEntries(storage)
+			if err != nil {
+				return false, err
+			}
+			for _, entry := range entries {
+				resume, err := fn(entry.key, entry.value)
+				if err != nil || !resume {
+					return resume, err
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+// Print is intended for debugging purpose only
+func (d *DictionaryMetaSlab) Print(storage SlabStorage) {
+	fmt.Println("============= dictionary slabs ================")
+	d.print(storage, 0)
+	fmt.Println("===============================================")
+}
+
+func (d *DictionaryMetaSlab) print(storage SlabStorage, level int) {
+	indent := strings.Repeat("  ", level)
+	i := 0
+	for e := d.orderedHeaders.Front(); e != nil; e = e.Next() {
+		h := e.Value.(*DictionarySlabHeader)
+		node, err := d.getSlab(storage, h)
+		if err != nil {
+			fmt.Printf("%sslab %d, id %s, error %v\n", indent, i, h.id, err)
+			i++
+			continue
+		}
+		switch slab := node.(type) {
+		case *DictionaryMetaSlab:
+			fmt.Printf("%smeta slab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			slab.print(storage, level+1)
+		case *DictionarySlab:
+			fmt.Printf("%sslab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			printDictionaryEntries(indent, slab.entries)
+		case *DictionaryCollisionSlab:
+			fmt.Printf("%scollision slab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			entries, _, err := slab.chainEntries(storage)
+			if err != nil {
+				fmt.Printf("%serror %v\n", indent, err)
+				break
+			}
+			printDictionaryEntries(indent, entries)
+		}
+		i++
+	}
+}
+
+func printDictionaryEntries(indent string, entries []dictionaryEntry) {
+	fmt.Printf("%s[", indent)
+	for _, e := range entries {
+		if e.value == nil {
+			fmt.Printf("%[1]v (%[1]T), ", e.key.GetValue())
+			continue
+		}
+		fmt.Printf("%[1]v (%[1]T): %[2]v (%[2]T), ", e.key.GetValue(), e.value.GetValue())
+	}
+	fmt.Printf("]\n")
+}
+
+// dictionaryIterator returns entries of dictionary trie in order of key hash,
+// then encoded key. Entries of one data slab (or collision slab chain) are
+// loaded at a time, so dictionary isn't loaded in memory.
+// Dictionary must not be modified during iteration.
+type dictionaryIterator struct {
+	storage SlabStorage
+
+	// metas are meta slabs from root to the current meta slab,
+	// and path holds the current child element of each meta slab.
+	metas []*DictionaryMetaSlab
+	path  []*list.Element
+
+	entries []dictionaryEntry
+	index   int
+}
+
+func newDictionaryIterator(storage SlabStorage, root *DictionaryMetaSlab) *dictionaryIterator {
+	return &dictionaryIterator{
+		storage: storage,
+		metas:   []*DictionaryMetaSlab{root},
+		path:    []*list.Element{nil},
+	}
+}
+
+// next returns the next entry, or false if all entries are returned.
+func (it *dictionaryIterator) next() (dictionaryEntry, bool, error) {
+	for it.index == len(it.entries) {
+		found, err := it.loadNextSlab()
+		if err != nil || !found {
+			return dictionaryEntry{}, false, err
+		}
+	}
+	e := it.entries[it.index]
+	it.index++
+	return e, true, nil
+}
+
+// loadNextSlab loads entries of the next data slab or collision slab chain.
+// It returns false if there isn't next slab.
+func (it *dictionaryIterator) loadNextSlab() (bool, error) {
+	for len(it.metas) > 0 {
+		last := len(it.metas) - 1
+		meta := it.metas[last]
+
+		e := it.path[last]
+		if e == nil {
+			e = meta.orderedHeaders.Front()
+		} else {
+			e = e.Next()
+		}
+		if e == nil {
+			// All children of meta slab are iterated
+			it.metas = it.metas[:last]
+			it.path = it.path[:last]
+			continue
+		}
+		it.path[last] = e
+
+		node, err := meta.getSlab(it.storage, e.Value.(*DictionarySlabHeader))
+		if err != nil {
+			return false, err
+		}
+
+		switch slab := node.(type) {
+		case *DictionaryMetaSlab:
+			it.metas = append(it.metas, slab)
+			it.path = append(it.path, nil)
+
+		case *DictionarySlab:
+			it.entries = append(it.entries[:0], slab.entries...)
+			it.index = 0
+			return true, nil
+
+		case *DictionaryCollisionSlab:
+			entries, _, err := slab.chainEntries(it.storage)
+			if err != nil {
+				return false, err
+			}
+			// Entries of collision slab are kept in order of insertion
+			err = sortEntriesByKey(entries)
+			if err != nil {
+				return false, err
+			}
+			it.entries = entries
+			it.index = 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mergeDictionaries calls fn with entries of both dictionary tries in order
+// of key hash, then encoded key. Entry with the same key in both tries is
+// passed once, with the value from root1. in1 and in2 report which tries
+// have the entry's key. Slabs of each trie are retrieved from its storage.
+func mergeDictionaries(
+	storage1 SlabStorage,
+	root1 *DictionaryMetaSlab,
+	storage2 SlabStorage,
+	root2 *DictionaryMetaSlab,
+	fn func(entry dictionaryEntry, in1, in2 bool) error,
+) error {
+	it1 := newDictionaryIterator(storage1, root1)
+	it2 := newDictionaryIterator(storage2, root2)
+
+	e1, ok1, err := it1.next()
+	if err != nil {
+		return err
+	}
+	e2, ok2, err := it2.next()
+	if err != nil {
+		return err
+	}
+
+	for ok1 || ok2 {
+		var c int
+		switch {
+		case !ok2:
+			c = -1
+		case !ok1:
+			c = 1
+		default:
+			c, err = compareEntries(e1, e2)
+			if err != nil {
+				return err
+			}
+		}
+
+		if c <= 0 {
+			err = fn(e1, true, c == 0)
+		} else {
+			err = fn(e2, false, true)
+		}
+		if err != nil {
+			return err
+		}
+
+		if c <= 0 {
+			e1, ok1, err = it1.next()
+			if err != nil {
+				return err
+			}
+		}
+		if c >= 0 {
+			e2, ok2, err = it2.next()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
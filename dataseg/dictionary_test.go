@@ -0,0 +1,501 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDictionaryValue(t *testing.T, storage SlabStorage, entries map[Value]Value) *DictionaryValue {
+	dictionary, err := NewDictionaryValue(storage, testAddress)
+	require.NoError(t, err)
+
+	for k, v := range entries {
+		require.NoError(t, dictionary.Set(k, v))
+	}
+	return dictionary
+}
+
+// setTestKeyHash replaces key hash function with hashFn until test ends.
+func setTestKeyHash(t *testing.T, hashFn func(data []byte) string) {
+	hashKeyData, hashFn = hashFn, hashKeyData
+	t.Cleanup(func() {
+		hashKeyData = hashFn
+	})
+}
+
+func verifyDictionaryTree(t *testing.T, dictionary *DictionaryValue, entries map[Value]Value) {
+	root, err := dictionary.root()
+	require.NoError(t, err)
+
+	verifyDictionarySlabs(t, root, dictionary.storage)
+
+	require.Equal(t, uint32(len(entries)), dictionary.Size())
+	for k, v := range entries {
+		value, found, err := dictionary.Get(k)
+		require.NoError(t, err)
+		require.True(t, found, "key %v not found", k)
+		require.Equal(t, v, value)
+	}
+
+	count := 0
+	err = dictionary.Iterate(func(k Value, v Value) (bool, error) {
+		assert.Equal(t, entries[k], v)
+		count++
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(entries), count)
+}
+
+// verifyDictionarySlabs verifies slabs of dictionary trie with root,
+// which is used by DictionaryValue and SetValue.
+func verifyDictionarySlabs(t *testing.T, root *DictionaryMetaSlab, storage *trackedSlabStorage) {
+	slabIDs := make(map[StorageID]bool)
+
+	var verify func(node DictionaryNode, isRoot bool)
+	verify = func(node DictionaryNode, isRoot bool) {
+		header := node.Header()
+		slabIDs[header.id] = true
+
+		switch slab := node.(type) {
+		case *DictionarySlab:
+			assert.Equal(t, uint32(len(slab.entries)), header.count)
+
+			if len(slab.entries) > 1 {
+				assert.True(t, header.size <= maxThreshold, "slab %s size %d exceeds %d", header.id, header.size, maxThreshold)
+			}
+
+			size := slab.headerSize()
+			for i, e := range slab.entries {
+				size += e.byteSize()
+
+				member, err := header.mask.IsMember(e.hash)
+				require.NoError(t, err)
+				assert.True(t, member, "slab %s mask doesn't accept its key", header.id)
+
+				hash, err := hashKey(e.key)
+				require.NoError(t, err)
+				assert.Equal(t, hash, e.hash)
+
+				if i > 0 {
+					prev := slab.entries[i-1]
+					assert.True(t, prev.hash <= e.hash)
+					if prev.hash == e.hash {
+						c, err := compareKeys(prev.key, e.key)
+						require.NoError(t, err)
+						assert.True(t, c < 0)
+					}
+				}
+			}
+			assert.Equal(t, size, header.size)
+
+			b, err := slab.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, int(header.size), len(b))
+
+		case *DictionaryCollisionSlab:
+			chain := []*DictionaryCollisionSlab{slab}
+			for c := slab; c.hasNext(); {
+				var err error
+				c, err = c.nextSlab(storage)
+				require.NoError(t, err)
+
+				assert.False(t, slabIDs[c.ID()], "collision slab %s is in chain twice", c.ID())
+				slabIDs[c.ID()] = true
+				chain = append(chain, c)
+			}
+
+			// Header count includes entries of the following slabs in chain
+			count := uint32(0)
+			dataSize := (&DictionarySlab{}).headerSize()
+			for i := len(chain) - 1; i >= 0; i-- {
+				c := chain[i]
+				assert.Equal(t, slab.hash, c.hash)
+
+				require.True(t, len(c.entries) > 0, "collision slab %s is empty", c.ID())
+				if len(c.entries) > 1 {
+					assert.True(t, c.header.size <= maxThreshold, "slab %s size %d exceeds %d", c.ID(), c.header.size, maxThreshold)
+				}
+
+				size := c.headerSize()
+				for _, e := range c.entries {
+					size += e.byteSize()
+
+					hash, err := hashKey(e.key)
+					require.NoError(t, err)
+					assert.Equal(t, slab.hash, hash)
+				}
+				assert.Equal(t, size, c.header.size)
+				dataSize += size - c.headerSize()
+
+				count += uint32(len(c.entries))
+				assert.Equal(t, count, c.header.count)
+			}
+			assert.Equal(t, count, header.count)
+
+			member, err := header.mask.IsMember(slab.hash)
+			require.NoError(t, err)
+			assert.True(t, member, "slab %s mask doesn't accept its key hash", header.id)
+
+			// Entries which fit in data slab aren't kept in collision slab
+			assert.True(t, dataSize > maxThreshold, "collision slab %s entries fit in data slab", header.id)
+
+		case *DictionaryMetaSlab:
+			assert.True(t, header.size <= maxThreshold, "slab %s size %d exceeds %d", header.id, header.size, maxThreshold)
+			if !isRoot {
+				assert.True(t, slab.orderedHeaders.Len() >= 2, "meta slab %s has %d children", header.id, slab.orderedHeaders.Len())
+			}
+
+			count := uint32(0)
+			size := slab.headerSize()
+
+			// Child masks partition keys accepted by meta slab mask
+			coverage := 0.0
+
+			var prevMask *Mask
+			for e := slab.orderedHeaders.Front(); e != nil; e = e.Next() {
+				h := e.Value.(*DictionarySlabHeader)
+
+				assert.True(t, header.mask.contains(h.mask))
+				coverage += math.Ldexp(1, -int(h.mask.index-header.mask.index))
+
+				if prevMask != nil {
+					assert.True(t, prevMask.less(h.mask))
+				}
+				prevMask = &h.mask
+
+				child, err := slab.getSlab(storage, h)
+				require.NoError(t, err)
+				assert.Equal(t, h, child.Header())
+				verify(child, false)
+
+				count += h.count
+				size += dictionaryMetaSlabChildHeaderSize + h.mask.encodedSize()
+			}
+			if slab.orderedHeaders.Len() > 0 {
+				assert.Equal(t, 1.0, coverage)
+			}
+			assert.Equal(t, count, header.count)
+			assert.Equal(t, size, header.size)
+		}
+	}
+
+	verify(root, true)
+
+	if storage, ok := storage.SlabStorage.(*BasicSlabStorage); ok {
+		assert.Equal(t, len(slabIDs), len(storage.slabs))
+		for id := range storage.slabs {
+			assert.True(t, slabIDs[id], "slab %s in storage isn't in dictionary", id)
+		}
+	}
+}
+
+func TestDictionarySetGet(t *testing.T) {
+
+	const dictionarySize = 2000
+
+	entries := make(map[Value]Value)
+
+	dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), nil)
+
+	for i := 0; i < dictionarySize; i++ {
+		k, v := UInt32Value(i), UInt32Value(i*2)
+		require.NoError(t, dictionary.Set(k, v))
+		entries[k] = v
+	}
+	verifyDictionaryTree(t, dictionary, entries)
+
+	// Replace values
+	for i := 0; i < dictionarySize; i += 3 {
+		k, v := UInt32Value(i), UInt32Value(i*3)
+		require.NoError(t, dictionary.Set(k, v))
+		entries[k] = v
+	}
+	verifyDictionaryTree(t, dictionary, entries)
+
+	for i := dictionarySize; i < dictionarySize+10; i++ {
+		found, err := dictionary.Has(UInt32Value(i))
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		_, found, err = dictionary.Get(UInt32Value(i))
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+}
+
+func TestDictionaryRemove(t *testing.T) {
+
+	const dictionarySize = 2000
+
+	entries := make(map[Value]Value)
+	for i := 0; i < dictionarySize; i++ {
+		entries[UInt32Value(i)] = UInt32Value(i)
+	}
+
+	storage := NewBasicSlabStorage()
+	dictionary := newTestDictionaryValue(t, storage, entries)
+	verifyDictionaryTree(t, dictionary, entries)
+
+	removed, err := dictionary.Remove(UInt32Value(dictionarySize))
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	r := rand.New(rand.NewSource(1))
+	keys := r.Perm(dictionarySize)
+
+	for i, k := range keys {
+		removed, err := dictionary.Remove(UInt32Value(k))
+		require.NoError(t, err)
+		assert.True(t, removed)
+		delete(entries, UInt32Value(k))
+
+		if i%500 == 0 {
+			verifyDictionaryTree(t, dictionary, entries)
+		}
+	}
+	verifyDictionaryTree(t, dictionary, entries)
+
+	// Empty dictionary has root and one data slab
+	assert.Equal(t, 2, len(storage.slabs))
+}
+
+func TestDictionaryRandomOperations(t *testing.T) {
+
+	const operationCount = 5000
+	const keyRange = 500
+
+	r := rand.New(rand.NewSource(2))
+
+	entries := make(map[Value]Value)
+
+	dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), nil)
+
+	for i := 0; i < operationCount; i++ {
+		k := UInt32Value(r.Intn(keyRange))
+
+		if r.Intn(3) == 0 {
+			removed, err := dictionary.Remove(k)
+			require.NoError(t, err)
+			_, exists := entries[k]
+			assert.Equal(t, exists, removed)
+			delete(entries, k)
+		} else {
+			v := UInt32Value(r.Uint32())
+			require.NoError(t, dictionary.Set(k, v))
+			entries[k] = v
+		}
+	}
+	verifyDictionaryTree(t, dictionary, entries)
+}
+
+func TestDictionaryEncodeDecode(t *testing.T) {
+
+	t.Run("empty", func(t *testing.T) {
+		dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), nil)
+
+		b, err := dictionary.GetSerizable().Encode()
+		require.NoError(t, err)
+		assert.Equal(t, 20, len(b)) // meta slab id (16 bytes) + slab count (4 bytes)
+
+		dictionary2, err := NewDictionaryValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyDictionaryTree(t, dictionary2, nil)
+	})
+
+	t.Run("one slab", func(t *testing.T) {
+		entries := map[Value]Value{UInt32Value(1): UInt32Value(2)}
+
+		dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), entries)
+
+		b, err := dictionary.GetSerizable().Encode()
+		require.NoError(t, err)
+		// meta slab id (16 bytes) + slab count (4 bytes) +
+		// slab 1 index (8 bytes) + slab 1 size (4 bytes) + slab 1 kind (1 byte) + slab 1 mask (2 bytes) +
+		// slab 1 (5 + 7 + 7 bytes)
+		assert.Equal(t, 20+13+2+19, len(b))
+
+		dictionary2, err := NewDictionaryValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyDictionaryTree(t, dictionary2, entries)
+	})
+
+	t.Run("multi slabs", func(t *testing.T) {
+		entries := make(map[Value]Value)
+		for i := 0; i < 2000; i++ {
+			entries[UInt32Value(i)] = UInt32Value(i)
+		}
+
+		dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), entries)
+
+		b, err := dictionary.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		dictionary2, err := NewDictionaryValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		assert.Equal(t, dictionary.metaSlab.ID(), dictionary2.metaSlab.ID())
+		verifyDictionaryTree(t, dictionary2, entries)
+
+		// Decoded dictionary can be modified
+		for i := 0; i < 1000; i++ {
+			_, err := dictionary2.Remove(UInt32Value(i))
+			require.NoError(t, err)
+			delete(entries, UInt32Value(i))
+		}
+		verifyDictionaryTree(t, dictionary2, entries)
+	})
+}
+
+func TestDictionaryHashCollision(t *testing.T) {
+
+	collidingHash := strings.Repeat("\xab", sha256.Size)
+
+	t.Run("all keys collide", func(t *testing.T) {
+		setTestKeyHash(t, func([]byte) string { return collidingHash })
+
+		const dictionarySize = 200
+
+		entries := make(map[Value]Value)
+		for i := 0; i < dictionarySize; i++ {
+			entries[UInt32Value(i)] = UInt32Value(i)
+		}
+
+		storage := NewBasicSlabStorage()
+		dictionary := newTestDictionaryValue(t, storage, entries)
+		verifyDictionaryTree(t, dictionary, entries)
+
+		root, err := dictionary.root()
+		require.NoError(t, err)
+		require.Equal(t, 1, root.orderedHeaders.Len())
+		child, err := root.getSlab(dictionary.storage, root.orderedHeaders.Front().Value.(*DictionarySlabHeader))
+		require.NoError(t, err)
+		require.IsType(t, &DictionaryCollisionSlab{}, child)
+
+		// Replace values
+		for i := 0; i < dictionarySize; i += 3 {
+			k, v := UInt32Value(i), UInt32Value(i*3)
+			require.NoError(t, dictionary.Set(k, v))
+			entries[k] = v
+		}
+		verifyDictionaryTree(t, dictionary, entries)
+
+		removed, err := dictionary.Remove(UInt32Value(dictionarySize))
+		require.NoError(t, err)
+		assert.False(t, removed)
+
+		r := rand.New(rand.NewSource(3))
+		for i, k := range r.Perm(dictionarySize) {
+			removed, err := dictionary.Remove(UInt32Value(k))
+			require.NoError(t, err)
+			assert.True(t, removed)
+			delete(entries, UInt32Value(k))
+
+			if i%50 == 0 {
+				verifyDictionaryTree(t, dictionary, entries)
+			}
+		}
+		verifyDictionaryTree(t, dictionary, entries)
+
+		// Empty dictionary has root and one data slab
+		assert.Equal(t, 2, len(storage.slabs))
+	})
+
+	t.Run("some keys collide", func(t *testing.T) {
+		// Keys below 100 have one of 3 hashes
+		setTestKeyHash(t, func(data []byte) string {
+			v, _, err := decodeSerializable(data)
+			require.NoError(t, err)
+			if k := v.(*UInt32Serializable).v; k < 100 {
+				hash := sha256.Sum256([]byte{byte(k % 3)})
+				return string(hash[:])
+			}
+			hash := sha256.Sum256(data)
+			return string(hash[:])
+		})
+
+		const operationCount = 5000
+		const keyRange = 500
+
+		r := rand.New(rand.NewSource(4))
+
+		entries := make(map[Value]Value)
+
+		dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), nil)
+
+		for i := 0; i < operationCount; i++ {
+			k := UInt32Value(r.Intn(keyRange))
+
+			if r.Intn(3) == 0 {
+				removed, err := dictionary.Remove(k)
+				require.NoError(t, err)
+				_, exists := entries[k]
+				assert.Equal(t, exists, removed)
+				delete(entries, k)
+			} else {
+				v := UInt32Value(r.Uint32())
+				require.NoError(t, dictionary.Set(k, v))
+				entries[k] = v
+			}
+
+			if i%1000 == 0 {
+				verifyDictionaryTree(t, dictionary, entries)
+			}
+		}
+		verifyDictionaryTree(t, dictionary, entries)
+	})
+
+	t.Run("encode decode", func(t *testing.T) {
+		setTestKeyHash(t, func(data []byte) string {
+			hash := sha256.Sum256([]byte{data[len(data)-1] % 8})
+			return string(hash[:])
+		})
+
+		entries := make(map[Value]Value)
+		for i := 0; i < 1000; i++ {
+			entries[UInt32Value(i)] = UInt32Value(i)
+		}
+
+		dictionary := newTestDictionaryValue(t, NewBasicSlabStorage(), entries)
+		verifyDictionaryTree(t, dictionary, entries)
+
+		b, err := dictionary.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		dictionary2, err := NewDictionaryValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyDictionaryTree(t, dictionary2, entries)
+
+		b2, err := dictionary2.GetSerizable().Encode()
+		require.NoError(t, err)
+		assert.Equal(t, b, b2)
+
+		// Slabs stored on their own
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		dictionary = newTestDictionaryValue(t, storage, entries)
+		id := dictionary.metaSlab.ID()
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		dictionary, err = NewDictionaryValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyDictionaryTree(t, dictionary, entries)
+
+		for i := 0; i < 500; i++ {
+			_, err := dictionary.Remove(UInt32Value(i))
+			require.NoError(t, err)
+			delete(entries, UInt32Value(i))
+		}
+		verifyDictionaryTree(t, dictionary, entries)
+	})
+}
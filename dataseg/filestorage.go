@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// fileSlabStorageMagic starts every slab storage file, followed by format version.
+var fileSlabStorageMagic = []byte("dseg")
+
+const fileSlabStorageVersion = 1
+
+const fileSlabStorageHeaderSize = 5
+
+const (
+	fileRecordStore  = 1
+	fileRecordRemove = 2
+
+	// fileRecordStorageIndex keeps the highest index allocated per address
+	// in compacted file, which doesn't have records of removed slabs.
+	fileRecordStorageIndex = 3
+)
+
+// fileRecordHeaderSize is the size of record head:
+// record type (1 byte) + address (8 bytes) + index (8 bytes) + payload size (4 bytes).
+// Record head and payload are followed by CRC-32 checksum (4 bytes) of both.
+const fileRecordHeaderSize = 21
+
+const fileRecordChecksumSize = 4
+
+// Slab kinds identify slab type of stored record payload,
+// which is slab kind (1 byte) followed by encoded slab.
+const (
+	slabKindArray          = 1
+	slabKindArrayMeta      = 2
+	slabKindDictionary     = 3
+	slabKindDictionaryMeta = 4
+
+	slabKindDictionaryCollision = 5
+
+	slabKindOrderedMap     = 6
+	slabKindOrderedMapMeta = 7
+
+	slabKindComposite = 8
+
+	slabKindOverflow = 9
+)
+
+// fileRecordLocation is the location of encoded slab in file.
+type fileRecordLocation struct {
+	offset int64
+	size   uint32
+}
+
+// FileSlabStorage is a SlabStorage persisting slabs in a single file.
+// The file is an append-only log of records, each storing an encoded slab
+// or removing a slab. An index of StorageID to the latest stored record is
+// kept in memory and rebuilt from the log when the file is opened.
+//
+// Slabs are decoded from file on each Retrieve, so retrieved slabs aren't
+// shared between callers. Elements of data slabs are decoded on first access.
+//
+// SlabStorage.Store doesn't return error, so the first write error is
+// kept and returned by subsequent Retrieve, GenerateStorageID, Sync and Close.
+type FileSlabStorage struct {
+	path  string
+	file  *os.File
+	size  int64
+	index map[StorageID]fileRecordLocation
+
+	// storageIndex is the highest index of StorageID allocated, stored or removed per address
+	storageIndex map[Address]uint64
+
+	err error
+}
+
+var _ SlabStorage = &FileSlabStorage{}
+
+// OpenFileSlabStorage opens slab storage file at path, creating it if
+// it doesn't exist. Incomplete or corrupted records at the end of the log,
+// e.g. left by interrupted write, are discarded.
+func OpenFileSlabStorage(path string) (*FileSlabStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileSlabStorage{
+		path:         path,
+		file:         file,
+		index:        make(map[StorageID]fileRecordLocation),
+		storageIndex: make(map[Address]uint64),
+	}
+
+	err = s.recover()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// recover checks file header and rebuilds index from records in file.
+func (s *FileSlabStorage) recover() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		header := append(append([]byte(nil), fileSlabStorageMagic...), fileSlabStorageVersion)
+		_, err := s.file.WriteAt(header, 0)
+		if err != nil {
+			return err
+		}
+		s.size = fileSlabStorageHeaderSize
+		return nil
+	}
+
+	header := make([]byte, fileSlabStorageHeaderSize)
+	_, err = s.file.ReadAt(header, 0)
+	if err != nil {
+		return fmt.Errorf("can't read slab storage file header: %w", err)
+	}
+	if !bytes.Equal(header[:len(fileSlabStorageMagic)], fileSlabStorageMagic) {
+		return errors.New("not slab storage file")
+	}
+	if header[len(fileSlabStorageMagic)] != fileSlabStorageVersion {
+		return fmt.Errorf("unsupported slab storage file version %d", header[len(fileSlabStorageMagic)])
+	}
+
+	offset := int64(fileSlabStorageHeaderSize)
+	for offset < info.Size() {
+		size, ok := s.recoverRecord(offset, info.Size())
+		if !ok {
+			break
+		}
+		offset += size
+	}
+
+	// Discard incomplete or corrupted records
+	if offset < info.Size() {
+		err := s.file.Truncate(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.size = offset
+	return nil
+}
+
+// recoverRecord reads record at offset and applies it to index.
+// It returns record size, or false if record is incomplete or corrupted.
+func (s *FileSlabStorage) recoverRecord(offset int64, fileSize int64) (int64, bool) {
+	if fileSize-offset < fileRecordHeaderSize+fileRecordChecksumSize {
+		return 0, false
+	}
+
+	head := make([]byte, fileRecordHeaderSize)
+	_, err := s.file.ReadAt(head, offset)
+	if err != nil {
+		return 0, false
+	}
+
+	payloadSize := binary.BigEndian.Uint32(head[17:])
+	size := int64(fileRecordHeaderSize) + int64(payloadSize) + fileRecordChecksumSize
+	if fileSize-offset < size {
+		return 0, false
+	}
+
+	record := make([]byte, size)
+	_, err = s.file.ReadAt(record, offset)
+	if err != nil {
+		return 0, false
+	}
+
+	checksumOffset := size - fileRecordChecksumSize
+	if crc32.ChecksumIEEE(record[:checksumOffset]) != binary.BigEndian.Uint32(record[checksumOffset:]) {
+		return 0, false
+	}
+
+	var address Address
+	copy(address[:], head[1:])
+	id := NewStorageID(address, binary.BigEndian.Uint64(head[9:]))
+
+	switch head[0] {
+	case fileRecordStore:
+		s.index[id] = fileRecordLocation{offset: offset + fileRecordHeaderSize, size: payloadSize}
+	case fileRecordRemove:
+		delete(s.index, id)
+	case fileRecordStorageIndex:
+		// Only storage index is updated
+	default:
+		return 0, false
+	}
+
+	if id.Index > s.storageIndex[id.Address] {
+		s.storageIndex[id.Address] = id.Index
+	}
+
+	return size, true
+}
+
+// appendRecord appends record with payload to file and returns payload location.
+func (s *FileSlabStorage) appendRecord(recordType byte, id StorageID, payload []byte) (fileRecordLocation, error) {
+	record := make([]byte, fileRecordHeaderSize, fileRecordHeaderSize+len(payload)+fileRecordChecksumSize)
+
+	record[0] = recordType
+	copy(record[1:], id.Address[:])
+	binary.BigEndian.PutUint64(record[9:], id.Index)
+	binary.BigEndian.PutUint32(record[17:], uint32(len(payload)))
+
+	record = append(record, payload...)
+	record = append(record, make([]byte, fileRecordChecksumSize)...)
+
+	checksumOffset := len(record) - fileRecordChecksumSize
+	binary.BigEndian.PutUint32(record[checksumOffset:], crc32.ChecksumIEEE(record[:checksumOffset]))
+
+	_, err := s.file.WriteAt(record, s.size)
+	if err != nil {
+		return fileRecordLocation{}, err
+	}
+
+	location := fileRecordLocation{offset: s.size + fileRecordHeaderSize, size: uint32(len(payload))}
+	s.size += int64(len(record))
+
+	if id.Index > s.storageIndex[id.Address] {
+		s.storageIndex[id.Address] = id.Index
+	}
+
+	return location, nil
+}
+
+func (s *FileSlabStorage) Store(slab Slab) {
+	if s.err != nil {
+		return
+	}
+
+	payload, err := encodeStoredSlab(slab)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	location, err := s.appendRecord(fileRecordStore, slab.ID(), payload)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	s.index[slab.ID()] = location
+}
+
+func (s *FileSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
+	if s.err != nil {
+		return nil, false, s.err
+	}
+
+	location, ok := s.index[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	payload := make([]byte, location.size)
+	_, err := s.file.ReadAt(payload, location.offset)
+	if err != nil {
+		return nil, false, err
+	}
+
+	slab, err := decodeStoredSlab(id, payload)
+	if err != nil {
+		return nil, false, err
+	}
+	return slab, true, nil
+}
+
+func (s *FileSlabStorage) Remove(id StorageID) {
+	if s.err != nil {
+		return
+	}
+
+	if _, ok := s.index[id]; !ok {
+		return
+	}
+
+	_, err := s.appendRecord(fileRecordRemove, id, nil)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	delete(s.index, id)
+}
+
+// GenerateStorageID returns StorageID with index greater than index of
+// any slab stored or removed in file, so indexes aren't reused after reopen.
+func (s *FileSlabStorage) GenerateStorageID(address Address) (StorageID, error) {
+	if s.err != nil {
+		return StorageID{}, s.err
+	}
+
+	index := s.storageIndex[address] + 1
+	s.storageIndex[address] = index
+	return NewStorageID(address, index), nil
+}
+
+// Compact rewrites file with only the latest record of each stored slab,
+// followed by the highest index allocated per address, so indexes allocated
+// by GenerateStorageID are not reused after Compact, also after reopen.
+func (s *FileSlabStorage) Compact() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	// Discard file left by interrupted Compact
+	err := os.Remove(s.path + ".compact")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	compacted, err := OpenFileSlabStorage(s.path + ".compact")
+	if err != nil {
+		return err
+	}
+
+	err = s.writeCompacted(compacted)
+	if err == nil {
+		err = os.Rename(compacted.path, s.path)
+	}
+	if err != nil {
+		compacted.file.Close()
+		os.Remove(compacted.path)
+		return err
+	}
+
+	s.file.Close()
+	s.file = compacted.file
+	s.size = compacted.size
+	s.index = compacted.index
+	return nil
+}
+
+// writeCompacted writes the latest record of each stored slab and
+// the highest index allocated per address to compacted storage.
+func (s *FileSlabStorage) writeCompacted(compacted *FileSlabStorage) error {
+	for _, id := range s.storedIDs() {
+		location := s.index[id]
+
+		payload := make([]byte, location.size)
+		_, err := s.file.ReadAt(payload, location.offset)
+		if err != nil {
+			return err
+		}
+
+		location, err = compacted.appendRecord(fileRecordStore, id, payload)
+		if err != nil {
+			return err
+		}
+		compacted.index[id] = location
+	}
+
+	for _, address := range sortedAddresses(s.storageIndex) {
+		id := NewStorageID(address, s.storageIndex[address])
+		if compacted.storageIndex[address] == id.Index {
+			// Index is already kept by record of stored slab
+			continue
+		}
+
+		_, err := compacted.appendRecord(fileRecordStorageIndex, id, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return compacted.file.Sync()
+}
+
+// sortedAddresses returns addresses of storage indexes in ascending order.
+func sortedAddresses(storageIndex map[Address]uint64) []Address {
+	addresses := make([]Address, 0, len(storageIndex))
+	for address := range storageIndex {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+	return addresses
+}
+
+func (s *FileSlabStorage) storedIDs() []StorageID {
+	ids := make(map[StorageID]bool, len(s.index))
+	for id := range s.index {
+		ids[id] = true
+	}
+	return sortedStorageIDs(ids)
+}
+
+// Sync commits written records to stable storage.
+func (s *FileSlabStorage) Sync() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.file.Sync()
+}
+
+// Close syncs and closes file.
+func (s *FileSlabStorage) Close() error {
+	err := s.Sync()
+	closeErr := s.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// encodeStoredSlab encodes slab kind and slab on its own,
+// without slabs it references.
+func encodeStoredSlab(slab Slab) ([]byte, error) {
+	var kind byte
+	var data []byte
+	var err error
+
+	switch slab := slab.(type) {
+	case *ArraySlab:
+		kind = slabKindArray
+		data, err = slab.Encode()
+	case *ArrayMetaSlab:
+		kind = slabKindArrayMeta
+		data, err = slab.encodeSlab()
+	case *DictionarySlab:
+		kind = slabKindDictionary
+		data, err = slab.encodeSlab()
+	case *DictionaryMetaSlab:
+		kind = slabKindDictionaryMeta
+		data, err = slab.encodeSlab()
+	case *DictionaryCollisionSlab:
+		kind = slabKindDictionaryCollision
+		data, err = slab.encodeSlab()
+	case *OrderedMapSlab:
+		kind = slabKindOrderedMap
+		data, err = slab.Encode()
+	case *OrderedMapMetaSlab:
+		kind = slabKindOrderedMapMeta
+		data, err = slab.encodeSlab()
+	case *CompositeSlab:
+		kind = slabKindComposite
+		data, err = slab.encodeSlab()
+	case *OverflowSlab:
+		kind = slabKindOverflow
+		data, err = slab.Encode()
+	default:
+		return nil, fmt.Errorf("can't encode slab %s of type %T", slab.ID(), slab)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{kind}, data...), nil
+}
+
+// decodeStoredSlab decodes slab with id encoded by encodeStoredSlab.
+func decodeStoredSlab(id StorageID, payload []byte) (Slab, error) {
+	if len(payload) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := payload[1:]
+
+	switch payload[0] {
+	case slabKindArray:
+		return newLazyArraySlab(&ArraySlabHeader{id: id}, data)
+
+	case slabKindArrayMeta:
+		meta := &ArrayMetaSlab{header: &ArraySlabHeader{}}
+		err := meta.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		if meta.ID() != id {
+			return nil, fmt.Errorf("slab %s has wrong id %s", id, meta.ID())
+		}
+		return meta, nil
+
+	case slabKindDictionary:
+		slab := &DictionarySlab{header: &DictionarySlabHeader{id: id}}
+		err := slab.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		return slab, nil
+
+	case slabKindDictionaryCollision:
+		slab := &DictionaryCollisionSlab{header: &DictionarySlabHeader{id: id}}
+		err := slab.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		return slab, nil
+
+	case slabKindDictionaryMeta:
+		meta := &DictionaryMetaSlab{header: &DictionarySlabHeader{}}
+		err := meta.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		if meta.ID() != id {
+			return nil, fmt.Errorf("slab %s has wrong id %s", id, meta.ID())
+		}
+		return meta, nil
+
+	case slabKindOrderedMap:
+		slab := &OrderedMapSlab{header: &OrderedMapSlabHeader{id: id}}
+		err := slab.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return slab, nil
+
+	case slabKindOrderedMapMeta:
+		meta := &OrderedMapMetaSlab{header: &OrderedMapSlabHeader{}}
+		err := meta.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		if meta.ID() != id {
+			return nil, fmt.Errorf("slab %s has wrong id %s", id, meta.ID())
+		}
+		return meta, nil
+
+	case slabKindComposite:
+		slab := &CompositeSlab{}
+		rest, err := slab.decodeSlab(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 {
+			return nil, errors.New("wrong byte size for composite slab")
+		}
+		if slab.ID() != id {
+			return nil, fmt.Errorf("slab %s has wrong id %s", id, slab.ID())
+		}
+		return slab, nil
+
+	case slabKindOverflow:
+		slab := &OverflowSlab{id: id}
+		err := slab.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return slab, nil
+
+	default:
+		return nil, fmt.Errorf("slab %s has unknown kind %d", id, payload[0])
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestFileSlabStorage(t *testing.T, path string) *FileSlabStorage {
+	storage, err := OpenFileSlabStorage(path)
+	require.NoError(t, err)
+	return storage
+}
+
+func TestFileSlabStorage(t *testing.T) {
+
+	const arraySize = 2000
+
+	values := make([]Value, arraySize)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		array := newTestArrayValue(t, storage, values[:arraySize/2])
+		for _, v := range values[arraySize/2:] {
+			require.NoError(t, array.Append(v))
+		}
+		for i := 0; i < arraySize/4; i++ {
+			require.NoError(t, array.Remove(0))
+		}
+		require.NoError(t, array.Set(0, UInt32Value(0)))
+
+		expected := append([]Value{UInt32Value(0)}, values[arraySize/4+1:]...)
+		verifyArrayTree(t, array, expected)
+
+		id := array.metaSlab.ID()
+		slabCount := len(storage.index)
+
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		// Removed slabs aren't in index
+		assert.Equal(t, slabCount, len(storage.index))
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array, expected)
+
+		// Indexes of stored and removed slabs aren't reused
+		newID, err := storage.GenerateStorageID(testAddress)
+		require.NoError(t, err)
+		for storedID := range storage.index {
+			assert.True(t, storedID.Less(newID))
+		}
+
+		require.NoError(t, array.Insert(1, UInt32Value(arraySize)))
+		expected = append([]Value{UInt32Value(0), UInt32Value(arraySize)}, expected[1:]...)
+		verifyArrayTree(t, array, expected)
+	})
+
+	t.Run("recover", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		array := newTestArrayValue(t, storage, values)
+		id := array.metaSlab.ID()
+		size := storage.size
+
+		// Interrupted write of a record appending element
+		require.NoError(t, array.Append(UInt32Value(arraySize)))
+		require.NoError(t, storage.Close())
+
+		require.NoError(t, os.Truncate(path, size+fileRecordHeaderSize+3))
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		assert.Equal(t, size, storage.size)
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values)
+
+		require.NoError(t, array.Append(UInt32Value(arraySize)))
+		verifyArrayTree(t, array, append(values, UInt32Value(arraySize)))
+	})
+
+	t.Run("corrupted record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		array := newTestArrayValue(t, storage, values)
+		id := array.metaSlab.ID()
+		size := storage.size
+
+		require.NoError(t, array.Set(0, UInt32Value(arraySize)))
+		require.NoError(t, storage.Close())
+
+		// Corrupt a byte of slab data in the first record after size
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		require.NoError(t, err)
+		_, err = file.WriteAt([]byte{0xff}, size+fileRecordHeaderSize+2)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		array, err = NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		array := newTestArrayValue(t, storage, values)
+		for i := 0; i < arraySize/2; i++ {
+			require.NoError(t, array.Remove(0))
+		}
+		id := array.metaSlab.ID()
+		size := storage.size
+
+		require.NoError(t, storage.Compact())
+		assert.Less(t, storage.size, size)
+
+		verifyArrayTree(t, array, values[arraySize/2:])
+
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values[arraySize/2:])
+	})
+
+	t.Run("compact doesn't reuse storage ids", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		address := Address{1}
+		array := newTestArrayValue(t, storage, values)
+		for array.Size() > 0 {
+			require.NoError(t, array.Remove(array.Size()-1))
+		}
+
+		// Index allocated but not stored
+		generated, err := storage.GenerateStorageID(address)
+		require.NoError(t, err)
+
+		require.NoError(t, storage.Compact())
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		id, err := storage.GenerateStorageID(address)
+		require.NoError(t, err)
+		assert.Greater(t, id.Index, generated.Index)
+
+		// Compacting reopened file keeps the highest index
+		require.NoError(t, storage.Compact())
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+
+		id2, err := storage.GenerateStorageID(address)
+		require.NoError(t, err)
+		assert.Greater(t, id2.Index, id.Index)
+	})
+
+	t.Run("dictionary", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+
+		storage := openTestFileSlabStorage(t, path)
+
+		entries := make(map[Value]Value)
+		for i := 0; i < arraySize; i++ {
+			entries[UInt32Value(i)] = UInt32Value(i * 2)
+		}
+
+		dictionary := newTestDictionaryValue(t, storage, entries)
+		for i := 0; i < arraySize/2; i++ {
+			_, err := dictionary.Remove(UInt32Value(i))
+			require.NoError(t, err)
+			delete(entries, UInt32Value(i))
+		}
+		verifyDictionaryTree(t, dictionary, entries)
+
+		id := dictionary.metaSlab.ID()
+
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		dictionary, err := NewDictionaryValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyDictionaryTree(t, dictionary, entries)
+	})
+
+	t.Run("not slab storage file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		require.NoError(t, ioutil.WriteFile(path, []byte("not slabs"), 0644))
+
+		_, err := OpenFileSlabStorage(path)
+		require.Error(t, err)
+	})
+}
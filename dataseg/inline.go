@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxInlineArraySize is the maximum encoded size of nested array which is
+// inlined in element of parent array, so that at least two inlined arrays
+// fit in a slab. Larger nested arrays are stored in their own slabs and
+// referenced by StorageID.
+const maxInlineArraySize = maxThreshold / 2
+
+// InlineArraySerializable is nested array encoded inline in element of
+// parent array. It keeps StorageID of the array, so the array keeps its
+// identity when it's moved to its own slabs.
+type InlineArraySerializable struct {
+	id   StorageID
+	slab *ArraySlab
+}
+
+// Encode encodes inlined array as
+//
+//	cbor.Tag{
+//			Number:  cborTagArrayValue,
+//			Content: []interface{}{address, index, elements...},
+//	}
+//
+// with the shortest array and index heads, so small arrays have small overhead.
+func (s *InlineArraySerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagArrayValue
+
+	index := 2
+	index += encodeCBORHead(buf[index:], 0x80, uint64(len(s.slab.elements)+2))
+	index += encodeCBORHead(buf[index:], cborMajorByteString, uint64(len(s.id.Address)))
+	index += copy(buf[index:], s.id.Address[:])
+	index += encodeCBORHead(buf[index:], 0, s.id.Index)
+
+	for _, e := range s.slab.elements {
+		b, err := e.Encode()
+		if err != nil {
+			return nil, err
+		}
+		index += copy(buf[index:], b)
+	}
+
+	return buf, nil
+}
+
+func (s *InlineArraySerializable) Decode(b []byte) error {
+	if len(b) < 2 {
+		return errors.New("too short for inlined array")
+	}
+
+	if b[0] != 0xd8 || b[1] != cborTagArrayValue {
+		return errors.New("not inlined array")
+	}
+
+	count, n, err := decodeCBORHead(b[2:], 0x80)
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return errors.New("inlined array without StorageID")
+	}
+	data := b[2+n:]
+
+	address, err := decodeCBORString(data, cborMajorByteString)
+	if err != nil {
+		return err
+	}
+	if len(address) != len(s.id.Address) {
+		return errors.New("wrong address size for inlined array")
+	}
+	copy(s.id.Address[:], address)
+	data = data[1+len(address):]
+
+	s.id.Index, n, err = decodeCBORHead(data, 0)
+	if err != nil {
+		return err
+	}
+	data = data[n:]
+
+	slab := &ArraySlab{header: &ArraySlabHeader{id: s.id}}
+	slab.header.size = slab.headerSize()
+
+	for i := uint64(2); i < count; i++ {
+		var e Serializable
+		e, data, err = decodeSerializable(data)
+		if err != nil {
+			return err
+		}
+		slab.elements = append(slab.elements, e)
+		slab.header.size += e.ByteSize()
+	}
+	slab.header.count = uint32(len(slab.elements))
+
+	s.slab = slab
+	return nil
+}
+
+// ByteSize returns size of tag number (2 bytes), array head,
+// address (9 bytes), index and elements.
+func (s *InlineArraySerializable) ByteSize() uint32 {
+	return 2 +
+		cborHeadSize(uint64(len(s.slab.elements)+2)) +
+		9 +
+		cborHeadSize(s.id.Index) +
+		s.slab.header.size - s.slab.headerSize()
+}
+
+func (s *InlineArraySerializable) IsConstantSized() bool { return false }
+
+// GetValue returns nil because inlined array is located through its
+// parent array. Like StorageID element, parent array resolves it to
+// ArrayValue (see ArrayValue.value).
+func (s *InlineArraySerializable) GetValue() Value {
+	return nil
+}
+
+// inlinedArray locates array inlined in element of parent array.
+type inlinedArray struct {
+	id     StorageID
+	parent *ArrayValue
+
+	// index is position of array in parent when it was last accessed.
+	// Parent is searched for the array if it has moved since then.
+	index uint32
+}
+
+// isNestedElement returns true if element is array with id,
+// either inlined or referenced by StorageID.
+func isNestedElement(element Serializable, id StorageID) bool {
+	switch e := element.(type) {
+	case *InlineArraySerializable:
+		return e.id == id
+	case *StorageID:
+		return *e == id
+	default:
+		return false
+	}
+}
+
+// inline returns Serializable of array inlined in element of parent,
+// or nil if array is too large to be inlined. Array holding arrays
+// referenced by StorageID isn't inlined, because their root slabs
+// reference it as parent.
+func (v *ArrayValue) inline() (*InlineArraySerializable, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+
+	slab := &ArraySlab{header: &ArraySlabHeader{id: metaSlab.ID()}}
+	slab.header.size = slab.headerSize()
+
+	s := &InlineArraySerializable{id: metaSlab.ID(), slab: slab}
+
+	nested := false
+	err = metaSlab.Iterate(v.storage, func(e Serializable) (bool, error) {
+		var err error
+		nested, err = v.isNestedArray(e)
+		if err != nil || nested {
+			return false, err
+		}
+		err = slab.Append(v.storage, e)
+		if err != nil {
+			return false, err
+		}
+		return s.ByteSize() <= maxInlineArraySize, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if nested || s.ByteSize() > maxInlineArraySize {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// isNestedArray returns true if element is StorageID of nested array,
+// instead of referenced composite, or optional value holding it.
+func (v *ArrayValue) isNestedArray(element Serializable) (bool, error) {
+	if some, ok := element.(*SomeSerializable); ok {
+		return v.isNestedArray(some.inner)
+	}
+	id, ok := element.(*StorageID)
+	if !ok {
+		return false, nil
+	}
+	slab, found, err := v.storage.Retrieve(*id)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("slab %s not found", *id)
+	}
+	_, ok = slab.(*ArrayMetaSlab)
+	return ok, nil
+}
+
+// isNestedIn returns true if this array is array with id, or is nested
+// in it. Parent of inlined array is found through inlinedArray, and parent
+// of array stored in its own slabs through parent in its root slab.
+func (v *ArrayValue) isNestedIn(id StorageID) (bool, error) {
+	array := v
+	for {
+		slab, err := array.inlinedSlab()
+		if err != nil {
+			return false, err
+		}
+		if slab == nil {
+			break
+		}
+		array = array.inlined.parent
+	}
+
+	// Array holding arrays referenced by StorageID isn't inlined,
+	// so parents of arrays stored in their own slabs are also stored
+	// in their own slabs. Array held in field of composite is the last
+	// array ancestor, because composites are only referenced.
+	for ancestor := array.ID(); ancestor != (StorageID{}); {
+		if ancestor == id {
+			return true, nil
+		}
+		slab, found, err := v.storage.Retrieve(ancestor)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, fmt.Errorf("slab %s not found", ancestor)
+		}
+		root, ok := slab.(*ArrayMetaSlab)
+		if !ok {
+			break
+		}
+		ancestor = root.parent
+	}
+	return false, nil
+}
+
+// removeElement removes slabs of element which is removed from array
+// or replaced: overflow slabs of element, or slabs of nested array
+// and slabs of its elements. Referenced composites aren't removed.
+func (v *ArrayValue) removeElement(element Serializable) error {
+	switch e := element.(type) {
+	case *InlineArraySerializable:
+		for _, element := range e.slab.elements {
+			err := v.removeElement(element)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *OverflowID:
+		return removeOverflow(v.storage, e.id)
+
+	case *SomeSerializable:
+		return v.removeElement(e.inner)
+
+	case *StorageID:
+		nested, err := v.isNestedArray(e)
+		if err != nil || !nested {
+			return err
+		}
+		return removeNestedArray(v.storage, *e)
+
+	default:
+		return nil
+	}
+}
+
+// removeNestedArray removes slabs of nested array with id
+// and slabs of its elements from storage.
+func removeNestedArray(storage SlabStorage, id StorageID) error {
+	child, err := NewArrayValueFromStorage(storage, id)
+	if err != nil {
+		return err
+	}
+	err = child.iterateElements(func(element Serializable) (bool, error) {
+		return true, child.removeElement(element)
+	})
+	if err != nil {
+		return err
+	}
+	return removeArraySlabs(child.metaSlab, storage)
+}
+
+// removeArraySlabs removes slabs of array tree under metaSlab,
+// including metaSlab, from storage.
+func removeArraySlabs(metaSlab *ArrayMetaSlab, storage SlabStorage) error {
+	if metaSlab.internal {
+		for e := metaSlab.orderedHeaders.Front(); e != nil; e = e.Next() {
+			slab, err := metaSlab.getSlab(storage, e.Value.(*ArraySlabHeader))
+			if err != nil {
+				return err
+			}
+			err = removeArraySlabs(slab.(*ArrayMetaSlab), storage)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		for e := metaSlab.orderedHeaders.Front(); e != nil; e = e.Next() {
+			storage.Remove(e.Value.(*ArraySlabHeader).id)
+		}
+	}
+	storage.Remove(metaSlab.ID())
+	return nil
+}
+
+// inlinedSlab returns data slab holding elements of inlined array,
+// or nil if array is stored in its own slabs.
+func (v *ArrayValue) inlinedSlab() (*ArraySlab, error) {
+	if v.inlined == nil {
+		return nil, nil
+	}
+
+	element, err := v.inlined.parent.nestedElement(v.inlined)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := element.(*InlineArraySerializable); ok {
+		return s.slab, nil
+	}
+
+	// Array is moved to its own slabs through another ArrayValue
+	slab, found, err := v.storage.Retrieve(v.inlined.id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("array slab %s not found", v.inlined.id)
+	}
+	metaSlab, ok := slab.(*ArrayMetaSlab)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not array meta slab", v.inlined.id, slab)
+	}
+
+	v.metaSlab = metaSlab
+	v.inlined = nil
+	return nil, nil
+}
+
+// updateInlined modifies a copy of data slab of inlined array with fn,
+// and replaces element of array in parent. Array is moved to its own
+// slabs with the same StorageID if it grows past maxInlineArraySize,
+// or if it holds array referenced by StorageID (see inline).
+func (v *ArrayValue) updateInlined(slab *ArraySlab, fn func(ArrayNode) error) error {
+	// Parent slab and its snapshots still hold the current slab
+	slab = slab.Clone().(*ArraySlab)
+
+	err := fn(slab)
+	if err != nil {
+		return err
+	}
+
+	nested := v.inlined
+
+	var element Serializable = &InlineArraySerializable{id: nested.id, slab: slab}
+
+	hasNested := false
+	for _, e := range slab.elements {
+		hasNested, err = v.isNestedArray(e)
+		if err != nil {
+			return err
+		}
+		if hasNested {
+			break
+		}
+	}
+
+	if hasNested || element.ByteSize() > maxInlineArraySize {
+		metaSlab := &ArrayMetaSlab{
+			header: &ArraySlabHeader{id: nested.id},
+			parent: nested.parent.ID(),
+		}
+		metaSlab.header.size = metaSlab.headerSize()
+
+		v.storage.Store(metaSlab)
+
+		for _, e := range slab.elements {
+			err := metaSlab.Append(v.storage, e)
+			if err != nil {
+				return err
+			}
+			err = metaSlab.updateRoot(v.storage)
+			if err != nil {
+				return err
+			}
+		}
+
+		v.metaSlab = metaSlab
+		v.inlined = nil
+
+		id := nested.id
+		element = &id
+	}
+
+	return nested.parent.setNested(nested, element)
+}
+
+// nestedElement returns element of nested array, and updates its index
+// if array has moved in this array since it was last accessed.
+func (v *ArrayValue) nestedElement(nested *inlinedArray) (Serializable, error) {
+	node, err := v.node()
+	if err != nil {
+		return nil, err
+	}
+
+	if nested.index < node.Header().count {
+		element, err := node.Get(v.storage, nested.index)
+		if err != nil {
+			return nil, err
+		}
+		if isNestedElement(element, nested.id) {
+			return element, nil
+		}
+	}
+
+	var found Serializable
+	index := uint32(0)
+	err = v.iterateElements(func(element Serializable) (bool, error) {
+		if isNestedElement(element, nested.id) {
+			found = element
+			return false, nil
+		}
+		index++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("array %s isn't nested in array %s", nested.id, v.ID())
+	}
+
+	nested.index = index
+	return found, nil
+}
+
+// setNested replaces element of nested array in this array.
+func (v *ArrayValue) setNested(nested *inlinedArray, element Serializable) error {
+	_, err := v.nestedElement(nested)
+	if err != nil {
+		return err
+	}
+	return v.update(func(node ArrayNode) error {
+		return node.Set(v.storage, nested.index, element)
+	})
+}
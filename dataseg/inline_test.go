@@ -0,0 +1,253 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireArrayValues checks that array has values in order.
+func requireArrayValues(t *testing.T, array *ArrayValue, values []Value) {
+	require.Equal(t, uint32(len(values)), array.Size())
+	for i := range values {
+		v, err := array.Get(uint32(i))
+		require.NoError(t, err)
+		require.Equal(t, values[i], v)
+	}
+}
+
+// requireNestedElement checks that element at index of array is nested
+// array with id, either inlined or referenced by StorageID.
+func requireNestedElement(t *testing.T, array *ArrayValue, index uint32, id StorageID, inlined bool) {
+	node, err := array.node()
+	require.NoError(t, err)
+	element, err := node.Get(array.storage, index)
+	require.NoError(t, err)
+
+	require.True(t, isNestedElement(element, id))
+	if inlined {
+		require.IsType(t, &InlineArraySerializable{}, element)
+	} else {
+		require.IsType(t, &StorageID{}, element)
+	}
+}
+
+func TestArrayInlined(t *testing.T) {
+
+	t.Run("inline and move out", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		parentValues := []Value{UInt32Value(0), UInt32Value(1)}
+		parent := newTestArrayValue(t, storage, parentValues)
+
+		childValues := []Value{UInt32Value(100)}
+		child := newTestArrayValue(t, storage, childValues)
+		id := child.ID()
+
+		require.NoError(t, parent.Insert(1, child))
+		parentValues = []Value{UInt32Value(0), child, UInt32Value(1)}
+
+		// Slabs of inlined array are removed from storage
+		requireNestedElement(t, parent, 1, id, true)
+		verifyArrayTree(t, parent, parentValues)
+		assert.Equal(t, 2, len(storage.slabs))
+
+		// Inlined array stays inlined while it's small
+		require.NoError(t, child.Append(UInt32Value(101)))
+		childValues = append(childValues, UInt32Value(101))
+		requireNestedElement(t, parent, 1, id, true)
+		requireArrayValues(t, child, childValues)
+		verifyArrayTree(t, parent, parentValues)
+
+		// Inlined array is moved to its own slabs with the same id
+		for i := 2; child.inlined != nil; i++ {
+			require.NoError(t, child.Append(UInt32Value(100+i)))
+			childValues = append(childValues, UInt32Value(100+i))
+		}
+		assert.Equal(t, id, child.ID())
+		requireNestedElement(t, parent, 1, id, false)
+		requireArrayValues(t, child, childValues)
+		verifyArrayTree(t, parent, parentValues)
+
+		// Array in its own slabs isn't inlined again when it shrinks
+		for child.Size() > 0 {
+			require.NoError(t, child.Remove(0))
+		}
+		requireNestedElement(t, parent, 1, id, false)
+		verifyArrayTree(t, parent, parentValues)
+
+		// Array in its own slabs grows past slab size
+		childValues = nil
+		for i := 0; i < 200; i++ {
+			require.NoError(t, child.Append(UInt32Value(i)))
+			childValues = append(childValues, UInt32Value(i))
+		}
+		assert.True(t, child.metaSlab.orderedHeaders.Len() > 1)
+		requireArrayValues(t, child, childValues)
+		verifyArrayTree(t, parent, parentValues)
+	})
+
+	t.Run("modify through parent", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		parentValues := make([]Value, 20)
+		for i := range parentValues {
+			parentValues[i] = newTestArrayValue(t, storage, []Value{UInt32Value(i)})
+		}
+		parent := newTestArrayValue(t, storage, parentValues)
+		assert.True(t, parent.metaSlab.orderedHeaders.Len() > 1)
+		verifyArrayTree(t, parent, parentValues)
+
+		v, err := parent.Get(10)
+		require.NoError(t, err)
+		child := v.(*ArrayValue)
+		require.NotNil(t, child.inlined)
+		require.NoError(t, child.Set(0, BoolValue(true)))
+
+		v, err = parent.Get(10)
+		require.NoError(t, err)
+		requireArrayValues(t, v.(*ArrayValue), []Value{BoolValue(true)})
+
+		// Array is found after it's moved by changes to parent
+		for i := 0; i < 30; i++ {
+			require.NoError(t, parent.Insert(0, UInt32Value(i)))
+		}
+		require.NoError(t, child.Insert(0, BoolValue(false)))
+		requireArrayValues(t, child, []Value{BoolValue(false), BoolValue(true)})
+		assert.Equal(t, uint32(40), child.inlined.index)
+
+		// Another ArrayValue of the same array sees changes,
+		// including move to its own slabs
+		v, err = parent.Get(40)
+		require.NoError(t, err)
+		child2 := v.(*ArrayValue)
+
+		var childValues []Value
+		for i := 0; child.inlined != nil; i++ {
+			require.NoError(t, child.Append(StringValue("s")))
+			childValues = append(childValues, StringValue("s"))
+		}
+		assert.Equal(t, uint32(len(childValues)+2), child2.Size())
+		assert.Nil(t, child2.inlined)
+		require.NoError(t, child2.Append(UInt32Value(0)))
+		assert.Equal(t, uint32(len(childValues)+3), child.Size())
+
+		// Array removed from parent can't be modified
+		v, err = parent.Get(41)
+		require.NoError(t, err)
+		removed := v.(*ArrayValue)
+		require.NoError(t, parent.Remove(41))
+		require.Error(t, removed.Append(UInt32Value(0)))
+
+		// Inlined array can't be added to another array
+		v, err = parent.Get(41)
+		require.NoError(t, err)
+		require.Error(t, parent.Append(v))
+	})
+
+	t.Run("nested inlined", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		grandchild := newTestArrayValue(t, storage, nil)
+		child := newTestArrayValue(t, storage, []Value{grandchild})
+		parent := newTestArrayValue(t, storage, []Value{UInt32Value(0), child})
+
+		requireNestedElement(t, parent, 1, child.ID(), true)
+		requireNestedElement(t, child, 0, grandchild.ID(), true)
+		assert.Equal(t, 2, len(storage.slabs))
+
+		require.NoError(t, grandchild.Append(UInt32Value(1)))
+		requireArrayValues(t, grandchild, []Value{UInt32Value(1)})
+
+		// Growing grandchild moves child to its own slabs first
+		for child.inlined != nil {
+			require.NoError(t, grandchild.Append(UInt32Value(2)))
+		}
+		requireNestedElement(t, parent, 1, child.ID(), false)
+		requireNestedElement(t, child, 0, grandchild.ID(), true)
+		verifyArrayTree(t, parent, []Value{UInt32Value(0), child})
+
+		for grandchild.inlined != nil {
+			require.NoError(t, grandchild.Append(UInt32Value(3)))
+		}
+		requireNestedElement(t, child, 0, grandchild.ID(), false)
+		verifyArrayTree(t, parent, []Value{UInt32Value(0), child})
+	})
+
+	t.Run("encode decode", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		parentValues := make([]Value, 20)
+		for i := range parentValues {
+			parentValues[i] = newTestArrayValue(t, storage, []Value{UInt32Value(i), StringValue("a")})
+		}
+		parent := newTestArrayValue(t, storage, parentValues)
+
+		b, err := parent.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		parent2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyArrayTree(t, parent2, parentValues)
+
+		for i := range parentValues {
+			v, err := parent2.Get(uint32(i))
+			require.NoError(t, err)
+			requireArrayValues(t, v.(*ArrayValue), []Value{UInt32Value(i), StringValue("a")})
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		committed := openTestFileSlabStorage(t, path)
+
+		storage := NewBasicSlabStorage()
+
+		child := newTestArrayValue(t, storage, []Value{UInt32Value(0)})
+		parent := newTestArrayValue(t, storage, []Value{child})
+		require.NoError(t, parent.Commit(committed))
+
+		// Inlined array changes, including move to its own slabs,
+		// are committed with parent
+		var childValues []Value
+		for i := 0; i < 100; i++ {
+			require.NoError(t, child.Append(UInt32Value(i)))
+			require.NoError(t, child.Commit(committed))
+			childValues = append(childValues, UInt32Value(i))
+		}
+		require.NoError(t, parent.Commit(committed))
+		require.NoError(t, committed.Close())
+
+		committed = openTestFileSlabStorage(t, path)
+		defer committed.Close()
+
+		parent2, err := NewArrayValueFromStorage(committed, parent.ID())
+		require.NoError(t, err)
+
+		v, err := parent2.Get(0)
+		require.NoError(t, err)
+		child2 := v.(*ArrayValue)
+		assert.Nil(t, child2.inlined)
+		requireArrayValues(t, child2, append([]Value{UInt32Value(0)}, childValues...))
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		child := newTestArrayValue(t, storage, []Value{UInt32Value(0)})
+		parent := newTestArrayValue(t, storage, []Value{child})
+
+		snapshot, err := parent.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		require.NoError(t, child.Set(0, UInt32Value(1)))
+		requireArrayValues(t, child, []Value{UInt32Value(1)})
+
+		v, err := snapshot.Get(0)
+		require.NoError(t, err)
+		requireArrayValues(t, v.(*ArrayValue), []Value{UInt32Value(0)})
+	})
+}
@@ -8,6 +8,8 @@ const minThreshold = targetThreshold / 4   // 15
 const maxThreshold = targetThreshold * 1.5 // 90
 //const maxItemSize = 6
 
+var exampleAddress = Address{0, 0, 0, 0, 0, 0, 0, 1}
+
 func newArrayExample() {
 
 	// Create ArrayValue with Values
@@ -17,10 +19,14 @@ func newArrayExample() {
 	}
 
 	fmt.Printf("Create ArrayValue with cadence values %v\n", values)
-	array := NewArrayValue(values)
+	array, err := NewArrayValue(NewBasicSlabStorage(), exampleAddress, values)
+	if err != nil {
+		fmt.Printf("NewArrayValue error %v\n", err)
+		return
+	}
 
 	// Print underlying slab layout
-	array.metaSlab.Print()
+	array.metaSlab.Print(array.storage)
 
 	verifyArrayElements(array, values)
 
@@ -35,13 +41,13 @@ func newArrayExample() {
 	fmt.Printf("Recreate ArrayValue with encoded data\n")
 
 	// Reconstruct array using encoded data
-	array2, err := NewArrayValueFromEncodedData(data)
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), data)
 	if err != nil {
-		fmt.Printf("NewArrayValueFromEncodedData(0x%x) error %v\n", data, err)
+		fmt.Printf("NewArrayValueFromEncodedData(NewBasicSlabStorage(), 0x%x) error %v\n", data, err)
 		return
 	}
 
-	array2.metaSlab.Print()
+	array2.metaSlab.Print(array2.storage)
 
 	verifyArrayElements(array2, values)
 }
@@ -55,10 +61,14 @@ func removeArrayExample() {
 	}
 
 	fmt.Printf("Create ArrayValue with cadence values %v\n", values)
-	array := NewArrayValue(values)
+	array, err := NewArrayValue(NewBasicSlabStorage(), exampleAddress, values)
+	if err != nil {
+		fmt.Printf("NewArrayValue error %v\n", err)
+		return
+	}
 
 	// Print underlying slab layout
-	array.metaSlab.Print()
+	array.metaSlab.Print(array.storage)
 
 	verifyArrayElements(array, values)
 
@@ -72,7 +82,7 @@ func removeArrayExample() {
 	array.Remove(uint32(array.Size() - 1))
 
 	fmt.Printf("Remove last 7 elements which triggers a merge and then a split of merged slab\n")
-	array.metaSlab.Print()
+	array.metaSlab.Print(array.storage)
 	/*
 		data, err := array.GetSerizable().Encode()
 		if err != nil {
@@ -85,13 +95,13 @@ func removeArrayExample() {
 		fmt.Printf("Recreate ArrayValue with encoded data\n")
 
 		// Reconstruct array using encoded data
-		array2, err := NewArrayValueFromEncodedData(data)
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), data)
 		if err != nil {
-			fmt.Printf("NewArrayValueFromEncodedData(0x%x) error %v\n", data, err)
+			fmt.Printf("NewArrayValueFromEncodedData(NewBasicSlabStorage(), 0x%x) error %v\n", data, err)
 			return
 		}
 
-		array2.metaSlab.Print()
+		array2.metaSlab.Print(array2.storage)
 
 	*/
 	verifyArrayElements(array, values[:len(values)-7])
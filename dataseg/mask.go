@@ -1,51 +1,176 @@
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
 
-// TODO handle large size keys and expansion
-// maybe enforce max keys size
+// maxMaskInputSize is the maximum size of inputs examined by Mask.
+// Inputs of different sizes are compared as if they were padded with
+// zero bytes to the same size, so inputs which differ only in trailing
+// zero bytes can't be separated by masks.
+const maxMaskInputSize = 256
+
+// maxMaskIndex is the maximum number of bits active in Mask.
+const maxMaskIndex = maxMaskInputSize * 8
+
+// ErrMaskInputTooLarge is returned for inputs larger than maxMaskInputSize.
+var ErrMaskInputTooLarge = fmt.Errorf("mask input is larger than %d bytes", maxMaskInputSize)
+
+// ErrMaskInputsNotSeparable is returned by NewSplitMasks for inputs
+// which have the same bits when padded to the same size.
+var ErrMaskInputsNotSeparable = errors.New("mask inputs have the same bits")
 
 type Mask struct {
 	index uint32 // number of bits active as mask
-	bytes []byte // TODO replace me with a bitset or a fixed size bytes
+	bytes []byte // active bits, (index+7)/8 bytes
 }
 
 func NewAcceptAllMask() Mask {
-	return Mask{
-		index: 0,
-		bytes: make([]byte, 32),
-	}
+	return Mask{index: 0}
 }
 
-func NewSplitMasks(parent Mask, inp1 string, inp2 string) (Mask, Mask) {
+// NewSplitMasks splits parent at the first bit where inp1 and inp2 differ.
+// Both masks accept inputs with the same bits as inp1 and inp2 before that bit,
+// left mask accepts inputs with bit 0 and right mask inputs with bit 1 at that bit.
+// inp1 and inp2 must be members of parent.
+func NewSplitMasks(parent Mask, inp1 string, inp2 string) (Mask, Mask, error) {
+	for _, inp := range []string{inp1, inp2} {
+		member, err := parent.IsMember(inp)
+		if err != nil {
+			return Mask{}, Mask{}, err
+		}
+		if !member {
+			return Mask{}, Mask{}, errors.New("mask input isn't member of parent mask")
+		}
+	}
+
 	// find first bit diff between inp1 and inp2
+	lastCommonBit, err := findLastCommonBit(inp1, inp2)
+	if err != nil {
+		return Mask{}, Mask{}, err
+	}
+	if lastCommonBit >= len(inp1)*8 && lastCommonBit >= len(inp2)*8 {
+		return Mask{}, Mask{}, ErrMaskInputsNotSeparable
+	}
+	index := uint32(lastCommonBit + 1)
 
-	index := uint32(findLastCommonBit(inp1, inp2))
-	leftMask := Mask{index: index + 1,
-		bytes: make([]byte, 32)}
-	copy(leftMask.bytes, parent.bytes)
+	prefix := parent.extend(inp1, index)
+	return prefix.child(0), prefix.child(1), nil
+}
+
+// extend returns mask accepting inputs with the same bits as m,
+// followed by the same bits as inp up to index.
+func (m Mask) extend(inp string, index uint32) Mask {
+	mask := Mask{index: index, bytes: make([]byte, (index+7)/8)}
+	copy(mask.bytes, m.bytes)
+	for i := m.index; i < index; i++ {
+		if inputBit(inp, int(i)) == 1 {
+			SetBit(mask.bytes, int(i))
+		}
+	}
+	return mask
+}
 
-	rightMask := Mask{index: index + 1,
-		bytes: make([]byte, 32)}
+// child returns mask accepting members of m with given bit after bits of m.
+// m.index must be less than maxMaskIndex.
+func (m Mask) child(bit int) Mask {
+	mask := Mask{index: m.index + 1, bytes: make([]byte, (m.index+8)/8)}
+	copy(mask.bytes, m.bytes)
+	if bit == 1 {
+		SetBit(mask.bytes, int(m.index))
+	}
+	return mask
+}
 
-	copy(rightMask.bytes, parent.bytes)
-	SetBit(rightMask.bytes, int(parent.index))
-	return leftMask, rightMask
+// parent returns mask accepting members of m and its sibling.
+// m must not accept all inputs.
+func (m Mask) parent() Mask {
+	mask := Mask{index: m.index - 1, bytes: make([]byte, (m.index+6)/8)}
+	copy(mask.bytes, m.bytes)
+	if mask.index%8 != 0 {
+		mask.bytes[mask.index>>3] &^= 1 << (7 - mask.index&7)
+	}
+	return mask
 }
 
-func (m Mask) IsMember(inp string) bool {
-	// if index is zero all values are member
-	if m.index == 0 {
-		return true
+// contains returns true if all members of other are members of m.
+func (m Mask) contains(other Mask) bool {
+	if other.index < m.index {
+		return false
 	}
 	for i := 0; i < int(m.index); i++ {
-		if Bit(m.bytes, i) != Bit([]byte(inp), i) {
+		if Bit(m.bytes, i) != Bit(other.bytes, i) {
 			return false
 		}
 	}
 	return true
 }
 
+// isSibling returns true if m and other differ only in their last bit.
+func (m Mask) isSibling(other Mask) bool {
+	return m.index > 0 && m.index == other.index &&
+		!m.equal(other) && m.parent().equal(other.parent())
+}
+
+func (m Mask) equal(other Mask) bool {
+	return m.index == other.index && bytes.Equal(m.bytes, other.bytes)
+}
+
+// less returns true if members of m are ordered before members of other.
+// m and other must not accept the same inputs.
+func (m Mask) less(other Mask) bool {
+	return bytes.Compare(m.bytes, other.bytes) < 0
+}
+
+// encodedSize returns size of encoded mask:
+// index (2 bytes) + bytes of active bits.
+func (m Mask) encodedSize() uint32 {
+	return 2 + (m.index+7)/8
+}
+
+// encode writes encoded mask to buf and returns number of bytes written.
+func (m Mask) encode(buf []byte) int {
+	binary.BigEndian.PutUint16(buf, uint16(m.index))
+	return 2 + copy(buf[2:], m.bytes)
+}
+
+// decodeMask decodes mask encoded by encode and returns number of bytes read.
+func decodeMask(data []byte) (Mask, int, error) {
+	if len(data) < 2 {
+		return Mask{}, 0, errors.New("too short for mask")
+	}
+
+	mask := Mask{index: uint32(binary.BigEndian.Uint16(data))}
+
+	if mask.index > maxMaskIndex {
+		return Mask{}, 0, fmt.Errorf("mask index %d is too large", mask.index)
+	}
+	if uint32(len(data)) < mask.encodedSize() {
+		return Mask{}, 0, errors.New("too short for mask")
+	}
+
+	mask.bytes = make([]byte, (mask.index+7)/8)
+	copy(mask.bytes, data[2:])
+	return mask, int(mask.encodedSize()), nil
+}
+
+// IsMember returns true if inp has the same bits as active bits of mask.
+func (m Mask) IsMember(inp string) (bool, error) {
+	if len(inp) > maxMaskInputSize {
+		return false, ErrMaskInputTooLarge
+	}
+	for i := 0; i < int(m.index); i++ {
+		if Bit(m.bytes, i) != inputBit(inp, i) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func (m Mask) Print() {
 	fmt.Printf("mask: ")
 	for i := 0; i < int(m.index); i++ {
@@ -59,13 +184,38 @@ func (m Mask) Print() {
 
 }
 
-func findLastCommonBit(inp1 string, inp2 string) int {
-	// TODO deal with variable sizes
-	for i := 0; i < len(inp1)*8; i++ {
-		if Bit([]byte(inp1), i) != Bit([]byte(inp2), i) {
-			// TODO i == 0 should never happen
-			return i - 1
+// inputBit returns the bit at index idx of inp, or 0 if inp is shorter than idx bits.
+func inputBit(inp string, idx int) int {
+	if idx>>3 >= len(inp) {
+		return 0
+	}
+	return int(inp[idx>>3]>>(7-idx&7)) & 1
+}
+
+// findLastCommonBit returns index of the last bit before the first bit
+// where inp1 and inp2 differ, or -1 if they differ at the first bit.
+// If inputs have the same bits, it returns the number of bits in the larger input.
+func findLastCommonBit(inp1 string, inp2 string) (int, error) {
+	if len(inp1) > maxMaskInputSize || len(inp2) > maxMaskInputSize {
+		return 0, ErrMaskInputTooLarge
+	}
+
+	size := len(inp1)
+	if len(inp2) > size {
+		size = len(inp2)
+	}
+
+	for i := 0; i < size; i++ {
+		var b1, b2 byte
+		if i < len(inp1) {
+			b1 = inp1[i]
+		}
+		if i < len(inp2) {
+			b2 = inp2[i]
+		}
+		if b1 != b2 {
+			return i*8 + bits.LeadingZeros8(b1^b2) - 1, nil
 		}
 	}
-	return len(inp1) * 8
+	return size * 8, nil
 }
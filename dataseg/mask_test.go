@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func isMember(t *testing.T, m Mask, inp string) bool {
+	member, err := m.IsMember(inp)
+	require.NoError(t, err)
+	return member
+}
+
+func TestNewSplitMasks(t *testing.T) {
+
+	inp1 := string([]byte{0b1010_0000, 0x00})
+	inp2 := string([]byte{0b1011_0000, 0xff})
+
+	left, right, err := NewSplitMasks(NewAcceptAllMask(), inp1, inp2)
+	require.NoError(t, err)
+
+	// First different bit is bit 3
+	assert.Equal(t, uint32(4), left.index)
+	assert.Equal(t, uint32(4), right.index)
+
+	assert.True(t, isMember(t, left, inp1))
+	assert.False(t, isMember(t, left, inp2))
+	assert.False(t, isMember(t, right, inp1))
+	assert.True(t, isMember(t, right, inp2))
+
+	// Inputs which differ from inp1 and inp2 before bit 3 aren't members
+	other := string([]byte{0b0010_0000, 0x00})
+	assert.False(t, isMember(t, left, other))
+	assert.False(t, isMember(t, right, other))
+
+	assert.True(t, left.isSibling(right))
+	assert.True(t, left.less(right))
+	assert.True(t, left.parent().equal(right.parent()))
+	assert.True(t, isMember(t, left.parent(), inp1))
+	assert.True(t, isMember(t, left.parent(), inp2))
+
+	left2, right2, err := NewSplitMasks(left, inp1, string([]byte{0b1010_0001, 0x00}))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(8), left2.index)
+	assert.False(t, left2.isSibling(right))
+	assert.True(t, left2.isSibling(right2))
+	assert.True(t, left.contains(left2))
+	assert.False(t, right.contains(left2))
+
+	// Inputs must be members of parent
+	_, _, err = NewSplitMasks(right, inp1, inp2)
+	require.Error(t, err)
+}
+
+func TestMaskVariableSizeInputs(t *testing.T) {
+
+	t.Run("different sizes", func(t *testing.T) {
+		inp1 := "a"
+		inp2 := "a\x00\x01"
+
+		lastCommonBit, err := findLastCommonBit(inp1, inp2)
+		require.NoError(t, err)
+		assert.Equal(t, 22, lastCommonBit)
+
+		lastCommonBit, err = findLastCommonBit(inp2, inp1)
+		require.NoError(t, err)
+		assert.Equal(t, 22, lastCommonBit)
+
+		left, right, err := NewSplitMasks(NewAcceptAllMask(), inp1, inp2)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(24), left.index)
+
+		assert.True(t, isMember(t, left, inp1))
+		assert.True(t, isMember(t, left, "a\x00"))
+		assert.True(t, isMember(t, right, inp2))
+		assert.True(t, isMember(t, right, "a\x00\x01\x02"))
+		assert.False(t, isMember(t, right, inp1))
+	})
+
+	t.Run("large inputs", func(t *testing.T) {
+		prefix := strings.Repeat("k", 100)
+		inp1 := prefix + "1"
+		inp2 := prefix + "2"
+
+		left, right, err := NewSplitMasks(NewAcceptAllMask(), inp1, inp2)
+		require.NoError(t, err)
+		assert.True(t, left.index > 800)
+		assert.True(t, isMember(t, left, inp1))
+		assert.True(t, isMember(t, right, inp2))
+
+		buf := make([]byte, right.encodedSize())
+		right.encode(buf)
+		decoded, _, err := decodeMask(buf)
+		require.NoError(t, err)
+		assert.True(t, right.equal(decoded))
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		inp := strings.Repeat("k", maxMaskInputSize)
+		tooLarge := inp + "k"
+
+		_, err := NewAcceptAllMask().IsMember(tooLarge)
+		require.Equal(t, ErrMaskInputTooLarge, err)
+
+		_, err = findLastCommonBit(inp, tooLarge)
+		require.Equal(t, ErrMaskInputTooLarge, err)
+
+		_, _, err = NewSplitMasks(NewAcceptAllMask(), inp, tooLarge)
+		require.Equal(t, ErrMaskInputTooLarge, err)
+
+		// Inputs which differ in the last bit can be split
+		last := []byte(inp)
+		last[maxMaskInputSize-1] ^= 1
+		left, right, err := NewSplitMasks(NewAcceptAllMask(), inp, string(last))
+		require.NoError(t, err)
+		assert.Equal(t, uint32(maxMaskIndex), left.index)
+		assert.Equal(t, uint32(maxMaskIndex), right.index)
+	})
+
+	t.Run("not separable", func(t *testing.T) {
+		_, _, err := NewSplitMasks(NewAcceptAllMask(), "key", "key")
+		require.Equal(t, ErrMaskInputsNotSeparable, err)
+
+		// Trailing zero bytes are the same as padding
+		_, _, err = NewSplitMasks(NewAcceptAllMask(), "key", "key\x00\x00")
+		require.Equal(t, ErrMaskInputsNotSeparable, err)
+
+		_, _, err = NewSplitMasks(NewAcceptAllMask(), "", "")
+		require.Equal(t, ErrMaskInputsNotSeparable, err)
+	})
+}
+
+func TestMaskEncodeDecode(t *testing.T) {
+
+	masks := []Mask{NewAcceptAllMask()}
+	for i := 0; i < 20; i++ {
+		masks = append(masks, masks[len(masks)-1].child(i%3%2))
+	}
+
+	for _, mask := range masks {
+		buf := make([]byte, mask.encodedSize())
+		n := mask.encode(buf)
+		assert.Equal(t, int(mask.encodedSize()), n)
+
+		decoded, n, err := decodeMask(buf)
+		require.NoError(t, err)
+		assert.Equal(t, int(mask.encodedSize()), n)
+		assert.True(t, mask.equal(decoded))
+
+		if mask.index > 0 {
+			assert.True(t, mask.parent().equal(masks[mask.index-1]))
+		}
+	}
+
+	_, _, err := decodeMask([]byte{0, 9, 0xff})
+	require.Error(t, err)
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// Integer and fixed-point values are encoded as CBOR tag with fixed size
+// content, so ByteSize() of a type is the same for all its values,
+// like UInt32Value.
+
+// fixedIntByteSize returns encoded size of integer with n bytes content:
+// tag number (2 bytes) + integer head (1 byte) + content (n bytes).
+func fixedIntByteSize(n int) uint32 {
+	return 3 + uint32(n)
+}
+
+// encodeFixedInt encodes v as
+//
+//	cbor.Tag{
+//			Number:  tag,
+//			Content: v as CBOR integer with n bytes argument,
+//	}
+//
+// Negative v is encoded as CBOR negative integer (major type 1) with
+// argument -1-v.
+func encodeFixedInt(tag byte, n int, v int64) []byte {
+	if v < 0 {
+		return encodeFixedUint(tag, n, 0x20, uint64(-1-v))
+	}
+	return encodeFixedUint(tag, n, 0, uint64(v))
+}
+
+func encodeFixedUint(tag byte, n int, major byte, v uint64) []byte {
+	buf := make([]byte, fixedIntByteSize(n))
+
+	buf[0] = 0xd8
+	buf[1] = tag
+	switch n {
+	case 1:
+		buf[2] = major | 24
+		buf[3] = byte(v)
+	case 2:
+		buf[2] = major | 25
+		binary.BigEndian.PutUint16(buf[3:], uint16(v))
+	case 4:
+		buf[2] = major | 26
+		binary.BigEndian.PutUint32(buf[3:], uint32(v))
+	case 8:
+		buf[2] = major | 27
+		binary.BigEndian.PutUint64(buf[3:], v)
+	}
+
+	return buf
+}
+
+// decodeFixedUint decodes data encoded by encodeFixedUint and returns
+// CBOR major type and argument.
+func decodeFixedUint(b []byte, tag byte, n int) (byte, uint64, error) {
+	if len(b) < int(fixedIntByteSize(n)) {
+		return 0, 0, fmt.Errorf("too short for integer type with tag %d", tag)
+	}
+
+	if b[0] != 0xd8 || b[1] != tag {
+		return 0, 0, fmt.Errorf("not integer type with tag %d", tag)
+	}
+
+	major := b[2] & 0xe0
+
+	var ai byte
+	var v uint64
+	switch n {
+	case 1:
+		ai = 24
+		v = uint64(b[3])
+	case 2:
+		ai = 25
+		v = uint64(binary.BigEndian.Uint16(b[3:]))
+	case 4:
+		ai = 26
+		v = uint64(binary.BigEndian.Uint32(b[3:]))
+	case 8:
+		ai = 27
+		v = binary.BigEndian.Uint64(b[3:])
+	}
+
+	if b[2]&0x1f != ai || (major != 0 && major != 0x20) {
+		return 0, 0, fmt.Errorf("wrong integer head 0x%x for tag %d", b[2], tag)
+	}
+
+	return major, v, nil
+}
+
+func decodeFixedUnsignedInt(b []byte, tag byte, n int) (uint64, error) {
+	major, v, err := decodeFixedUint(b, tag, n)
+	if err != nil {
+		return 0, err
+	}
+	if major != 0 {
+		return 0, fmt.Errorf("negative integer for unsigned type with tag %d", tag)
+	}
+	return v, nil
+}
+
+func decodeFixedInt(b []byte, tag byte, n int) (int64, error) {
+	major, v, err := decodeFixedUint(b, tag, n)
+	if err != nil {
+		return 0, err
+	}
+
+	// Argument of signed integer uses one bit less than content.
+	if v>>(8*n-1) != 0 {
+		return 0, fmt.Errorf("integer out of range for type with tag %d", tag)
+	}
+
+	if major != 0 {
+		return -1 - int64(v), nil
+	}
+	return int64(v), nil
+}
+
+// bigIntByteSize returns encoded size of big integer with n bytes content:
+// tag number (2 bytes) + bignum tag (1 byte) + byte string head (1 or 2 bytes) + content (n bytes).
+func bigIntByteSize(n int) uint32 {
+	if n < 24 {
+		return 4 + uint32(n)
+	}
+	return 5 + uint32(n)
+}
+
+// encodeBigInt encodes v as
+//
+//	cbor.Tag{
+//			Number:  tag,
+//			Content: cbor.Tag{
+//				Number:  2 (positive bignum) or 3 (negative bignum),
+//				Content: []byte(v or -1-v as n bytes big-endian),
+//			},
+//	}
+//
+// Unlike CBOR bignum, content is padded to n bytes so that all values
+// of the type have the same size.
+func encodeBigInt(tag byte, n int, signed bool, v *big.Int) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("nil big integer for type with tag %d", tag)
+	}
+
+	bits := 8 * n
+	if signed {
+		bits--
+	}
+
+	bignumTag := byte(0xc2)
+	content := v
+	if v.Sign() < 0 {
+		if !signed {
+			return nil, fmt.Errorf("negative integer for unsigned type with tag %d", tag)
+		}
+		bignumTag = 0xc3
+		content = new(big.Int).Neg(v)
+		content.Sub(content, big.NewInt(1))
+	}
+
+	if content.BitLen() > bits {
+		return nil, fmt.Errorf("integer out of range for type with tag %d", tag)
+	}
+
+	buf := make([]byte, bigIntByteSize(n))
+
+	buf[0] = 0xd8
+	buf[1] = tag
+	buf[2] = bignumTag
+
+	i := 3
+	if n < 24 {
+		buf[i] = 0x40 | byte(n)
+	} else {
+		buf[i] = 0x40 | 24
+		i++
+		buf[i] = byte(n)
+	}
+	i++
+
+	content.FillBytes(buf[i:])
+
+	return buf, nil
+}
+
+func decodeBigInt(b []byte, tag byte, n int, signed bool) (*big.Int, error) {
+	if len(b) < int(bigIntByteSize(n)) {
+		return nil, fmt.Errorf("too short for integer type with tag %d", tag)
+	}
+
+	if b[0] != 0xd8 || b[1] != tag {
+		return nil, fmt.Errorf("not integer type with tag %d", tag)
+	}
+
+	negative := false
+	switch b[2] {
+	case 0xc2:
+	case 0xc3:
+		if !signed {
+			return nil, fmt.Errorf("negative integer for unsigned type with tag %d", tag)
+		}
+		negative = true
+	default:
+		return nil, fmt.Errorf("not bignum for type with tag %d", tag)
+	}
+
+	i := 3
+	if n < 24 {
+		if b[i] != 0x40|byte(n) {
+			return nil, fmt.Errorf("wrong bignum size for type with tag %d", tag)
+		}
+	} else {
+		if b[i] != 0x40|24 || b[i+1] != byte(n) {
+			return nil, fmt.Errorf("wrong bignum size for type with tag %d", tag)
+		}
+		i++
+	}
+	i++
+
+	v := new(big.Int).SetBytes(b[i : i+n])
+
+	if signed && v.BitLen() > 8*n-1 {
+		return nil, fmt.Errorf("integer out of range for type with tag %d", tag)
+	}
+
+	if negative {
+		v.Add(v, big.NewInt(1))
+		v.Neg(v)
+	}
+
+	return v, nil
+}
+
+type Int8Serializable struct {
+	v Int8Value
+}
+
+func (s *Int8Serializable) Encode() ([]byte, error) {
+	return encodeFixedInt(cborTagInt8Value, 1, int64(s.v)), nil
+}
+
+func (s *Int8Serializable) Decode(b []byte) error {
+	v, err := decodeFixedInt(b, cborTagInt8Value, 1)
+	if err != nil {
+		return err
+	}
+	s.v = Int8Value(v)
+	return nil
+}
+
+func (s *Int8Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(1)
+}
+
+func (s *Int8Serializable) IsConstantSized() bool { return true }
+
+func (s *Int8Serializable) GetValue() Value {
+	return s.v
+}
+
+type Int16Serializable struct {
+	v Int16Value
+}
+
+func (s *Int16Serializable) Encode() ([]byte, error) {
+	return encodeFixedInt(cborTagInt16Value, 2, int64(s.v)), nil
+}
+
+func (s *Int16Serializable) Decode(b []byte) error {
+	v, err := decodeFixedInt(b, cborTagInt16Value, 2)
+	if err != nil {
+		return err
+	}
+	s.v = Int16Value(v)
+	return nil
+}
+
+func (s *Int16Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(2)
+}
+
+func (s *Int16Serializable) IsConstantSized() bool { return true }
+
+func (s *Int16Serializable) GetValue() Value {
+	return s.v
+}
+
+type Int32Serializable struct {
+	v Int32Value
+}
+
+func (s *Int32Serializable) Encode() ([]byte, error) {
+	return encodeFixedInt(cborTagInt32Value, 4, int64(s.v)), nil
+}
+
+func (s *Int32Serializable) Decode(b []byte) error {
+	v, err := decodeFixedInt(b, cborTagInt32Value, 4)
+	if err != nil {
+		return err
+	}
+	s.v = Int32Value(v)
+	return nil
+}
+
+func (s *Int32Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(4)
+}
+
+func (s *Int32Serializable) IsConstantSized() bool { return true }
+
+func (s *Int32Serializable) GetValue() Value {
+	return s.v
+}
+
+type Int64Serializable struct {
+	v Int64Value
+}
+
+func (s *Int64Serializable) Encode() ([]byte, error) {
+	return encodeFixedInt(cborTagInt64Value, 8, int64(s.v)), nil
+}
+
+func (s *Int64Serializable) Decode(b []byte) error {
+	v, err := decodeFixedInt(b, cborTagInt64Value, 8)
+	if err != nil {
+		return err
+	}
+	s.v = Int64Value(v)
+	return nil
+}
+
+func (s *Int64Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(8)
+}
+
+func (s *Int64Serializable) IsConstantSized() bool { return true }
+
+func (s *Int64Serializable) GetValue() Value {
+	return s.v
+}
+
+type Int128Serializable struct {
+	v Int128Value
+}
+
+func (s *Int128Serializable) Encode() ([]byte, error) {
+	return encodeBigInt(cborTagInt128Value, 16, true, s.v.BigInt)
+}
+
+func (s *Int128Serializable) Decode(b []byte) error {
+	v, err := decodeBigInt(b, cborTagInt128Value, 16, true)
+	if err != nil {
+		return err
+	}
+	s.v = Int128Value{BigInt: v}
+	return nil
+}
+
+func (s *Int128Serializable) ByteSize() uint32 {
+	return bigIntByteSize(16)
+}
+
+func (s *Int128Serializable) IsConstantSized() bool { return true }
+
+func (s *Int128Serializable) GetValue() Value {
+	return s.v
+}
+
+type Int256Serializable struct {
+	v Int256Value
+}
+
+func (s *Int256Serializable) Encode() ([]byte, error) {
+	return encodeBigInt(cborTagInt256Value, 32, true, s.v.BigInt)
+}
+
+func (s *Int256Serializable) Decode(b []byte) error {
+	v, err := decodeBigInt(b, cborTagInt256Value, 32, true)
+	if err != nil {
+		return err
+	}
+	s.v = Int256Value{BigInt: v}
+	return nil
+}
+
+func (s *Int256Serializable) ByteSize() uint32 {
+	return bigIntByteSize(32)
+}
+
+func (s *Int256Serializable) IsConstantSized() bool { return true }
+
+func (s *Int256Serializable) GetValue() Value {
+	return s.v
+}
+
+type UInt8Serializable struct {
+	v UInt8Value
+}
+
+func (s *UInt8Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagUInt8Value, 1, 0, uint64(s.v)), nil
+}
+
+func (s *UInt8Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagUInt8Value, 1)
+	if err != nil {
+		return err
+	}
+	s.v = UInt8Value(v)
+	return nil
+}
+
+func (s *UInt8Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(1)
+}
+
+func (s *UInt8Serializable) IsConstantSized() bool { return true }
+
+func (s *UInt8Serializable) GetValue() Value {
+	return s.v
+}
+
+type UInt16Serializable struct {
+	v UInt16Value
+}
+
+func (s *UInt16Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagUInt16Value, 2, 0, uint64(s.v)), nil
+}
+
+func (s *UInt16Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagUInt16Value, 2)
+	if err != nil {
+		return err
+	}
+	s.v = UInt16Value(v)
+	return nil
+}
+
+func (s *UInt16Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(2)
+}
+
+func (s *UInt16Serializable) IsConstantSized() bool { return true }
+
+func (s *UInt16Serializable) GetValue() Value {
+	return s.v
+}
+
+type UInt64Serializable struct {
+	v UInt64Value
+}
+
+func (s *UInt64Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagUInt64Value, 8, 0, uint64(s.v)), nil
+}
+
+func (s *UInt64Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagUInt64Value, 8)
+	if err != nil {
+		return err
+	}
+	s.v = UInt64Value(v)
+	return nil
+}
+
+func (s *UInt64Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(8)
+}
+
+func (s *UInt64Serializable) IsConstantSized() bool { return true }
+
+func (s *UInt64Serializable) GetValue() Value {
+	return s.v
+}
+
+type UInt128Serializable struct {
+	v UInt128Value
+}
+
+func (s *UInt128Serializable) Encode() ([]byte, error) {
+	return encodeBigInt(cborTagUInt128Value, 16, false, s.v.BigInt)
+}
+
+func (s *UInt128Serializable) Decode(b []byte) error {
+	v, err := decodeBigInt(b, cborTagUInt128Value, 16, false)
+	if err != nil {
+		return err
+	}
+	s.v = UInt128Value{BigInt: v}
+	return nil
+}
+
+func (s *UInt128Serializable) ByteSize() uint32 {
+	return bigIntByteSize(16)
+}
+
+func (s *UInt128Serializable) IsConstantSized() bool { return true }
+
+func (s *UInt128Serializable) GetValue() Value {
+	return s.v
+}
+
+type UInt256Serializable struct {
+	v UInt256Value
+}
+
+func (s *UInt256Serializable) Encode() ([]byte, error) {
+	return encodeBigInt(cborTagUInt256Value, 32, false, s.v.BigInt)
+}
+
+func (s *UInt256Serializable) Decode(b []byte) error {
+	v, err := decodeBigInt(b, cborTagUInt256Value, 32, false)
+	if err != nil {
+		return err
+	}
+	s.v = UInt256Value{BigInt: v}
+	return nil
+}
+
+func (s *UInt256Serializable) ByteSize() uint32 {
+	return bigIntByteSize(32)
+}
+
+func (s *UInt256Serializable) IsConstantSized() bool { return true }
+
+func (s *UInt256Serializable) GetValue() Value {
+	return s.v
+}
+
+type Word8Serializable struct {
+	v Word8Value
+}
+
+func (s *Word8Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagWord8Value, 1, 0, uint64(s.v)), nil
+}
+
+func (s *Word8Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagWord8Value, 1)
+	if err != nil {
+		return err
+	}
+	s.v = Word8Value(v)
+	return nil
+}
+
+func (s *Word8Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(1)
+}
+
+func (s *Word8Serializable) IsConstantSized() bool { return true }
+
+func (s *Word8Serializable) GetValue() Value {
+	return s.v
+}
+
+type Word16Serializable struct {
+	v Word16Value
+}
+
+func (s *Word16Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagWord16Value, 2, 0, uint64(s.v)), nil
+}
+
+func (s *Word16Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagWord16Value, 2)
+	if err != nil {
+		return err
+	}
+	s.v = Word16Value(v)
+	return nil
+}
+
+func (s *Word16Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(2)
+}
+
+func (s *Word16Serializable) IsConstantSized() bool { return true }
+
+func (s *Word16Serializable) GetValue() Value {
+	return s.v
+}
+
+type Word32Serializable struct {
+	v Word32Value
+}
+
+func (s *Word32Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagWord32Value, 4, 0, uint64(s.v)), nil
+}
+
+func (s *Word32Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagWord32Value, 4)
+	if err != nil {
+		return err
+	}
+	s.v = Word32Value(v)
+	return nil
+}
+
+func (s *Word32Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(4)
+}
+
+func (s *Word32Serializable) IsConstantSized() bool { return true }
+
+func (s *Word32Serializable) GetValue() Value {
+	return s.v
+}
+
+type Word64Serializable struct {
+	v Word64Value
+}
+
+func (s *Word64Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagWord64Value, 8, 0, uint64(s.v)), nil
+}
+
+func (s *Word64Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagWord64Value, 8)
+	if err != nil {
+		return err
+	}
+	s.v = Word64Value(v)
+	return nil
+}
+
+func (s *Word64Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(8)
+}
+
+func (s *Word64Serializable) IsConstantSized() bool { return true }
+
+func (s *Word64Serializable) GetValue() Value {
+	return s.v
+}
+
+type Fix64Serializable struct {
+	v Fix64Value
+}
+
+func (s *Fix64Serializable) Encode() ([]byte, error) {
+	return encodeFixedInt(cborTagFix64Value, 8, int64(s.v)), nil
+}
+
+func (s *Fix64Serializable) Decode(b []byte) error {
+	v, err := decodeFixedInt(b, cborTagFix64Value, 8)
+	if err != nil {
+		return err
+	}
+	s.v = Fix64Value(v)
+	return nil
+}
+
+func (s *Fix64Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(8)
+}
+
+func (s *Fix64Serializable) IsConstantSized() bool { return true }
+
+func (s *Fix64Serializable) GetValue() Value {
+	return s.v
+}
+
+type UFix64Serializable struct {
+	v UFix64Value
+}
+
+func (s *UFix64Serializable) Encode() ([]byte, error) {
+	return encodeFixedUint(cborTagUFix64Value, 8, 0, uint64(s.v)), nil
+}
+
+func (s *UFix64Serializable) Decode(b []byte) error {
+	v, err := decodeFixedUnsignedInt(b, cborTagUFix64Value, 8)
+	if err != nil {
+		return err
+	}
+	s.v = UFix64Value(v)
+	return nil
+}
+
+func (s *UFix64Serializable) ByteSize() uint32 {
+	return fixedIntByteSize(8)
+}
+
+func (s *UFix64Serializable) IsConstantSized() bool { return true }
+
+func (s *UFix64Serializable) GetValue() Value {
+	return s.v
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fix64Factor is the number of units of fixed-point values in 1.
+const fix64Factor = 100_000_000
+
+func bigIntPow2(n uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), n)
+}
+
+// mixedIntegerValues returns minimum and maximum values of all integer types.
+func mixedIntegerValues() []Value {
+	minInt128 := new(big.Int).Neg(bigIntPow2(127))
+	maxInt128 := new(big.Int).Sub(bigIntPow2(127), big.NewInt(1))
+	minInt256 := new(big.Int).Neg(bigIntPow2(255))
+	maxInt256 := new(big.Int).Sub(bigIntPow2(255), big.NewInt(1))
+	maxUInt128 := new(big.Int).Sub(bigIntPow2(128), big.NewInt(1))
+	maxUInt256 := new(big.Int).Sub(bigIntPow2(256), big.NewInt(1))
+
+	return []Value{
+		Int8Value(math.MinInt8),
+		Int8Value(math.MaxInt8),
+		Int16Value(math.MinInt16),
+		Int16Value(math.MaxInt16),
+		Int32Value(math.MinInt32),
+		Int32Value(math.MaxInt32),
+		Int64Value(math.MinInt64),
+		Int64Value(math.MaxInt64),
+		NewInt128ValueFromBigInt(minInt128),
+		NewInt128ValueFromBigInt(maxInt128),
+		NewInt256ValueFromBigInt(minInt256),
+		NewInt256ValueFromBigInt(maxInt256),
+		UInt8Value(math.MaxUint8),
+		UInt16Value(math.MaxUint16),
+		UInt32Value(math.MaxUint32),
+		UInt64Value(math.MaxUint64),
+		NewUInt128ValueFromBigInt(maxUInt128),
+		NewUInt256ValueFromBigInt(maxUInt256),
+		Word8Value(math.MaxUint8),
+		Word16Value(math.MaxUint16),
+		Word32Value(math.MaxUint32),
+		Word64Value(math.MaxUint64),
+	}
+}
+
+func TestIntegerEncodeDecode(t *testing.T) {
+
+	values := mixedIntegerValues()
+	values = append(values,
+		Int8Value(-1),
+		Int64Value(-1),
+		NewInt128ValueFromInt64(-1),
+		NewInt256ValueFromInt64(-1),
+		NewInt128ValueFromInt64(1),
+		NewUInt256ValueFromUint64(1),
+	)
+
+	for _, v := range values {
+		s := v.GetSerizable()
+		assert.True(t, s.IsConstantSized())
+
+		b, err := s.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, int(s.ByteSize()), len(b), "%T", v)
+
+		decoded, rest, err := decodeSerializable(append(b, 0xff))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xff}, rest)
+		assert.IsType(t, s, decoded)
+		assert.Equal(t, s.ByteSize(), decoded.ByteSize())
+
+		b2, err := decoded.GetValue().GetSerizable().Encode()
+		require.NoError(t, err)
+		assert.Equal(t, b, b2, "%T", v)
+
+		_, _, err = decodeSerializable(b[:len(b)-1])
+		require.Error(t, err)
+	}
+
+	// CBOR encoding of small values
+	b, err := Int8Value(-1).GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xd8, cborTagInt8Value, 0x38, 0x00}, b)
+
+	b, err = UInt16Value(0x0102).GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xd8, cborTagUInt16Value, 0x19, 0x01, 0x02}, b)
+
+	b, err = NewInt128ValueFromInt64(-2).GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0xd8, cborTagInt128Value, 0xc3, 0x50}, append(make([]byte, 15), 0x01)...), b)
+
+	// Big integer values
+	v, _, err := decodeSerializable(b)
+	require.NoError(t, err)
+	assert.Equal(t, 0, v.GetValue().(Int128Value).BigInt.Cmp(big.NewInt(-2)))
+}
+
+func TestIntegerOutOfRange(t *testing.T) {
+
+	values := []Value{
+		NewInt128ValueFromBigInt(bigIntPow2(127)),
+		NewInt128ValueFromBigInt(new(big.Int).Sub(new(big.Int).Neg(bigIntPow2(127)), big.NewInt(1))),
+		NewInt256ValueFromBigInt(bigIntPow2(255)),
+		NewUInt128ValueFromBigInt(bigIntPow2(128)),
+		NewUInt128ValueFromBigInt(big.NewInt(-1)),
+		NewUInt256ValueFromBigInt(bigIntPow2(256)),
+		UInt128Value{},
+	}
+
+	for _, v := range values {
+		_, err := v.GetSerizable().Encode()
+		require.Error(t, err, "%T", v)
+	}
+
+	// Int8 content 0xff is out of range
+	_, _, err := decodeSerializable([]byte{0xd8, cborTagInt8Value, 0x18, 0xff})
+	require.Error(t, err)
+
+	// Unsigned integer can't be negative
+	_, _, err = decodeSerializable([]byte{0xd8, cborTagUInt8Value, 0x38, 0x00})
+	require.Error(t, err)
+
+	// Content size must match type
+	_, _, err = decodeSerializable([]byte{0xd8, cborTagUInt16Value, 0x18, 0x01, 0x00})
+	require.Error(t, err)
+}
+
+func TestFixedPointEncodeDecode(t *testing.T) {
+
+	values := []Value{
+		Fix64Value(math.MinInt64),
+		Fix64Value(-fix64Factor),
+		Fix64Value(0),
+		Fix64Value(math.MaxInt64),
+		UFix64Value(0),
+		UFix64Value(fix64Factor / 2),
+		UFix64Value(math.MaxUint64),
+	}
+
+	for _, v := range values {
+		s := v.GetSerizable()
+		assert.True(t, s.IsConstantSized())
+
+		b, err := s.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, int(s.ByteSize()), len(b))
+
+		decoded, rest, err := decodeSerializable(b)
+		require.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.Equal(t, v, decoded.GetValue())
+	}
+
+	b, err := Fix64Value(-1).GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xd8, cborTagFix64Value, 0x3b, 0, 0, 0, 0, 0, 0, 0, 0}, b)
+
+	// UFix64 can't be negative
+	_, _, err = decodeSerializable([]byte{0xd8, cborTagUFix64Value, 0x3b, 0, 0, 0, 0, 0, 0, 0, 0})
+	require.Error(t, err)
+}
+
+func TestArrayOfBalances(t *testing.T) {
+
+	values := make([]Value, 1000)
+	for i := 0; i < len(values); i++ {
+		// 1.5, 3.0, 4.5, ...
+		values[i] = UFix64Value(uint64(i+1) * fix64Factor * 3 / 2)
+	}
+
+	array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+	assert.True(t, array.metaSlab.hasMetaChildren())
+	verifyArrayTree(t, array, values)
+
+	b, err := array.GetSerizable().Encode()
+	require.NoError(t, err)
+
+	array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+	require.NoError(t, err)
+	verifyArrayTree(t, array2, values)
+}
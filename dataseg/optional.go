@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+)
+
+type NilSerializable struct{}
+
+// Encode encodes NilValue as CBOR null.
+func (s *NilSerializable) Encode() ([]byte, error) {
+	return []byte{cborNil}, nil
+}
+
+func (s *NilSerializable) Decode(b []byte) error {
+	if len(b) < 1 {
+		return errors.New("too short for NilValue type")
+	}
+	if b[0] != cborNil {
+		return errors.New("not NilValue type")
+	}
+	return nil
+}
+
+func (s *NilSerializable) ByteSize() uint32 {
+	return 1
+}
+
+func (s *NilSerializable) IsConstantSized() bool { return true }
+
+func (s *NilSerializable) GetValue() Value {
+	return NilValue{}
+}
+
+// SomeSerializable wraps Serializable of the inner value.
+type SomeSerializable struct {
+	inner Serializable
+}
+
+// Encode encodes SomeValue as
+//
+//	cbor.Tag{
+//			Number:  cborTagSomeValue,
+//			Content: inner value,
+//	}
+func (s *SomeSerializable) Encode() ([]byte, error) {
+	b, err := s.inner.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2+len(b))
+	buf[0] = 0xd8
+	buf[1] = cborTagSomeValue
+	copy(buf[2:], b)
+
+	return buf, nil
+}
+
+func (s *SomeSerializable) Decode(b []byte) error {
+	if len(b) < 3 {
+		return errors.New("too short for SomeValue type")
+	}
+	if b[0] != 0xd8 || b[1] != cborTagSomeValue {
+		return errors.New("not SomeValue type")
+	}
+
+	inner, _, err := decodeSerializable(b[2:])
+	if err != nil {
+		return err
+	}
+	s.inner = inner
+	return nil
+}
+
+// ByteSize returns size of tag number (2 bytes) and inner value.
+func (s *SomeSerializable) ByteSize() uint32 {
+	return 2 + s.inner.ByteSize()
+}
+
+func (s *SomeSerializable) IsConstantSized() bool {
+	return s.inner.IsConstantSized()
+}
+
+func (s *SomeSerializable) GetValue() Value {
+	return SomeValue{Value: s.inner.GetValue()}
+}
+
+// holdsContainer returns true if optional value holds ArrayValue or
+// CompositeValue, or StorageID referencing one, directly or in nested
+// optional value. Such values are stored by StorageID of their root slab
+// instead of being encoded inline.
+func holdsContainer(value SomeValue) bool {
+	switch inner := value.Value.(type) {
+	case *ArrayValue, *CompositeValue, StorageID:
+		return true
+	case SomeValue:
+		return holdsContainer(inner)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoolOptionalEncodeDecode(t *testing.T) {
+
+	tests := []struct {
+		value   Value
+		encoded []byte
+	}{
+		{BoolValue(false), []byte{0xf4}},
+		{BoolValue(true), []byte{0xf5}},
+		{NilValue{}, []byte{0xf6}},
+		{SomeValue{Value: BoolValue(true)}, []byte{0xd8, cborTagSomeValue, 0xf5}},
+		{SomeValue{Value: NilValue{}}, []byte{0xd8, cborTagSomeValue, 0xf6}},
+		{SomeValue{Value: StringValue("ab")}, []byte{0xd8, cborTagSomeValue, 0x62, 'a', 'b'}},
+		{
+			SomeValue{Value: SomeValue{Value: Int8Value(1)}},
+			[]byte{0xd8, cborTagSomeValue, 0xd8, cborTagSomeValue, 0xd8, cborTagInt8Value, 0x18, 0x01},
+		},
+	}
+
+	for _, test := range tests {
+		s := test.value.GetSerizable()
+
+		b, err := s.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, test.encoded, b)
+		assert.Equal(t, int(s.ByteSize()), len(b))
+
+		decoded, rest, err := decodeSerializable(append(b, 0xff))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xff}, rest)
+		assert.Equal(t, s.ByteSize(), decoded.ByteSize())
+		assert.Equal(t, s.IsConstantSized(), decoded.IsConstantSized())
+		assert.Equal(t, test.value, decoded.GetValue())
+	}
+
+	assert.True(t, SomeValue{Value: UInt32Value(1)}.GetSerizable().IsConstantSized())
+	assert.False(t, SomeValue{Value: StringValue("")}.GetSerizable().IsConstantSized())
+
+	// Some without inner value
+	_, _, err := decodeSerializable([]byte{0xd8, cborTagSomeValue})
+	require.Error(t, err)
+
+	// Undefined simple value
+	_, _, err = decodeSerializable([]byte{0xf7})
+	require.Error(t, err)
+}
+
+func TestArrayBoolOptional(t *testing.T) {
+
+	t.Run("bool", func(t *testing.T) {
+		values := make([]Value, 500)
+		for i := 0; i < len(values); i++ {
+			values[i] = BoolValue(i%3 == 0)
+		}
+
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+		verifyArrayTree(t, array, values)
+
+		b, err := array.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		values := make([]Value, 500)
+		for i := 0; i < len(values); i++ {
+			if i%4 == 0 {
+				values[i] = NilValue{}
+			} else {
+				values[i] = SomeValue{Value: Int64Value(-i)}
+			}
+		}
+
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+		verifyArrayTree(t, array, values)
+
+		// Replace nil with some and some with nil, changing element sizes
+		for i := 0; i < len(values); i += 2 {
+			v := Value(NilValue{})
+			if i%4 == 0 {
+				v = SomeValue{Value: Int64Value(i)}
+			}
+			require.NoError(t, array.Set(uint32(i), v))
+			values[i] = v
+		}
+		verifyArrayTree(t, array, values)
+
+		b, err := array.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		array2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+	})
+}
+
+func TestOptionalContainer(t *testing.T) {
+
+	t.Run("array element", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		child := newTestArrayValue(t, storage, []Value{UInt32Value(1), UInt32Value(2)})
+		values := []Value{
+			SomeValue{Value: child},
+			SomeValue{Value: SomeValue{Value: newTestArrayValue(t, storage, nil)}},
+			SomeValue{Value: UInt32Value(3)},
+		}
+		array := newTestArrayValue(t, storage, values)
+		verifyArrayTree(t, array, values)
+
+		// Array in optional value is stored as StorageID instead of being inlined
+		node, err := array.node()
+		require.NoError(t, err)
+		element, err := node.Get(array.storage, 0)
+		require.NoError(t, err)
+		require.IsType(t, &SomeSerializable{}, element)
+		assert.Equal(t, child.ID(), *element.(*SomeSerializable).inner.(*StorageID))
+
+		// Changes of array in optional value are visible through parent array
+		v, err := array.Get(0)
+		require.NoError(t, err)
+		require.NoError(t, v.(SomeValue).Value.(*ArrayValue).Append(UInt32Value(3)))
+
+		committed := NewBasicSlabStorage()
+		require.NoError(t, child.Commit(committed))
+		require.NoError(t, values[1].(SomeValue).Value.(SomeValue).Value.(*ArrayValue).Commit(committed))
+		require.NoError(t, array.Commit(committed))
+
+		array2, err := NewArrayValueFromStorage(committed, array.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+
+		v, err = array2.Get(0)
+		require.NoError(t, err)
+		child2 := v.(SomeValue).Value.(*ArrayValue)
+		require.Equal(t, uint32(3), child2.Size())
+		e, err := child2.Get(2)
+		require.NoError(t, err)
+		assert.Equal(t, UInt32Value(3), e)
+
+		// Array in optional value is owned by parent array
+		require.Error(t, array.Append(child))
+		require.Error(t, array.Append(SomeValue{Value: child}))
+
+		// Replaced array is removed with its optional value
+		require.NoError(t, array.Set(0, NilValue{}))
+		values[0] = NilValue{}
+		verifyArrayTree(t, array, values)
+		_, found, err := storage.Retrieve(child.ID())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("composite element", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		composite := newTestCompositeValue(t, storage, testCompositeFields(2))
+		array := newTestArrayValue(t, storage, []Value{SomeValue{Value: composite}})
+
+		committed := NewBasicSlabStorage()
+		require.NoError(t, composite.Commit(committed))
+		require.NoError(t, array.Commit(committed))
+
+		array2, err := NewArrayValueFromStorage(committed, array.ID())
+		require.NoError(t, err)
+		v, err := array2.Get(0)
+		require.NoError(t, err)
+		require.IsType(t, SomeValue{}, v)
+		composite2 := v.(SomeValue).Value.(*CompositeValue)
+		assert.Equal(t, composite.ID(), composite2.ID())
+		verifyComposite(t, composite2, testCompositeFields(2))
+
+		// Referenced composite isn't removed with its optional value
+		require.NoError(t, array.Remove(0))
+		verifyComposite(t, composite, testCompositeFields(2))
+	})
+
+	t.Run("composite field", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		array := newTestArrayValue(t, storage, []Value{UInt32Value(1)})
+		inner := newTestCompositeValue(t, storage, testCompositeFields(2))
+		composite := newTestCompositeValue(t, storage, map[string]Value{
+			"items": SomeValue{Value: array},
+			"inner": SomeValue{Value: inner},
+		})
+
+		committed := NewBasicSlabStorage()
+		require.NoError(t, array.Commit(committed))
+		require.NoError(t, inner.Commit(committed))
+		require.NoError(t, composite.Commit(committed))
+
+		composite2, err := NewCompositeValueFromStorage(committed, composite.ID())
+		require.NoError(t, err)
+
+		v, found, err := composite2.GetField("items")
+		require.NoError(t, err)
+		require.True(t, found)
+		items := v.(SomeValue).Value.(*ArrayValue)
+		assert.Equal(t, array.ID(), items.ID())
+		assert.Equal(t, composite.ID(), items.metaSlab.parent)
+		verifyArrayTree(t, items, []Value{UInt32Value(1)})
+
+		v, found, err = composite2.GetField("inner")
+		require.NoError(t, err)
+		require.True(t, found)
+		inner2 := v.(SomeValue).Value.(*CompositeValue)
+		assert.Equal(t, inner.ID(), inner2.ID())
+		verifyComposite(t, inner2, testCompositeFields(2))
+
+		// Replaced array is removed with its optional value
+		require.NoError(t, composite.SetField("items", NilValue{}))
+		_, found, err = storage.Retrieve(array.ID())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// orderedKey returns key data of ordered map key. Key data of two keys of
+// the same type compare with bytes.Compare in the same order as key values.
+// Keys of different types are ordered by type first: key data starts with
+// CBOR tag number of the type (or major type of strings), followed by
+// fixed-size content of numbers, or content of strings.
+//
+// Encoded CBOR data isn't used for ordering because it doesn't preserve
+// order of values: negative integers follow positive integers, and
+// strings are ordered by length before content.
+func orderedKey(key Serializable) ([]byte, error) {
+	switch v := key.GetValue().(type) {
+	case Int8Value:
+		return orderedIntKey(cborTagInt8Value, 1, int64(v)), nil
+	case Int16Value:
+		return orderedIntKey(cborTagInt16Value, 2, int64(v)), nil
+	case Int32Value:
+		return orderedIntKey(cborTagInt32Value, 4, int64(v)), nil
+	case Int64Value:
+		return orderedIntKey(cborTagInt64Value, 8, int64(v)), nil
+	case Fix64Value:
+		return orderedIntKey(cborTagFix64Value, 8, int64(v)), nil
+	case Int128Value:
+		return orderedBigIntKey(cborTagInt128Value, 16, true, v.BigInt)
+	case Int256Value:
+		return orderedBigIntKey(cborTagInt256Value, 32, true, v.BigInt)
+
+	case UInt8Value:
+		return orderedUintKey(cborTagUInt8Value, 1, uint64(v)), nil
+	case UInt16Value:
+		return orderedUintKey(cborTagUInt16Value, 2, uint64(v)), nil
+	case UInt32Value:
+		return orderedUintKey(cborTagUInt32Value, 4, uint64(v)), nil
+	case UInt64Value:
+		return orderedUintKey(cborTagUInt64Value, 8, uint64(v)), nil
+	case Word8Value:
+		return orderedUintKey(cborTagWord8Value, 1, uint64(v)), nil
+	case Word16Value:
+		return orderedUintKey(cborTagWord16Value, 2, uint64(v)), nil
+	case Word32Value:
+		return orderedUintKey(cborTagWord32Value, 4, uint64(v)), nil
+	case Word64Value:
+		return orderedUintKey(cborTagWord64Value, 8, uint64(v)), nil
+	case UFix64Value:
+		return orderedUintKey(cborTagUFix64Value, 8, uint64(v)), nil
+	case UInt128Value:
+		return orderedBigIntKey(cborTagUInt128Value, 16, false, v.BigInt)
+	case UInt256Value:
+		return orderedBigIntKey(cborTagUInt256Value, 32, false, v.BigInt)
+
+	case StringValue:
+		return append([]byte{cborMajorTextString}, v...), nil
+	case BytesValue:
+		return append([]byte{cborMajorByteString}, v...), nil
+	case CharacterValue:
+		return append([]byte{cborTagCharacterValue}, v...), nil
+	case PathValue:
+		return append([]byte{cborTagPathValue, byte(v.Domain)}, v.Identifier...), nil
+
+	case SomeValue:
+		inner, err := orderedKey(v.Value.GetSerizable())
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{cborTagSomeValue}, inner...), nil
+	}
+
+	// Encoded data of other keys (bool, nil, address) has the same size
+	// for all values of the type, so it preserves order. Its first byte
+	// (simple value or tag head) isn't used as first byte of key data above.
+	return key.Encode()
+}
+
+// orderedIntKey returns tag followed by v in n bytes big-endian with
+// sign bit flipped, so negative values are ordered before positive values.
+func orderedIntKey(tag byte, n int, v int64) []byte {
+	return orderedUintKey(tag, n, uint64(v)^(1<<(8*n-1)))
+}
+
+// orderedUintKey returns tag followed by v in n bytes big-endian.
+func orderedUintKey(tag byte, n int, v uint64) []byte {
+	buf := make([]byte, 9)
+	buf[0] = tag
+	binary.BigEndian.PutUint64(buf[1:], v)
+	return append(buf[:1], buf[9-n:]...)
+}
+
+// orderedBigIntKey returns tag followed by v in n bytes big-endian two's
+// complement. If signed is true, sign bit is flipped like orderedIntKey.
+func orderedBigIntKey(tag byte, n int, signed bool, v *big.Int) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("nil big integer for type with tag %d", tag)
+	}
+
+	bits := 8 * n
+	if signed {
+		bits--
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	if (!signed && v.Sign() < 0) || v.CmpAbs(limit) > 0 || v.Cmp(limit) == 0 {
+		return nil, fmt.Errorf("integer %s overflows type with tag %d", v, tag)
+	}
+
+	content := new(big.Int).Set(v)
+	if content.Sign() < 0 {
+		content.Add(content, new(big.Int).Lsh(big.NewInt(1), uint(8*n)))
+	}
+
+	buf := make([]byte, 1+n)
+	buf[0] = tag
+	b := content.Bytes()
+	copy(buf[1+n-len(b):], b)
+	if signed {
+		buf[1] ^= 0x80
+	}
+	return buf, nil
+}
@@ -0,0 +1,1134 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// orderedMapMetaSlabInternalFlag is set in the encoded slab count of an
+// OrderedMapMetaSlab whose children are OrderedMapMetaSlabs instead of
+// OrderedMapSlabs.
+const orderedMapMetaSlabInternalFlag = uint32(1) << 31
+
+// orderedMapMetaSlabChildHeaderSize is the encoded size of each child slab
+// in meta slab, not including its first key: slab index (8 bytes) +
+// slab size (4 bytes) + first key size (2 bytes).
+// Child slabs have the same owner address as meta slab.
+const orderedMapMetaSlabChildHeaderSize = 14
+
+// orderedMapMetaSlabStoredChildHeaderSize is the size of each child slab
+// in meta slab encoded on its own, not including its first key:
+// slab index (8 bytes) + entry count (4 bytes) + slab size (4 bytes) +
+// first key size (2 bytes).
+const orderedMapMetaSlabStoredChildHeaderSize = 18
+
+// OrderedMapSlabHeader holds cached slab info. Meta slabs keep a copy of
+// the header of each child slab, which is updated when child slab is stored.
+type OrderedMapSlabHeader struct {
+	id       StorageID
+	count    uint32 // number of entries in slab (or in all slabs under a meta slab)
+	size     uint32 // sum of all entry size + map header size (or meta slab header size)
+	firstKey []byte // key data of first key in slab (or in all slabs under a meta slab), nil if empty
+}
+
+// OrderedMapNode is a node of the ordered map tree, either a data slab
+// (OrderedMapSlab) or a meta slab (OrderedMapMetaSlab) holding headers
+// of its children. Keys are ordered by their key data (see orderedKey).
+type OrderedMapNode interface {
+	Slab
+
+	Header() *OrderedMapSlabHeader
+
+	// Entries are accessed through storage of ordered map, which retrieves
+	// child slabs of meta slabs. Data slabs don't use it.
+	Get(storage SlabStorage, key []byte) (Serializable, bool, error)
+	Set(storage SlabStorage, entry orderedMapEntry) (bool, error)
+	Remove(storage SlabStorage, key []byte) (bool, error)
+
+	// Floor returns entry with the greatest key less than or equal to key.
+	Floor(storage SlabStorage, key []byte) (orderedMapEntry, bool, error)
+	// Ceiling returns entry with the least key greater than or equal to key.
+	Ceiling(storage SlabStorage, key []byte) (orderedMapEntry, bool, error)
+}
+
+// orderedMapEntry is a key and value pair with key data of key.
+type orderedMapEntry struct {
+	keyData []byte
+	key     Serializable
+	value   Serializable
+}
+
+func newOrderedMapEntry(key Serializable, value Serializable) (orderedMapEntry, error) {
+	data, err := orderedKey(key)
+	if err != nil {
+		return orderedMapEntry{}, err
+	}
+	return orderedMapEntry{keyData: data, key: key, value: value}, nil
+}
+
+func (e orderedMapEntry) byteSize() uint32 {
+	return e.key.ByteSize() + e.value.ByteSize()
+}
+
+// OrderedMapSlab implements Slab interface.
+// Entries are ordered by key data.
+type OrderedMapSlab struct {
+	header  *OrderedMapSlabHeader
+	entries []orderedMapEntry
+}
+
+// OrderedMapMetaSlab implements Slab interface.
+// Children of OrderedMapMetaSlab are either all OrderedMapSlabs or all
+// OrderedMapMetaSlabs (internal is set), and they're ordered by their first
+// key. Child slabs are retrieved from storage passed by the value accessing
+// the slab, like in ArrayMetaSlab.
+type OrderedMapMetaSlab struct {
+	header         *OrderedMapSlabHeader
+	orderedHeaders list.List
+	internal       bool
+
+	// children is rebuilt by updateHeader, so child slab
+	// containing a key can be found by binary search.
+	children []*list.Element
+}
+
+func (m *OrderedMapSlab) Header() *OrderedMapSlabHeader {
+	return m.header
+}
+
+func (m *OrderedMapSlab) headerSize() uint32 {
+	// map head (1 byte) + entry count (4 bytes)
+	return 5
+}
+
+// updateFirstKey sets first key in header after entries are modified.
+func (m *OrderedMapSlab) updateFirstKey() {
+	if len(m.entries) == 0 {
+		m.header.firstKey = nil
+		return
+	}
+	m.header.firstKey = m.entries[0].keyData
+}
+
+// find returns index of the first entry with key greater than or equal
+// to key, and true if that entry has key.
+func (m *OrderedMapSlab) find(key []byte) (int, bool) {
+	i := sort.Search(len(m.entries), func(i int) bool {
+		return bytes.Compare(m.entries[i].keyData, key) >= 0
+	})
+	return i, i < len(m.entries) && bytes.Equal(m.entries[i].keyData, key)
+}
+
+func (m *OrderedMapSlab) Get(storage SlabStorage, key []byte) (Serializable, bool, error) {
+	i, found := m.find(key)
+	if !found {
+		return nil, false, nil
+	}
+	return m.entries[i].value, true, nil
+}
+
+// Set inserts entry, or replaces value of entry with the same key.
+// It returns true if entry is inserted.
+func (m *OrderedMapSlab) Set(storage SlabStorage, entry orderedMapEntry) (bool, error) {
+	i, found := m.find(entry.keyData)
+
+	if found {
+		oldSize := m.entries[i].value.ByteSize()
+		m.entries[i].value = entry.value
+		m.header.size = m.header.size - oldSize + entry.value.ByteSize()
+		return false, nil
+	}
+
+	m.entries = append(m.entries, orderedMapEntry{})
+	copy(m.entries[i+1:], m.entries[i:])
+	m.entries[i] = entry
+
+	m.header.count++
+	m.header.size += entry.byteSize()
+	m.updateFirstKey()
+	return true, nil
+}
+
+// Remove removes entry with key. It returns true if entry is removed.
+func (m *OrderedMapSlab) Remove(storage SlabStorage, key []byte) (bool, error) {
+	i, found := m.find(key)
+	if !found {
+		return false, nil
+	}
+
+	size := m.entries[i].byteSize()
+
+	copy(m.entries[i:], m.entries[i+1:])
+	m.entries[len(m.entries)-1] = orderedMapEntry{}
+	m.entries = m.entries[:len(m.entries)-1]
+
+	m.header.count--
+	m.header.size -= size
+	m.updateFirstKey()
+	return true, nil
+}
+
+func (m *OrderedMapSlab) Floor(storage SlabStorage, key []byte) (orderedMapEntry, bool, error) {
+	i, found := m.find(key)
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return orderedMapEntry{}, false, nil
+	}
+	return m.entries[i], true, nil
+}
+
+func (m *OrderedMapSlab) Ceiling(storage SlabStorage, key []byte) (orderedMapEntry, bool, error) {
+	i, _ := m.find(key)
+	if i == len(m.entries) {
+		return orderedMapEntry{}, false, nil
+	}
+	return m.entries[i], true, nil
+}
+
+// Split moves the second half of entries by size to a new slab,
+// in the same way as ArraySlab.
+func (m *OrderedMapSlab) Split(storage SlabStorage) (Segmentable, error) {
+	if len(m.entries) < 2 {
+		// Can't split slab with one entry
+		return nil, nil
+	}
+
+	// this compute the ceil of split keep the first part with more members
+	breakPoint := uint32(math.Ceil(float64(m.header.size) / float64(2)))
+
+	newSlabStartIndex := 0
+	slab1Size := m.headerSize()
+	for i, e := range m.entries {
+		slab1Size += e.byteSize()
+		if slab1Size > breakPoint {
+			newSlabStartIndex = i + 1
+			break
+		}
+	}
+
+	if newSlabStartIndex == len(m.entries) {
+		// Split last entry from the rest of entries
+		newSlabStartIndex = len(m.entries) - 1
+		slab1Size = m.header.size - m.entries[len(m.entries)-1].byteSize()
+	}
+
+	id, err := storage.GenerateStorageID(m.header.id.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy entries so both slabs don't share the same underlying array
+	entries := make([]orderedMapEntry, len(m.entries)-newSlabStartIndex)
+	copy(entries, m.entries[newSlabStartIndex:])
+
+	newSlab := &OrderedMapSlab{
+		header: &OrderedMapSlabHeader{
+			id:    id,
+			count: uint32(len(entries)),
+			size:  m.header.size - slab1Size + m.headerSize(),
+		},
+		entries: entries,
+	}
+	newSlab.updateFirstKey()
+
+	for i := newSlabStartIndex; i < len(m.entries); i++ {
+		m.entries[i] = orderedMapEntry{}
+	}
+	m.entries = m.entries[:newSlabStartIndex]
+	m.header.size = slab1Size
+	m.header.count = uint32(newSlabStartIndex)
+
+	return newSlab, nil
+}
+
+// Merge moves entries of the following slab to this slab.
+func (m *OrderedMapSlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*OrderedMapSlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into ordered map slab", s)
+	}
+	m.entries = append(m.entries, slab2.entries...)
+	m.header.size += slab2.header.size - m.headerSize()
+	m.header.count += slab2.header.count
+	m.updateFirstKey()
+	return nil
+}
+
+func (m *OrderedMapSlab) Clone() Slab {
+	header := *m.header
+	return &OrderedMapSlab{
+		header:  &header,
+		entries: append([]orderedMapEntry(nil), m.entries...),
+	}
+}
+
+func (m *OrderedMapSlab) ID() StorageID {
+	return m.header.id
+}
+
+// Encode encodes entries as CBOR map with keys and values in order.
+func (m *OrderedMapSlab) Encode() ([]byte, error) {
+	buf := make([]byte, m.headerSize(), m.ByteSize())
+
+	// Map head
+	buf[0] = 0xa0 | byte(26)
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(m.entries)))
+
+	for _, e := range m.entries {
+		b, err := e.key.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+
+		b, err = e.value.Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+func (m *OrderedMapSlab) Decode(data []byte) error {
+	if len(data) < int(m.headerSize()) {
+		return errors.New("wrong byte size for ordered map slab")
+	}
+	if data[0] != 0xa0|byte(26) {
+		return errors.New("wrong data for ordered map slab")
+	}
+
+	count := binary.BigEndian.Uint32(data[1:])
+
+	m.header.count = count
+	m.header.size = uint32(len(data))
+
+	data = data[5:]
+	m.entries = make([]orderedMapEntry, count)
+	for i := 0; i < int(count); i++ {
+		var err error
+		var e orderedMapEntry
+
+		e.key, data, err = decodeSerializable(data)
+		if err != nil {
+			return err
+		}
+		e.keyData, err = orderedKey(e.key)
+		if err != nil {
+			return err
+		}
+
+		e.value, data, err = decodeSerializable(data)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 && bytes.Compare(m.entries[i-1].keyData, e.keyData) >= 0 {
+			return errors.New("ordered map slab keys aren't in order")
+		}
+
+		m.entries[i] = e
+	}
+
+	if len(data) > 0 {
+		return errors.New("wrong byte size for ordered map slab")
+	}
+
+	m.updateFirstKey()
+	return nil
+}
+
+func (m *OrderedMapSlab) ByteSize() uint32 {
+	return m.header.size
+}
+
+func (m *OrderedMapSlab) IsConstantSized() bool { return false }
+
+// GetValue returns nil because data slab holds only a range of entries of
+// ordered map. OrderedMapValue is returned by GetValue of Serializable
+// returned by its GetSerizable.
+func (m *OrderedMapSlab) GetValue() Value {
+	return nil
+}
+
+func newOrderedMapMetaSlab(storage SlabStorage, address Address) (*OrderedMapMetaSlab, error) {
+	id, err := storage.GenerateStorageID(address)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &OrderedMapMetaSlab{header: &OrderedMapSlabHeader{id: id}}
+	meta.header.size = meta.headerSize()
+	return meta, nil
+}
+
+// GetValue returns nil because meta slab is shared by OrderedMapValues
+// loaded from storage. OrderedMapValue is returned by GetValue of
+// Serializable returned by its GetSerizable.
+func (m *OrderedMapMetaSlab) GetValue() Value {
+	return nil
+}
+
+func (m *OrderedMapMetaSlab) IsConstantSized() bool { return false }
+
+func (m *OrderedMapMetaSlab) ID() StorageID {
+	return m.header.id
+}
+
+func (m *OrderedMapMetaSlab) Header() *OrderedMapSlabHeader {
+	return m.header
+}
+
+func (m *OrderedMapMetaSlab) headerSize() uint32 {
+	// address (8 bytes) + index (8 bytes) + slab count (4 bytes)
+	return 20
+}
+
+func (m *OrderedMapMetaSlab) Clone() Slab {
+	header := *m.header
+	meta := &OrderedMapMetaSlab{
+		header:   &header,
+		internal: m.internal,
+	}
+	for e := m.orderedHeaders.Front(); e != nil; e = e.Next() {
+		h := *e.Value.(*OrderedMapSlabHeader)
+		meta.orderedHeaders.PushBack(&h)
+	}
+	meta.updateHeader()
+	return meta
+}
+
+// getSlab retrieves child slab with given header from storage.
+func (m *OrderedMapMetaSlab) getSlab(storage SlabStorage, header *OrderedMapSlabHeader) (OrderedMapNode, error) {
+	slab, found, err := storage.Retrieve(header.id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("ordered map slab %s not found", header.id)
+	}
+	node, ok := slab.(OrderedMapNode)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not ordered map slab", header.id, slab)
+	}
+	return node, nil
+}
+
+// getSlabForUpdate retrieves child slab which is about to be modified,
+// so that snapshots can preserve its current version.
+func (m *OrderedMapMetaSlab) getSlabForUpdate(storage SlabStorage, header *OrderedMapSlabHeader) (OrderedMapNode, error) {
+	slab, err := m.getSlab(storage, header)
+	if err != nil {
+		return nil, err
+	}
+	if tracked, ok := storage.(*trackedSlabStorage); ok {
+		tracked.beforeUpdate(slab)
+	}
+	return slab, nil
+}
+
+// storeSlab stores modified child slab and updates its header at headerElement.
+func (m *OrderedMapMetaSlab) storeSlab(storage SlabStorage, headerElement *list.Element, slab OrderedMapNode) {
+	header := *slab.Header()
+	headerElement.Value = &header
+	storage.Store(slab)
+}
+
+// updateHeader recomputes entry count, meta slab size, first key and
+// children from child headers. It must be called whenever children are modified.
+func (m *OrderedMapMetaSlab) updateHeader() {
+	m.children = m.children[:0]
+
+	count := uint32(0)
+	size := m.headerSize()
+	for e := m.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*OrderedMapSlabHeader)
+		count += header.count
+		size += orderedMapMetaSlabChildHeaderSize + uint32(len(header.firstKey))
+		m.children = append(m.children, e)
+	}
+	m.header.count = count
+	m.header.size = size
+
+	m.header.firstKey = nil
+	if front := m.orderedHeaders.Front(); front != nil {
+		m.header.firstKey = front.Value.(*OrderedMapSlabHeader).firstKey
+	}
+}
+
+func (m *OrderedMapMetaSlab) GetCount() uint32 {
+	return m.header.count
+}
+
+// childIndex returns index of the last child with first key less than or
+// equal to key, or 0 if key is less than first keys of all children.
+func (m *OrderedMapMetaSlab) childIndex(key []byte) int {
+	i := sort.Search(len(m.children), func(i int) bool {
+		return bytes.Compare(m.children[i].Value.(*OrderedMapSlabHeader).firstKey, key) > 0
+	})
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// childFor returns list element of child slab which contains key,
+// or where key should be inserted.
+func (m *OrderedMapMetaSlab) childFor(key []byte) *list.Element {
+	return m.children[m.childIndex(key)]
+}
+
+func (m *OrderedMapMetaSlab) Get(storage SlabStorage, key []byte) (Serializable, bool, error) {
+	if m.orderedHeaders.Len() == 0 {
+		return nil, false, nil
+	}
+
+	slab, err := m.getSlab(storage, m.childFor(key).Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return slab.Get(storage, key)
+}
+
+func (m *OrderedMapMetaSlab) Set(storage SlabStorage, entry orderedMapEntry) (bool, error) {
+	if m.orderedHeaders.Len() == 0 {
+		id, err := storage.GenerateStorageID(m.header.id.Address)
+		if err != nil {
+			return false, err
+		}
+		slab := &OrderedMapSlab{header: &OrderedMapSlabHeader{id: id}}
+		slab.header.size = slab.headerSize()
+
+		m.storeSlab(storage, m.orderedHeaders.PushBack(nil), slab)
+		m.updateHeader()
+	}
+
+	e := m.childFor(entry.keyData)
+
+	slab, err := m.getSlabForUpdate(storage, e.Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := slab.Set(storage, entry)
+	if err != nil {
+		return false, err
+	}
+
+	m.storeSlab(storage, e, slab)
+
+	return inserted, m.rebalance(storage, e)
+}
+
+func (m *OrderedMapMetaSlab) Remove(storage SlabStorage, key []byte) (bool, error) {
+	if m.orderedHeaders.Len() == 0 {
+		return false, nil
+	}
+
+	e := m.childFor(key)
+
+	slab, err := m.getSlabForUpdate(storage, e.Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := slab.Remove(storage, key)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	m.storeSlab(storage, e, slab)
+
+	return true, m.rebalance(storage, e)
+}
+
+// Floor returns entry with the greatest key less than or equal to key.
+// Children following the child containing key have greater keys.
+func (m *OrderedMapMetaSlab) Floor(storage SlabStorage, key []byte) (orderedMapEntry, bool, error) {
+	if m.orderedHeaders.Len() == 0 {
+		return orderedMapEntry{}, false, nil
+	}
+
+	slab, err := m.getSlab(storage, m.childFor(key).Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return orderedMapEntry{}, false, err
+	}
+
+	return slab.Floor(storage, key)
+}
+
+// Ceiling returns entry with the least key greater than or equal to key.
+// If keys of the child containing key are less than key, it is
+// the first entry of the following child.
+func (m *OrderedMapMetaSlab) Ceiling(storage SlabStorage, key []byte) (orderedMapEntry, bool, error) {
+	if m.orderedHeaders.Len() == 0 {
+		return orderedMapEntry{}, false, nil
+	}
+
+	for e := m.childFor(key); e != nil; e = e.Next() {
+		slab, err := m.getSlab(storage, e.Value.(*OrderedMapSlabHeader))
+		if err != nil {
+			return orderedMapEntry{}, false, err
+		}
+
+		entry, found, err := slab.Ceiling(storage, key)
+		if err != nil || found {
+			return entry, found, err
+		}
+	}
+
+	return orderedMapEntry{}, false, nil
+}
+
+// isUnderflow returns true if child slab should be merged with a sibling.
+// Meta slab with only one child is merged so its child can be merged with siblings.
+func (m *OrderedMapMetaSlab) isUnderflow(header *OrderedMapSlabHeader) bool {
+	if m.internal {
+		return header.size < minThreshold || header.size < m.headerSize()+2*orderedMapMetaSlabChildHeaderSize
+	}
+	return header.size < minThreshold
+}
+
+// rebalance splits or merges modified child slab if its size
+// exceeds maxThreshold or falls below minThreshold.
+func (m *OrderedMapMetaSlab) rebalance(storage SlabStorage, headerElement *list.Element) error {
+	header := headerElement.Value.(*OrderedMapSlabHeader)
+
+	var err error
+	if header.size > maxThreshold {
+		err = m.split(storage, headerElement)
+	} else if m.isUnderflow(header) {
+		err = m.merge(storage, headerElement)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.updateHeader()
+	return nil
+}
+
+// updateRoot adjusts tree height after root slab is modified, and stores
+// root slab. Other meta slabs are stored by their parent after they're
+// modified.
+func (m *OrderedMapMetaSlab) updateRoot(storage SlabStorage) error {
+	var err error
+	if m.header.size > maxThreshold {
+		err = m.splitRoot(storage)
+	} else if m.orderedHeaders.Len() == 1 && m.internal {
+		err = m.collapseRoot(storage)
+	}
+	if err != nil {
+		return err
+	}
+
+	storage.Store(m)
+	return nil
+}
+
+// splitRoot moves all children of root to a new meta slab and splits it,
+// increasing tree height by one. Root keeps its StorageID.
+func (m *OrderedMapMetaSlab) splitRoot(storage SlabStorage) error {
+	child, err := newOrderedMapMetaSlab(storage, m.header.id.Address)
+	if err != nil {
+		return err
+	}
+	child.internal = m.internal
+	child.orderedHeaders.PushBackList(&m.orderedHeaders)
+	child.updateHeader()
+
+	m.internal = true
+	m.orderedHeaders.Init()
+	m.storeSlab(storage, m.orderedHeaders.PushBack(nil), child)
+
+	err = m.split(storage, m.orderedHeaders.Front())
+	if err != nil {
+		return err
+	}
+
+	m.updateHeader()
+	return nil
+}
+
+// collapseRoot moves children of root's only child to root,
+// decreasing tree height by one.
+func (m *OrderedMapMetaSlab) collapseRoot(storage SlabStorage) error {
+	slab, err := m.getSlab(storage, m.orderedHeaders.Front().Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return err
+	}
+	child := slab.(*OrderedMapMetaSlab)
+
+	m.internal = child.internal
+	m.orderedHeaders.Init()
+	m.orderedHeaders.PushBackList(&child.orderedHeaders)
+
+	storage.Remove(child.ID())
+
+	m.updateHeader()
+	return nil
+}
+
+// Split moves the second half of children by size to a new meta slab.
+func (m *OrderedMapMetaSlab) Split(storage SlabStorage) (Segmentable, error) {
+	if m.orderedHeaders.Len() < 2 {
+		// Can't split meta slab with one child
+		return nil, nil
+	}
+
+	// Child headers have different sizes because of their first keys,
+	// so keep at least the first half of size in this slab.
+	breakPoint := (m.header.size + 1) / 2
+
+	size := m.headerSize()
+	e := m.orderedHeaders.Front()
+	for e.Next() != nil && size < breakPoint {
+		size += orderedMapMetaSlabChildHeaderSize + uint32(len(e.Value.(*OrderedMapSlabHeader).firstKey))
+		e = e.Next()
+	}
+
+	newSlab, err := newOrderedMapMetaSlab(storage, m.header.id.Address)
+	if err != nil {
+		return nil, err
+	}
+	newSlab.internal = m.internal
+	for e != nil {
+		next := e.Next()
+		newSlab.orderedHeaders.PushBack(m.orderedHeaders.Remove(e))
+		e = next
+	}
+
+	m.updateHeader()
+	newSlab.updateHeader()
+
+	return newSlab, nil
+}
+
+// Merge moves children of the following meta slab to this meta slab.
+func (m *OrderedMapMetaSlab) Merge(s Segmentable) error {
+	slab2, ok := s.(*OrderedMapMetaSlab)
+	if !ok {
+		return fmt.Errorf("can't merge %T into ordered map meta slab", s)
+	}
+	m.orderedHeaders.PushBackList(&slab2.orderedHeaders)
+	m.updateHeader()
+	return nil
+}
+
+// mergeSlabs merges slab of rightElement into slab of leftElement,
+// and removes merged slab from this meta slab and storage.
+func (m *OrderedMapMetaSlab) mergeSlabs(storage SlabStorage, leftElement, rightElement *list.Element) error {
+	leftSlab, err := m.getSlabForUpdate(storage, leftElement.Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return err
+	}
+
+	rightSlab, err := m.getSlab(storage, rightElement.Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return err
+	}
+
+	err = leftSlab.Merge(rightSlab)
+	if err != nil {
+		return err
+	}
+
+	m.storeSlab(storage, leftElement, leftSlab)
+
+	// Remove merged slab header
+	m.orderedHeaders.Remove(rightElement)
+
+	storage.Remove(rightSlab.ID())
+
+	if leftSlab.Header().size > maxThreshold {
+		return m.split(storage, leftElement)
+	}
+
+	return nil
+}
+
+func (m *OrderedMapMetaSlab) merge(storage SlabStorage, headerElement *list.Element) error {
+
+	if m.orderedHeaders.Len() == 1 {
+		return nil
+	}
+
+	if headerElement.Prev() == nil {
+		// First slab merges with next slab
+		return m.mergeSlabs(storage, headerElement, headerElement.Next())
+	}
+
+	if headerElement.Next() == nil {
+		// Last slab merges with prev slab
+		return m.mergeSlabs(storage, headerElement.Prev(), headerElement)
+	}
+
+	prevHeader := headerElement.Prev().Value.(*OrderedMapSlabHeader)
+	nextHeader := headerElement.Next().Value.(*OrderedMapSlabHeader)
+
+	if prevHeader.size <= nextHeader.size {
+		// Merge with previous slab
+		return m.mergeSlabs(storage, headerElement.Prev(), headerElement)
+	}
+
+	// Merge with next slab
+	return m.mergeSlabs(storage, headerElement, headerElement.Next())
+}
+
+func (m *OrderedMapMetaSlab) split(storage SlabStorage, headerElement *list.Element) error {
+	slab, err := m.getSlabForUpdate(storage, headerElement.Value.(*OrderedMapSlabHeader))
+	if err != nil {
+		return err
+	}
+
+	newSlab, err := slab.Split(storage)
+	if err != nil {
+		return err
+	}
+	if newSlab == nil {
+		return nil
+	}
+
+	m.storeSlab(storage, headerElement, slab)
+	m.storeSlab(storage, m.orderedHeaders.InsertAfter(nil, headerElement), newSlab.(OrderedMapNode))
+	return nil
+}
+
+// encodeOrderedMapChildHeader encodes header of child slab for Encode or encodeSlab.
+// If count is true, entry count is encoded after slab index.
+func encodeOrderedMapChildHeader(header *OrderedMapSlabHeader, size uint32, count bool) []byte {
+	headerSize := orderedMapMetaSlabChildHeaderSize
+	if count {
+		headerSize = orderedMapMetaSlabStoredChildHeaderSize
+	}
+
+	buf := make([]byte, headerSize, headerSize+len(header.firstKey))
+	binary.BigEndian.PutUint64(buf, header.id.Index)
+	offset := 8
+	if count {
+		binary.BigEndian.PutUint32(buf[offset:], header.count)
+		offset += 4
+	}
+	binary.BigEndian.PutUint32(buf[offset:], size)
+	binary.BigEndian.PutUint16(buf[offset+4:], uint16(len(header.firstKey)))
+	return append(buf, header.firstKey...)
+}
+
+// decodeOrderedMapChildHeader decodes child header encoded by
+// encodeOrderedMapChildHeader and returns number of bytes read.
+func decodeOrderedMapChildHeader(data []byte, address Address, count bool) (*OrderedMapSlabHeader, int, error) {
+	headerSize := orderedMapMetaSlabChildHeaderSize
+	if count {
+		headerSize = orderedMapMetaSlabStoredChildHeaderSize
+	}
+	if len(data) < headerSize {
+		return nil, 0, errors.New("too short for ordered map meta slab")
+	}
+
+	header := &OrderedMapSlabHeader{
+		id: NewStorageID(address, binary.BigEndian.Uint64(data)),
+	}
+	offset := 8
+	if count {
+		header.count = binary.BigEndian.Uint32(data[offset:])
+		offset += 4
+	}
+	header.size = binary.BigEndian.Uint32(data[offset:])
+
+	keySize := int(binary.BigEndian.Uint16(data[offset+4:]))
+	if len(data) < headerSize+keySize {
+		return nil, 0, errors.New("too short for ordered map meta slab")
+	}
+	if keySize > 0 {
+		header.firstKey = append([]byte(nil), data[headerSize:headerSize+keySize]...)
+	}
+
+	return header, headerSize + keySize, nil
+}
+
+// Encode encodes meta slab on its own (see encodeSlab), because child
+// slabs are retrieved from storage passed to encode.
+func (m *OrderedMapMetaSlab) Encode() ([]byte, error) {
+	return m.encodeSlab()
+}
+
+// encode encodes meta slab header followed by encoded data of all child slabs.
+// Slab size of each child is the length of child's encoded data.
+func (m *OrderedMapMetaSlab) encode(storage SlabStorage) ([]byte, error) {
+	buf := make([]byte, m.headerSize(), m.ByteSize())
+
+	// Write metaslab address (8 bytes) and index (8 bytes)
+	copy(buf, m.header.id.Address[:])
+	binary.BigEndian.PutUint64(buf[8:], m.header.id.Index)
+
+	// Write number of slabs (4 bytes)
+	slabCount := uint32(m.orderedHeaders.Len())
+	if m.internal {
+		slabCount |= orderedMapMetaSlabInternalFlag
+	}
+	binary.BigEndian.PutUint32(buf[16:], slabCount)
+
+	children := make([][]byte, 0, m.orderedHeaders.Len())
+
+	// For each slab, write slab index (8 bytes), slab size (4 bytes),
+	// first key size (2 bytes) and first key
+	for e := m.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*OrderedMapSlabHeader)
+
+		slab, err := m.getSlab(storage, header)
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		if meta, ok := slab.(*OrderedMapMetaSlab); ok {
+			b, err = meta.encode(storage)
+		} else {
+			b, err = slab.Encode()
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, b)
+
+		buf = append(buf, encodeOrderedMapChildHeader(header, uint32(len(b)), false)...)
+	}
+
+	for _, b := range children {
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+// Decode decodes meta slab encoded on its own (see decodeSlab).
+func (m *OrderedMapMetaSlab) Decode(data []byte) error {
+	return m.decodeSlab(data)
+}
+
+// decode decodes meta slab and all its child slabs encoded by encode,
+// and stores decoded child slabs in storage.
+func (m *OrderedMapMetaSlab) decode(storage SlabStorage, data []byte) error {
+	if len(data) < int(m.headerSize()) {
+		return errors.New("too short for ordered map meta slab")
+	}
+
+	var address Address
+	copy(address[:], data)
+	m.header.id = NewStorageID(address, binary.BigEndian.Uint64(data[8:]))
+
+	slabCount := binary.BigEndian.Uint32(data[16:])
+	m.internal = slabCount&orderedMapMetaSlabInternalFlag != 0
+	slabCount &^= orderedMapMetaSlabInternalFlag
+
+	headers := make([]*OrderedMapSlabHeader, slabCount)
+
+	index := int(m.headerSize())
+	for i := 0; i < int(slabCount); i++ {
+		header, n, err := decodeOrderedMapChildHeader(data[index:], address, false)
+		if err != nil {
+			return err
+		}
+		headers[i] = header
+		index += n
+	}
+
+	for _, header := range headers {
+		size := int(header.size)
+		if len(data) < index+size {
+			return errors.New("too short for ordered map meta slab")
+		}
+
+		var slab OrderedMapNode
+		var err error
+		if m.internal {
+			meta := &OrderedMapMetaSlab{header: header}
+			err = meta.decode(storage, data[index:index+size])
+			slab = meta
+		} else {
+			slab = &OrderedMapSlab{header: header}
+			err = slab.Decode(data[index : index+size])
+		}
+		if err != nil {
+			return err
+		}
+
+		// Decoded meta slab id is the same as in child header
+		header.id = NewStorageID(address, header.id.Index)
+
+		storage.Store(slab)
+
+		index += size
+
+		h := *header
+		m.orderedHeaders.PushBack(&h)
+	}
+
+	m.updateHeader()
+
+	return nil
+}
+
+// encodeSlab encodes meta slab header followed by the header of each child
+// slab, without child slabs. It is used to store meta slab on its own.
+func (m *OrderedMapMetaSlab) encodeSlab() ([]byte, error) {
+	buf := make([]byte, m.headerSize())
+
+	// Write metaslab address (8 bytes) and index (8 bytes)
+	copy(buf, m.header.id.Address[:])
+	binary.BigEndian.PutUint64(buf[8:], m.header.id.Index)
+
+	// Write number of slabs (4 bytes)
+	slabCount := uint32(m.orderedHeaders.Len())
+	if m.internal {
+		slabCount |= orderedMapMetaSlabInternalFlag
+	}
+	binary.BigEndian.PutUint32(buf[16:], slabCount)
+
+	// For each slab, write slab index (8 bytes), entry count (4 bytes),
+	// slab size (4 bytes), first key size (2 bytes) and first key
+	for e := m.orderedHeaders.Front(); e != nil; e = e.Next() {
+		header := e.Value.(*OrderedMapSlabHeader)
+		buf = append(buf, encodeOrderedMapChildHeader(header, header.size, true)...)
+	}
+
+	return buf, nil
+}
+
+// decodeSlab decodes meta slab encoded by encodeSlab.
+// Child slabs are retrieved from storage on access.
+func (m *OrderedMapMetaSlab) decodeSlab(data []byte) error {
+	if len(data) < int(m.headerSize()) {
+		return errors.New("too short for ordered map meta slab")
+	}
+
+	var address Address
+	copy(address[:], data)
+	m.header.id = NewStorageID(address, binary.BigEndian.Uint64(data[8:]))
+
+	slabCount := binary.BigEndian.Uint32(data[16:])
+	m.internal = slabCount&orderedMapMetaSlabInternalFlag != 0
+	slabCount &^= orderedMapMetaSlabInternalFlag
+
+	offset := int(m.headerSize())
+	for i := 0; i < int(slabCount); i++ {
+		header, n, err := decodeOrderedMapChildHeader(data[offset:], address, true)
+		if err != nil {
+			return err
+		}
+		m.orderedHeaders.PushBack(header)
+		offset += n
+	}
+
+	if offset != len(data) {
+		return errors.New("wrong byte size for ordered map meta slab")
+	}
+
+	m.updateHeader()
+
+	return nil
+}
+
+// ByteSize returns encoded size of meta slab header and child headers,
+// not including child slabs.
+func (m *OrderedMapMetaSlab) ByteSize() uint32 {
+	return m.header.size
+}
+
+// Iterate calls fn for each entry with key greater than or equal to start
+// and less than end in order of key, until fn returns false or error.
+// If start or end is nil, range isn't bounded on that side.
+func (m *OrderedMapMetaSlab) Iterate(storage SlabStorage, start, end []byte, fn func(key Serializable, value Serializable) (bool, error)) error {
+	_, err := m.iterate(storage, start, end, fn)
+	return err
+}
+
+func (m *OrderedMapMetaSlab) iterate(storage SlabStorage, start, end []byte, fn func(key Serializable, value Serializable) (bool, error)) (bool, error) {
+	first := 0
+	if start != nil {
+		first = m.childIndex(start)
+	}
+
+	for _, e := range m.children[first:] {
+		header := e.Value.(*OrderedMapSlabHeader)
+		if end != nil && header.firstKey != nil && bytes.Compare(header.firstKey, end) >= 0 {
+			// Keys of this and following children are out of range
+			return false, nil
+		}
+
+		node, err := m.getSlab(storage, header)
+		if err != nil {
+			return false, err
+		}
+
+		switch slab := node.(type) {
+		case *OrderedMapMetaSlab:
+			resume, err := slab.iterate(storage, start, end, fn)
+			if err != nil || !resume {
+				return resume, err
+			}
+
+		case *OrderedMapSlab:
+			i := 0
+			if start != nil {
+				i, _ = slab.find(start)
+			}
+
+			// Copy entries so fn can modify the map without affecting iteration
+			entries := append([]orderedMapEntry(nil), slab.entries[i:]...)
+			for _, entry := range entries {
+				if end != nil && bytes.Compare(entry.keyData, end) >= 0 {
+					return false, nil
+				}
+				resume, err := fn(entry.key, entry.value)
+				if err != nil || !resume {
+					return resume, err
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+// Print is intended for debugging purpose only
+func (m *OrderedMapMetaSlab) Print(storage SlabStorage) {
+	fmt.Println("============= ordered map slabs ================")
+	m.print(storage, 0)
+	fmt.Println("================================================")
+}
+
+func (m *OrderedMapMetaSlab) print(storage SlabStorage, level int) {
+	indent := strings.Repeat("  ", level)
+	i := 0
+	for e := m.orderedHeaders.Front(); e != nil; e = e.Next() {
+		h := e.Value.(*OrderedMapSlabHeader)
+		node, err := m.getSlab(storage, h)
+		if err != nil {
+			fmt.Printf("%sslab %d, id %s, error %v\n", indent, i, h.id, err)
+			i++
+			continue
+		}
+		switch slab := node.(type) {
+		case *OrderedMapMetaSlab:
+			fmt.Printf("%smeta slab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			slab.print(storage, level+1)
+		case *OrderedMapSlab:
+			fmt.Printf("%sslab %d, id %s, count %d, size %d\n", indent, i, h.id, h.count, h.size)
+			fmt.Printf("%s[", indent)
+			for _, e := range slab.entries {
+				fmt.Printf("%[1]v (%[1]T): %[2]v (%[2]T), ", e.key.GetValue(), e.value.GetValue())
+			}
+			fmt.Printf("]\n")
+		}
+		i++
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOrderedMapValue(t *testing.T, storage SlabStorage, entries map[Value]Value) *OrderedMapValue {
+	orderedMap, err := NewOrderedMapValue(storage, testAddress)
+	require.NoError(t, err)
+
+	for k, v := range entries {
+		require.NoError(t, orderedMap.Set(k, v))
+	}
+	return orderedMap
+}
+
+// sortedKeys returns keys of entries in order of key data.
+func sortedKeys(t *testing.T, entries map[Value]Value) []Value {
+	keys := make([]Value, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, err := orderedKey(keys[i].GetSerizable())
+		require.NoError(t, err)
+		kj, err := orderedKey(keys[j].GetSerizable())
+		require.NoError(t, err)
+		return bytes.Compare(ki, kj) < 0
+	})
+	return keys
+}
+
+func verifyOrderedMapTree(t *testing.T, orderedMap *OrderedMapValue, entries map[Value]Value) {
+	slabIDs := make(map[StorageID]bool)
+
+	var prevKey []byte
+
+	var verify func(node OrderedMapNode, isRoot bool)
+	verify = func(node OrderedMapNode, isRoot bool) {
+		header := node.Header()
+		slabIDs[header.id] = true
+
+		if !isRoot {
+			assert.True(t, header.size <= maxThreshold, "slab %s size %d exceeds %d", header.id, header.size, maxThreshold)
+		}
+
+		switch slab := node.(type) {
+		case *OrderedMapSlab:
+			assert.Equal(t, uint32(len(slab.entries)), header.count)
+
+			size := slab.headerSize()
+			for i, e := range slab.entries {
+				size += e.byteSize()
+
+				key, err := orderedKey(e.key)
+				require.NoError(t, err)
+				assert.Equal(t, key, e.keyData)
+
+				if prevKey != nil {
+					assert.True(t, bytes.Compare(prevKey, e.keyData) < 0, "keys aren't in order")
+				}
+				prevKey = e.keyData
+
+				if i == 0 {
+					assert.Equal(t, e.keyData, header.firstKey)
+				}
+			}
+			assert.Equal(t, size, header.size)
+
+			b, err := slab.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, int(header.size), len(b))
+
+		case *OrderedMapMetaSlab:
+			assert.True(t, header.size <= maxThreshold, "slab %s size %d exceeds %d", header.id, header.size, maxThreshold)
+
+			count := uint32(0)
+			size := slab.headerSize()
+			for e := slab.orderedHeaders.Front(); e != nil; e = e.Next() {
+				h := e.Value.(*OrderedMapSlabHeader)
+
+				child, err := slab.getSlab(orderedMap.storage, h)
+				require.NoError(t, err)
+				assert.Equal(t, h, child.Header())
+
+				_, isMeta := child.(*OrderedMapMetaSlab)
+				assert.Equal(t, slab.internal, isMeta)
+
+				if e == slab.orderedHeaders.Front() {
+					assert.Equal(t, h.firstKey, header.firstKey)
+				} else {
+					require.NotNil(t, h.firstKey, "slab %s is empty", h.id)
+				}
+
+				verify(child, false)
+
+				count += h.count
+				size += orderedMapMetaSlabChildHeaderSize + uint32(len(h.firstKey))
+			}
+			assert.Equal(t, count, header.count)
+			assert.Equal(t, size, header.size)
+		}
+	}
+
+	root, err := orderedMap.root()
+	require.NoError(t, err)
+
+	verify(root, true)
+
+	if storage, ok := orderedMap.storage.SlabStorage.(*BasicSlabStorage); ok {
+		assert.Equal(t, len(slabIDs), len(storage.slabs))
+		for id := range storage.slabs {
+			assert.True(t, slabIDs[id], "slab %s in storage isn't in ordered map", id)
+		}
+	}
+
+	require.Equal(t, uint32(len(entries)), orderedMap.Size())
+	for k, v := range entries {
+		value, found, err := orderedMap.Get(k)
+		require.NoError(t, err)
+		require.True(t, found, "key %v not found", k)
+		require.Equal(t, v, value)
+	}
+
+	keys := sortedKeys(t, entries)
+
+	i := 0
+	err = orderedMap.Iterate(func(k Value, v Value) (bool, error) {
+		require.Less(t, i, len(keys))
+		assert.Equal(t, keys[i], k)
+		assert.Equal(t, entries[k], v)
+		i++
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(entries), i)
+}
+
+func TestOrderedMapSetGet(t *testing.T) {
+
+	const mapSize = 2000
+
+	entries := make(map[Value]Value)
+
+	orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), nil)
+
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(mapSize) {
+		k, v := UInt32Value(i), UInt32Value(i*2)
+		require.NoError(t, orderedMap.Set(k, v))
+		entries[k] = v
+	}
+	verifyOrderedMapTree(t, orderedMap, entries)
+
+	// Replace values
+	for i := 0; i < mapSize; i += 3 {
+		k, v := UInt32Value(i), UInt32Value(i*3)
+		require.NoError(t, orderedMap.Set(k, v))
+		entries[k] = v
+	}
+	verifyOrderedMapTree(t, orderedMap, entries)
+
+	for i := mapSize; i < mapSize+10; i++ {
+		found, err := orderedMap.Has(UInt32Value(i))
+		require.NoError(t, err)
+		assert.False(t, found)
+	}
+}
+
+func TestOrderedMapRemove(t *testing.T) {
+
+	const mapSize = 2000
+
+	entries := make(map[Value]Value)
+	for i := 0; i < mapSize; i++ {
+		entries[UInt32Value(i)] = UInt32Value(i)
+	}
+
+	storage := NewBasicSlabStorage()
+	orderedMap := newTestOrderedMapValue(t, storage, entries)
+	verifyOrderedMapTree(t, orderedMap, entries)
+
+	removed, err := orderedMap.Remove(UInt32Value(mapSize))
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	r := rand.New(rand.NewSource(2))
+	for i, k := range r.Perm(mapSize) {
+		removed, err := orderedMap.Remove(UInt32Value(k))
+		require.NoError(t, err)
+		assert.True(t, removed)
+		delete(entries, UInt32Value(k))
+
+		if i%500 == 0 {
+			verifyOrderedMapTree(t, orderedMap, entries)
+		}
+	}
+	verifyOrderedMapTree(t, orderedMap, entries)
+
+	// Empty ordered map has root and one data slab
+	assert.Equal(t, 2, len(storage.slabs))
+}
+
+func TestOrderedMapRandomOperations(t *testing.T) {
+
+	const operationCount = 5000
+	const keyRange = 500
+
+	r := rand.New(rand.NewSource(3))
+
+	entries := make(map[Value]Value)
+
+	orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), nil)
+
+	for i := 0; i < operationCount; i++ {
+		k := UInt32Value(r.Intn(keyRange))
+
+		if r.Intn(3) == 0 {
+			removed, err := orderedMap.Remove(k)
+			require.NoError(t, err)
+			_, exists := entries[k]
+			assert.Equal(t, exists, removed)
+			delete(entries, k)
+		} else {
+			v := UInt32Value(r.Uint32())
+			require.NoError(t, orderedMap.Set(k, v))
+			entries[k] = v
+		}
+	}
+	verifyOrderedMapTree(t, orderedMap, entries)
+}
+
+func TestOrderedMapFloorCeiling(t *testing.T) {
+
+	// Keys are even numbers from 10 to 2008
+	entries := make(map[Value]Value)
+	for i := 10; i < 2010; i += 2 {
+		entries[UInt32Value(i)] = UInt32Value(i * 2)
+	}
+
+	orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+
+	for i := 0; i < 2020; i++ {
+		k, v, found, err := orderedMap.Floor(UInt32Value(i))
+		require.NoError(t, err)
+		if i < 10 {
+			assert.False(t, found)
+		} else {
+			require.True(t, found)
+			floor := i &^ 1
+			if floor > 2008 {
+				floor = 2008
+			}
+			assert.Equal(t, UInt32Value(floor), k)
+			assert.Equal(t, UInt32Value(floor*2), v)
+		}
+
+		k, v, found, err = orderedMap.Ceiling(UInt32Value(i))
+		require.NoError(t, err)
+		if i > 2008 {
+			assert.False(t, found)
+		} else {
+			require.True(t, found)
+			ceiling := (i + 1) &^ 1
+			if ceiling < 10 {
+				ceiling = 10
+			}
+			assert.Equal(t, UInt32Value(ceiling), k)
+			assert.Equal(t, UInt32Value(ceiling*2), v)
+		}
+	}
+
+	emptyMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), nil)
+
+	_, _, found, err := emptyMap.Floor(UInt32Value(1))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, found, err = emptyMap.Ceiling(UInt32Value(1))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestOrderedMapIterateRange(t *testing.T) {
+
+	const mapSize = 2000
+
+	entries := make(map[Value]Value)
+	for i := 0; i < mapSize; i++ {
+		entries[UInt32Value(i)] = UInt32Value(i)
+	}
+
+	orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+
+	iterateRange := func(start, end Value) []Value {
+		var keys []Value
+		err := orderedMap.IterateRange(start, end, func(k Value, v Value) (bool, error) {
+			assert.Equal(t, k, v)
+			keys = append(keys, k)
+			return true, nil
+		})
+		require.NoError(t, err)
+		return keys
+	}
+
+	uint32Range := func(start, end int) []Value {
+		var keys []Value
+		for i := start; i < end; i++ {
+			keys = append(keys, UInt32Value(i))
+		}
+		return keys
+	}
+
+	assert.Equal(t, uint32Range(100, 1500), iterateRange(UInt32Value(100), UInt32Value(1500)))
+	assert.Equal(t, uint32Range(0, 10), iterateRange(nil, UInt32Value(10)))
+	assert.Equal(t, uint32Range(1990, mapSize), iterateRange(UInt32Value(1990), nil))
+	assert.Equal(t, uint32Range(0, mapSize), iterateRange(nil, nil))
+	assert.Nil(t, iterateRange(UInt32Value(500), UInt32Value(500)))
+	assert.Nil(t, iterateRange(UInt32Value(mapSize), nil))
+
+	// Iteration stops when fn returns false
+	count := 0
+	err := orderedMap.IterateRange(UInt32Value(10), nil, func(k Value, v Value) (bool, error) {
+		count++
+		return count < 5, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+}
+
+func TestOrderedMapKeyOrder(t *testing.T) {
+
+	keys := func(orderedMap *OrderedMapValue) []Value {
+		var keys []Value
+		err := orderedMap.Iterate(func(k Value, v Value) (bool, error) {
+			keys = append(keys, k)
+			return true, nil
+		})
+		require.NoError(t, err)
+		return keys
+	}
+
+	t.Run("negative integers", func(t *testing.T) {
+		entries := make(map[Value]Value)
+		for _, i := range []int64{0, 3, 100, -1, -5, math.MaxInt64, math.MinInt64} {
+			entries[Int64Value(i)] = Int64Value(i)
+		}
+
+		orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+		verifyOrderedMapTree(t, orderedMap, entries)
+
+		expected := []Value{
+			Int64Value(math.MinInt64), Int64Value(-5), Int64Value(-1), Int64Value(0),
+			Int64Value(3), Int64Value(100), Int64Value(math.MaxInt64),
+		}
+		assert.Equal(t, expected, keys(orderedMap))
+
+		k, _, found, err := orderedMap.Floor(Int64Value(-3))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, Int64Value(-5), k)
+
+		k, _, found, err = orderedMap.Ceiling(Int64Value(-3))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, Int64Value(-1), k)
+
+		var rangeKeys []Value
+		err = orderedMap.IterateRange(Int64Value(-5), Int64Value(3), func(k Value, v Value) (bool, error) {
+			rangeKeys = append(rangeKeys, k)
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Value{Int64Value(-5), Int64Value(-1), Int64Value(0)}, rangeKeys)
+	})
+
+	t.Run("big integers", func(t *testing.T) {
+		entries := make(map[Value]Value)
+		for _, i := range []int64{0, 1000, -1, -1000} {
+			entries[NewInt128ValueFromInt64(i)] = Int64Value(i)
+		}
+
+		orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+
+		var values []Value
+		err := orderedMap.Iterate(func(k Value, v Value) (bool, error) {
+			values = append(values, v)
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Value{Int64Value(-1000), Int64Value(-1), Int64Value(0), Int64Value(1000)}, values)
+	})
+
+	t.Run("strings of mixed length", func(t *testing.T) {
+		entries := make(map[Value]Value)
+		for _, s := range []string{"b", "c", "aa", "abc", ""} {
+			entries[StringValue(s)] = StringValue(s)
+		}
+
+		orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+		verifyOrderedMapTree(t, orderedMap, entries)
+
+		expected := []Value{StringValue(""), StringValue("aa"), StringValue("abc"), StringValue("b"), StringValue("c")}
+		assert.Equal(t, expected, keys(orderedMap))
+
+		k, _, found, err := orderedMap.Floor(StringValue("ab"))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, StringValue("aa"), k)
+
+		k, _, found, err = orderedMap.Ceiling(StringValue("ab"))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, StringValue("abc"), k)
+	})
+
+	t.Run("encoded data", func(t *testing.T) {
+		entries := make(map[Value]Value)
+		for i := -500; i < 500; i++ {
+			entries[Int64Value(i)] = Int64Value(i)
+		}
+
+		orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+
+		b, err := orderedMap.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		orderedMap2, err := NewOrderedMapValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyOrderedMapTree(t, orderedMap2, entries)
+
+		k, _, found, err := orderedMap2.Floor(Int64Value(-1))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, Int64Value(-1), k)
+	})
+}
+
+func TestOrderedMapEncodeDecode(t *testing.T) {
+
+	entries := make(map[Value]Value)
+	for i := 0; i < 2000; i++ {
+		entries[UInt32Value(i)] = UInt32Value(i)
+	}
+
+	t.Run("encoded data", func(t *testing.T) {
+		orderedMap := newTestOrderedMapValue(t, NewBasicSlabStorage(), entries)
+
+		b, err := orderedMap.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		orderedMap2, err := NewOrderedMapValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		assert.Equal(t, orderedMap.metaSlab.ID(), orderedMap2.metaSlab.ID())
+		verifyOrderedMapTree(t, orderedMap2, entries)
+	})
+
+	t.Run("file storage", func(t *testing.T) {
+		entries := copyEntries(entries)
+
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		orderedMap := newTestOrderedMapValue(t, storage, entries)
+		id := orderedMap.metaSlab.ID()
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		orderedMap, err := NewOrderedMapValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifyOrderedMapTree(t, orderedMap, entries)
+
+		// Loaded ordered map can be modified
+		for i := 0; i < 1000; i++ {
+			_, err := orderedMap.Remove(UInt32Value(i))
+			require.NoError(t, err)
+			delete(entries, UInt32Value(i))
+		}
+		verifyOrderedMapTree(t, orderedMap, entries)
+	})
+}
+
+func copyEntries(entries map[Value]Value) map[Value]Value {
+	c := make(map[Value]Value, len(entries))
+	for k, v := range entries {
+		c[k] = v
+	}
+	return c
+}
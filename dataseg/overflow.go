@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxInlineElementSize is the maximum encoded size of element held in
+// array data slab, so that data slab with only the element doesn't exceed
+// maxThreshold: array head (5 bytes) + element. Larger elements are stored
+// in overflow slabs and referenced by StorageID.
+const maxInlineElementSize = maxThreshold - 5
+
+// overflowSlabHeaderSize is the encoded size of index of next overflow slab.
+const overflowSlabHeaderSize = 8
+
+// maxOverflowChunkSize is the maximum size of element data held in one
+// overflow slab. Larger elements are chunked into chain of overflow slabs.
+const maxOverflowChunkSize = maxThreshold - overflowSlabHeaderSize
+
+// OverflowSlab implements Slab interface. It holds a chunk of encoded
+// element which is too large for array data slab. Chunks of the element
+// are chained by index of next slab, which has the same owner address.
+type OverflowSlab struct {
+	id   StorageID
+	next uint64 // index of next overflow slab, 0 if this is the last chunk
+	data []byte
+}
+
+func (m *OverflowSlab) ID() StorageID {
+	return m.id
+}
+
+// Encode encodes index of next overflow slab (8 bytes) followed by chunk data.
+func (m *OverflowSlab) Encode() ([]byte, error) {
+	buf := make([]byte, overflowSlabHeaderSize, m.ByteSize())
+	binary.BigEndian.PutUint64(buf, m.next)
+	return append(buf, m.data...), nil
+}
+
+func (m *OverflowSlab) Decode(data []byte) error {
+	if len(data) <= overflowSlabHeaderSize {
+		return errors.New("too short for overflow slab")
+	}
+	m.next = binary.BigEndian.Uint64(data)
+	m.data = append([]byte(nil), data[overflowSlabHeaderSize:]...)
+	return nil
+}
+
+func (m *OverflowSlab) ByteSize() uint32 {
+	return overflowSlabHeaderSize + uint32(len(m.data))
+}
+
+func (m *OverflowSlab) IsConstantSized() bool { return false }
+
+// GetValue returns nil because overflow slab holds only part of element.
+func (m *OverflowSlab) GetValue() Value {
+	return nil
+}
+
+// Split returns nil because element is chunked when it's stored.
+func (m *OverflowSlab) Split(storage SlabStorage) (Segmentable, error) {
+	return nil, nil
+}
+
+func (m *OverflowSlab) Merge(s Segmentable) error {
+	return errors.New("overflow slab can't be merged")
+}
+
+func (m *OverflowSlab) Clone() Slab {
+	return &OverflowSlab{
+		id:   m.id,
+		next: m.next,
+		data: append([]byte(nil), m.data...),
+	}
+}
+
+// storeOverflow stores data in chain of overflow slabs owned by address,
+// and returns StorageID of the first slab.
+func storeOverflow(storage SlabStorage, address Address, data []byte) (StorageID, error) {
+	ids := make([]StorageID, (len(data)+maxOverflowChunkSize-1)/maxOverflowChunkSize)
+	for i := range ids {
+		var err error
+		ids[i], err = storage.GenerateStorageID(address)
+		if err != nil {
+			return StorageID{}, err
+		}
+	}
+
+	for i, id := range ids {
+		end := (i + 1) * maxOverflowChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		slab := &OverflowSlab{id: id, data: append([]byte(nil), data[i*maxOverflowChunkSize:end]...)}
+		if i+1 < len(ids) {
+			slab.next = ids[i+1].Index
+		}
+		storage.Store(slab)
+	}
+
+	return ids[0], nil
+}
+
+// retrieveOverflowSlab retrieves overflow slab with id from storage.
+func retrieveOverflowSlab(storage SlabStorage, id StorageID) (*OverflowSlab, error) {
+	slab, found, err := storage.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("overflow slab %s not found", id)
+	}
+	overflow, ok := slab.(*OverflowSlab)
+	if !ok {
+		return nil, fmt.Errorf("slab %s is %T, not overflow slab", id, slab)
+	}
+	return overflow, nil
+}
+
+// decodeOverflow decodes element stored in chain of overflow slabs
+// starting with slab id.
+func decodeOverflow(storage SlabStorage, id StorageID) (Serializable, error) {
+	var data []byte
+	for {
+		slab, err := retrieveOverflowSlab(storage, id)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, slab.data...)
+
+		if slab.next == 0 {
+			break
+		}
+		id = NewStorageID(id.Address, slab.next)
+	}
+
+	s, rest, err := decodeSerializable(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("wrong byte size for overflow element")
+	}
+	return s, nil
+}
+
+// removeOverflow removes chain of overflow slabs starting with slab id.
+func removeOverflow(storage SlabStorage, id StorageID) error {
+	for {
+		slab, err := retrieveOverflowSlab(storage, id)
+		if err != nil {
+			return err
+		}
+		storage.Remove(id)
+
+		if slab.next == 0 {
+			return nil
+		}
+		id = NewStorageID(id.Address, slab.next)
+	}
+}
+
+// OverflowID is element of array data slab referencing element stored in
+// chain of overflow slabs starting with slab id. It has its own CBOR tag,
+// so overflow elements are told apart from nested arrays and composites
+// referenced by StorageID without retrieving the slab.
+type OverflowID struct {
+	id StorageID
+}
+
+// Encode encodes OverflowID as
+//
+//	cbor.Tag{
+//			Number:  cborTagOverflowID,
+//			Content: []byte(address (8 bytes) + index (8 bytes)),
+//	}
+func (s *OverflowID) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagOverflowID
+	buf[2] = 0x40 | byte(16)
+	copy(buf[3:], s.id.Address[:])
+	binary.BigEndian.PutUint64(buf[11:], s.id.Index)
+
+	return buf, nil
+}
+
+func (s *OverflowID) Decode(b []byte) error {
+	if uint32(len(b)) < s.ByteSize() {
+		return errors.New("too short for OverflowID type")
+	}
+
+	if !bytes.Equal([]byte{0xd8, cborTagOverflowID, 0x40 | byte(16)}, b[:3]) {
+		return errors.New("not OverflowID type")
+	}
+
+	copy(s.id.Address[:], b[3:])
+	s.id.Index = binary.BigEndian.Uint64(b[11:])
+	return nil
+}
+
+func (s *OverflowID) ByteSize() uint32 {
+	// tag number (2 bytes) + byte string head (1 byte) + address (8 bytes) + index (8 bytes)
+	return 19
+}
+
+func (s *OverflowID) IsConstantSized() bool {
+	return true
+}
+
+// GetValue returns nil because element is stored in overflow slabs.
+// Arrays decode it through their SlabStorage (see ArrayValue.value).
+func (s *OverflowID) GetValue() Value {
+	return nil
+}
+
+// overflow returns element to be held in array data slab. Element larger
+// than maxInlineElementSize is stored in overflow slabs, and OverflowID
+// of the first slab is returned instead.
+func (v *ArrayValue) overflow(element Serializable) (Serializable, error) {
+	if element.ByteSize() <= maxInlineElementSize {
+		return element, nil
+	}
+
+	data, err := element.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := storeOverflow(v.storage, v.ID().Address, data)
+	if err != nil {
+		return nil, err
+	}
+	return &OverflowID{id: id}, nil
+}
+
+// encodeOverflowSlabs encodes overflow slabs of elements of data slab,
+// including elements of inlined arrays, as number of overflow slabs
+// (4 bytes) followed by index (8 bytes), size (4 bytes) and encoded data
+// of each overflow slab, and returns number of overflow slabs.
+// ArrayMetaSlab.Encode writes it before encoded data slab, so overflow
+// elements are decoded with the slab holding them.
+func encodeOverflowSlabs(storage SlabStorage, slab *ArraySlab) ([]byte, uint32, error) {
+	err := slab.load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, 4)
+	count := uint32(0)
+
+	var encode func(elements []Serializable) error
+	encode = func(elements []Serializable) error {
+		for _, e := range elements {
+			switch e := e.(type) {
+			case *InlineArraySerializable:
+				err := encode(e.slab.elements)
+				if err != nil {
+					return err
+				}
+
+			case *OverflowID:
+				for id := e.id; ; {
+					overflow, err := retrieveOverflowSlab(storage, id)
+					if err != nil {
+						return err
+					}
+					b, err := overflow.Encode()
+					if err != nil {
+						return err
+					}
+
+					var header [12]byte
+					binary.BigEndian.PutUint64(header[:], id.Index)
+					binary.BigEndian.PutUint32(header[8:], uint32(len(b)))
+					buf = append(buf, header[:]...)
+					buf = append(buf, b...)
+					count++
+
+					if overflow.next == 0 {
+						break
+					}
+					id = NewStorageID(id.Address, overflow.next)
+				}
+			}
+		}
+		return nil
+	}
+
+	err = encode(slab.elements)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	binary.BigEndian.PutUint32(buf, count)
+	return buf, count, nil
+}
+
+// decodeOverflowSlabs decodes overflow slabs owned by address encoded by
+// encodeOverflowSlabs, stores them in storage, and returns data following
+// them.
+func decodeOverflowSlabs(storage SlabStorage, address Address, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("too short for overflow slabs")
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 12 {
+			return nil, errors.New("too short for overflow slabs")
+		}
+		index := binary.BigEndian.Uint64(data)
+		size := binary.BigEndian.Uint32(data[8:])
+		data = data[12:]
+
+		if uint32(len(data)) < size {
+			return nil, errors.New("too short for overflow slabs")
+		}
+
+		slab := &OverflowSlab{id: NewStorageID(address, index)}
+		err := slab.Decode(data[:size])
+		if err != nil {
+			return nil, err
+		}
+		storage.Store(slab)
+
+		data = data[size:]
+	}
+
+	return data, nil
+}
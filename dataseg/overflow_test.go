@@ -0,0 +1,220 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyOverflowSlabs checks chain of overflow slabs starting with slab id,
+// adding their StorageIDs to slabIDs.
+func verifyOverflowSlabs(t *testing.T, storage SlabStorage, id StorageID, slabIDs map[StorageID]bool) {
+	for {
+		slab, err := retrieveOverflowSlab(storage, id)
+		require.NoError(t, err)
+
+		assert.False(t, slabIDs[id], "overflow slab %s is referenced twice", id)
+		slabIDs[id] = true
+
+		assert.True(t, slab.ByteSize() <= maxThreshold, "slab %s size %d exceeds %d", id, slab.ByteSize(), maxThreshold)
+
+		b, err := slab.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, int(slab.ByteSize()), len(b))
+
+		if slab.next == 0 {
+			return
+		}
+		id = NewStorageID(id.Address, slab.next)
+	}
+}
+
+// overflowSlabCount returns number of overflow slabs in storage.
+func overflowSlabCount(storage *BasicSlabStorage) int {
+	count := 0
+	for _, slab := range storage.slabs {
+		if _, ok := slab.(*OverflowSlab); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestArrayOverflow(t *testing.T) {
+
+	// Elements around maxInlineElementSize and elements
+	// chunked into several overflow slabs
+	newLargeValues := func() []Value {
+		return []Value{
+			UInt32Value(0),
+			StringValue(strings.Repeat("a", maxInlineElementSize-2)),
+			StringValue(strings.Repeat("b", maxInlineElementSize-1)),
+			UInt32Value(1),
+			BytesValue(make([]byte, 200)),
+			StringValue(strings.Repeat("c", 5000)),
+			SomeValue{Value: StringValue(strings.Repeat("d", 100))},
+		}
+	}
+
+	t.Run("append set remove", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newLargeValues()
+		array := newTestArrayValue(t, storage, values)
+		verifyArrayTree(t, array, values)
+
+		node, err := array.node()
+		require.NoError(t, err)
+		for i, inline := range []bool{true, true, false, true, false, false, false} {
+			element, err := node.Get(array.storage, uint32(i))
+			require.NoError(t, err)
+			_, overflow := element.(*OverflowID)
+			assert.Equal(t, !inline, overflow, "element %d", i)
+		}
+
+		// Overflow slabs of replaced element are removed
+		require.NoError(t, array.Set(5, StringValue(strings.Repeat("e", 1000))))
+		values[5] = StringValue(strings.Repeat("e", 1000))
+		verifyArrayTree(t, array, values)
+
+		require.NoError(t, array.Set(4, UInt32Value(4)))
+		values[4] = UInt32Value(4)
+		verifyArrayTree(t, array, values)
+
+		for i := 0; i < 100; i++ {
+			v := StringValue(strings.Repeat("f", i*10))
+			require.NoError(t, array.Insert(uint32(i%len(values)), v))
+
+			index := i % len(values)
+			values = append(values, nil)
+			copy(values[index+1:], values[index:])
+			values[index] = v
+		}
+		verifyArrayTree(t, array, values)
+
+		// Overflow slabs of removed elements are removed
+		for len(values) > 0 {
+			require.NoError(t, array.Remove(0))
+			values = values[1:]
+		}
+		verifyArrayTree(t, array, values)
+		assert.Equal(t, 2, len(storage.slabs))
+		assert.Equal(t, 0, overflowSlabCount(storage))
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		values := newLargeValues()
+		array := newTestArrayValue(t, storage, values)
+		slabCount := len(storage.slabs)
+		overflowCount := overflowSlabCount(storage)
+		require.True(t, overflowCount > 0)
+
+		// Overflow slabs aren't stored for element which isn't added
+		v := StringValue(strings.Repeat("g", 1000))
+		require.Error(t, array.Insert(uint32(len(values)+1), v))
+		require.Error(t, array.Set(uint32(len(values)), v))
+		assert.Equal(t, slabCount, len(storage.slabs))
+		assert.Equal(t, overflowCount, overflowSlabCount(storage))
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("nested inlined", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		child := newTestArrayValue(t, storage, nil)
+		parent := newTestArrayValue(t, storage, []Value{child})
+
+		// Reference to overflow slab is small enough to be inlined
+		v := StringValue(strings.Repeat("a", 500))
+		require.NoError(t, child.Append(v))
+		require.NotNil(t, child.inlined)
+		requireArrayValues(t, child, []Value{v})
+		verifyArrayTree(t, parent, []Value{child})
+		overflowCount := overflowSlabCount(storage)
+
+		require.Error(t, child.Insert(2, v))
+		require.Error(t, child.Set(1, v))
+		assert.Equal(t, overflowCount, overflowSlabCount(storage))
+		verifyArrayTree(t, parent, []Value{child})
+
+		require.NoError(t, child.Remove(0))
+		requireArrayValues(t, child, nil)
+		verifyArrayTree(t, parent, []Value{child})
+		assert.Equal(t, 0, overflowSlabCount(storage))
+	})
+
+	t.Run("encoded data", func(t *testing.T) {
+		values := append(newLargeValues(), newLargeValues()...)
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+
+		b, err := array.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		// Overflow slabs are decoded with data slabs into new storage
+		for _, decode := range []func(SlabStorage, []byte) (*ArrayValue, error){
+			NewArrayValueFromEncodedData,
+			NewLazyArrayValueFromEncodedData,
+		} {
+			array2, err := decode(NewBasicSlabStorage(), b)
+			require.NoError(t, err)
+			verifyArrayTree(t, array2, values)
+
+			b2, err := array2.GetSerizable().Encode()
+			require.NoError(t, err)
+			assert.Equal(t, b, b2)
+		}
+
+		// Overflow slabs of elements of inlined array
+		child := newTestArrayValue(t, array.storage, nil)
+		parent := newTestArrayValue(t, array.storage, []Value{child})
+		v := StringValue(strings.Repeat("a", 500))
+		require.NoError(t, child.Append(v))
+		require.NotNil(t, child.inlined)
+
+		b, err = parent.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		parent2, err := NewArrayValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		verifyArrayTree(t, parent2, []Value{child})
+		element, err := parent2.Get(0)
+		require.NoError(t, err)
+		requireArrayValues(t, element.(*ArrayValue), []Value{v})
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		committed := openTestFileSlabStorage(t, path)
+
+		values := newLargeValues()
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+		require.NoError(t, array.Commit(committed))
+		require.NoError(t, committed.Close())
+
+		committed = openTestFileSlabStorage(t, path)
+		defer committed.Close()
+
+		array2, err := NewArrayValueFromStorage(committed, array.ID())
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		values := newLargeValues()
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		require.NoError(t, array.Remove(5))
+		require.NoError(t, array.Set(1, UInt32Value(1)))
+
+		verifyArraySnapshot(t, snapshot, values)
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+// SetValue is a set of elements stored in dictionary slabs,
+// with elements as keys without values.
+type SetValue struct {
+	slabContainer
+
+	metaSlab *DictionaryMetaSlab
+}
+
+// NewSetValue creates empty SetValue, storing its slabs owned by address
+// in storage. Elements are partitioned among slabs by masks of their hash
+// in the same way as DictionaryValue keys.
+func NewSetValue(storage SlabStorage, address Address) (*SetValue, error) {
+	container := newSlabContainer(storage)
+
+	metaSlab, err := newDictionaryMetaSlab(container.storage, address, NewAcceptAllMask())
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &SetValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewSetValueFromEncodedData decodes SetValue from data, storing its slabs in storage.
+func NewSetValueFromEncodedData(storage SlabStorage, data []byte) (*SetValue, error) {
+	container := newSlabContainer(storage)
+
+	// Meta slab id is decoded from data
+	metaSlab := &DictionaryMetaSlab{header: &DictionarySlabHeader{mask: NewAcceptAllMask()}}
+
+	err := metaSlab.decode(container.storage, data)
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &SetValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewSetValueFromStorage loads SetValue with root slab id from storage.
+// Slabs are retrieved from storage on access.
+func NewSetValueFromStorage(storage SlabStorage, id StorageID) (*SetValue, error) {
+	root, err := retrieveRootSlab(storage, id, setKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SetValue{slabContainer: newSlabContainer(storage), metaSlab: root.(*DictionaryMetaSlab)}, nil
+}
+
+// root retrieves root slab of set from storage.
+func (v *SetValue) root() (*DictionaryMetaSlab, error) {
+	root, err := v.retrieveRoot(v.metaSlab.ID(), setKind)
+	if err != nil {
+		return nil, err
+	}
+	return root.(*DictionaryMetaSlab), nil
+}
+
+// rootForUpdate retrieves root slab which is about to be modified.
+func (v *SetValue) rootForUpdate() (*DictionaryMetaSlab, error) {
+	if err := v.checkWritable(); err != nil {
+		return nil, err
+	}
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	v.storage.beforeUpdate(metaSlab)
+	return metaSlab, nil
+}
+
+func (v *SetValue) GetSerizable() Serializable {
+	metaSlab, err := v.root()
+	if err != nil {
+		metaSlab = v.metaSlab
+	}
+	return &containerSerializable{root: metaSlab, storage: v.storage, value: v}
+}
+
+// Size returns number of elements, or 0 if root slab can't be retrieved.
+func (v *SetValue) Size() uint32 {
+	metaSlab, err := v.root()
+	if err != nil {
+		return 0
+	}
+	return metaSlab.GetCount()
+}
+
+// Contains returns true if element is in set.
+func (v *SetValue) Contains(element Value) (bool, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return false, err
+	}
+
+	k := element.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return false, err
+	}
+
+	_, found, err := metaSlab.Get(v.storage, hash, k)
+	return found, err
+}
+
+// Add adds element to set. It returns false if element is already in set.
+func (v *SetValue) Add(element Value) (bool, error) {
+	k := element.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return false, err
+	}
+
+	return v.add(dictionaryEntry{hash: hash, key: k})
+}
+
+func (v *SetValue) add(entry dictionaryEntry) (bool, error) {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return false, err
+	}
+	added, err := metaSlab.Set(v.storage, entry)
+	if err != nil {
+		return false, err
+	}
+	return added, metaSlab.updateRoot(v.storage)
+}
+
+// Remove removes element. It returns false if element isn't in set.
+func (v *SetValue) Remove(element Value) (bool, error) {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return false, err
+	}
+
+	k := element.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := metaSlab.Remove(v.storage, hash, k)
+	if err != nil {
+		return false, err
+	}
+	return removed, metaSlab.updateRoot(v.storage)
+}
+
+// Iterate calls fn for each element in order of element hash
+// until fn returns false or error.
+func (v *SetValue) Iterate(fn func(Value) (bool, error)) error {
+	metaSlab, err := v.root()
+	if err != nil {
+		return err
+	}
+	return metaSlab.Iterate(v.storage, func(key Serializable, _ Serializable) (bool, error) {
+		return fn(key.GetValue())
+	})
+}
+
+// Union returns new set with elements in either set, storing its slabs
+// owned by address in storage.
+func (v *SetValue) Union(other *SetValue, storage SlabStorage, address Address) (*SetValue, error) {
+	return v.combine(other, storage, address, func(inThis, inOther bool) bool {
+		return true
+	})
+}
+
+// Intersect returns new set with elements in both sets, storing its slabs
+// owned by address in storage.
+func (v *SetValue) Intersect(other *SetValue, storage SlabStorage, address Address) (*SetValue, error) {
+	return v.combine(other, storage, address, func(inThis, inOther bool) bool {
+		return inThis && inOther
+	})
+}
+
+// Difference returns new set with elements in this set which aren't in
+// other set, storing its slabs owned by address in storage.
+func (v *SetValue) Difference(other *SetValue, storage SlabStorage, address Address) (*SetValue, error) {
+	return v.combine(other, storage, address, func(inThis, inOther bool) bool {
+		return inThis && !inOther
+	})
+}
+
+// combine returns new set with elements for which include returns true.
+// Both sets are iterated one slab at a time in order of element hash,
+// so neither set is loaded in memory.
+func (v *SetValue) combine(other *SetValue, storage SlabStorage, address Address, include func(inThis, inOther bool) bool) (*SetValue, error) {
+	root1, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	root2, err := other.root()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := NewSetValue(storage, address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mergeDictionaries(v.storage, root1, other.storage, root2, func(entry dictionaryEntry, inThis, inOther bool) error {
+		if !include(inThis, inOther) {
+			return nil
+		}
+		_, err := result.add(entry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSetValue(t *testing.T, storage SlabStorage, elements map[Value]bool) *SetValue {
+	set, err := NewSetValue(storage, testAddress)
+	require.NoError(t, err)
+
+	for e := range elements {
+		added, err := set.Add(e)
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+	return set
+}
+
+func verifySet(t *testing.T, set *SetValue, elements map[Value]bool) {
+	root, err := set.root()
+	require.NoError(t, err)
+
+	verifyDictionarySlabs(t, root, set.storage)
+
+	require.Equal(t, uint32(len(elements)), set.Size())
+	for e := range elements {
+		found, err := set.Contains(e)
+		require.NoError(t, err)
+		require.True(t, found, "element %v not found", e)
+	}
+
+	iterated := make(map[Value]bool)
+	err = set.Iterate(func(e Value) (bool, error) {
+		assert.True(t, elements[e], "element %v isn't in set", e)
+		assert.False(t, iterated[e], "element %v is iterated twice", e)
+		iterated[e] = true
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(elements), len(iterated))
+}
+
+func uint32Elements(start, end int) map[Value]bool {
+	elements := make(map[Value]bool)
+	for i := start; i < end; i++ {
+		elements[UInt32Value(i)] = true
+	}
+	return elements
+}
+
+func TestSetAddRemove(t *testing.T) {
+
+	const setSize = 2000
+
+	elements := make(map[Value]bool)
+
+	storage := NewBasicSlabStorage()
+	set := newTestSetValue(t, storage, nil)
+
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(setSize) {
+		added, err := set.Add(UInt32Value(i))
+		require.NoError(t, err)
+		assert.True(t, added)
+		elements[UInt32Value(i)] = true
+	}
+	verifySet(t, set, elements)
+
+	// Adding element again doesn't change set
+	for i := 0; i < setSize; i += 3 {
+		added, err := set.Add(UInt32Value(i))
+		require.NoError(t, err)
+		assert.False(t, added)
+	}
+	verifySet(t, set, elements)
+
+	found, err := set.Contains(UInt32Value(setSize))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	removed, err := set.Remove(UInt32Value(setSize))
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	for i, k := range r.Perm(setSize) {
+		removed, err := set.Remove(UInt32Value(k))
+		require.NoError(t, err)
+		assert.True(t, removed)
+		delete(elements, UInt32Value(k))
+
+		if i%500 == 0 {
+			verifySet(t, set, elements)
+		}
+	}
+	verifySet(t, set, elements)
+
+	// Empty set has root and one data slab
+	assert.Equal(t, 2, len(storage.slabs))
+}
+
+func TestSetOperations(t *testing.T) {
+
+	test := func(t *testing.T, elements1, elements2 map[Value]bool) {
+		set1 := newTestSetValue(t, NewBasicSlabStorage(), elements1)
+		set2 := newTestSetValue(t, NewBasicSlabStorage(), elements2)
+
+		union := make(map[Value]bool)
+		intersection := make(map[Value]bool)
+		difference := make(map[Value]bool)
+		for e := range elements1 {
+			union[e] = true
+			if elements2[e] {
+				intersection[e] = true
+			} else {
+				difference[e] = true
+			}
+		}
+		for e := range elements2 {
+			union[e] = true
+		}
+
+		result, err := set1.Union(set2, NewBasicSlabStorage(), testAddress)
+		require.NoError(t, err)
+		verifySet(t, result, union)
+
+		result, err = set1.Intersect(set2, NewBasicSlabStorage(), testAddress)
+		require.NoError(t, err)
+		verifySet(t, result, intersection)
+
+		result, err = set1.Difference(set2, NewBasicSlabStorage(), testAddress)
+		require.NoError(t, err)
+		verifySet(t, result, difference)
+
+		// Operands aren't modified
+		verifySet(t, set1, elements1)
+		verifySet(t, set2, elements2)
+	}
+
+	t.Run("overlapping", func(t *testing.T) {
+		test(t, uint32Elements(0, 1500), uint32Elements(1000, 2500))
+	})
+
+	t.Run("disjoint", func(t *testing.T) {
+		test(t, uint32Elements(0, 1000), uint32Elements(1000, 2000))
+	})
+
+	t.Run("same elements", func(t *testing.T) {
+		test(t, uint32Elements(0, 1000), uint32Elements(0, 1000))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		test(t, uint32Elements(0, 1000), nil)
+		test(t, nil, uint32Elements(0, 1000))
+		test(t, nil, nil)
+	})
+
+	t.Run("colliding hashes", func(t *testing.T) {
+		setTestKeyHash(t, func(data []byte) string {
+			hash := sha256.Sum256([]byte{data[len(data)-1] % 8})
+			return string(hash[:])
+		})
+
+		test(t, uint32Elements(0, 600), uint32Elements(300, 900))
+	})
+}
+
+func TestSetEncodeDecode(t *testing.T) {
+
+	elements := uint32Elements(0, 2000)
+
+	t.Run("encoded data", func(t *testing.T) {
+		set := newTestSetValue(t, NewBasicSlabStorage(), elements)
+
+		b, err := set.GetSerizable().Encode()
+		require.NoError(t, err)
+
+		set2, err := NewSetValueFromEncodedData(NewBasicSlabStorage(), b)
+		require.NoError(t, err)
+		assert.Equal(t, set.metaSlab.ID(), set2.metaSlab.ID())
+		verifySet(t, set2, elements)
+	})
+
+	t.Run("file storage", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "slabs")
+		storage := openTestFileSlabStorage(t, path)
+
+		set := newTestSetValue(t, storage, elements)
+		id := set.metaSlab.ID()
+		require.NoError(t, storage.Close())
+
+		storage = openTestFileSlabStorage(t, path)
+		defer storage.Close()
+
+		set, err := NewSetValueFromStorage(storage, id)
+		require.NoError(t, err)
+		verifySet(t, set, elements)
+	})
+}
@@ -1,7 +1,14 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+)
+
 type Segmentable interface {
-	Split() (Segmentable, error)
+	// Split moves part of the content to a new segment with StorageID
+	// allocated by storage.
+	Split(SlabStorage) (Segmentable, error)
 	Merge(Segmentable) error
 	ByteSize() uint32
 }
@@ -9,21 +16,40 @@ type Segmentable interface {
 type Slab interface {
 	Storable
 	Segmentable
+
+	// Clone returns a copy of the slab which doesn't share mutable data,
+	// so modifying the copy doesn't modify the original.
+	Clone() Slab
 }
 
 type SlabStorage interface {
 	Store(Slab)
 	Retrieve(StorageID) (Slab, bool, error)
 	Remove(StorageID)
+
+	// GenerateStorageID returns a new StorageID owned by address.
+	// Index of returned StorageID increases monotonically per address,
+	// and is greater than index of any slab stored with the same address.
+	GenerateStorageID(Address) (StorageID, error)
 }
 
 // think of it as ledger
 type BasicSlabStorage struct {
-	slabs map[StorageID]Slab
+	slabs        map[StorageID]Slab
+	storageIndex map[Address]uint64
 }
 
 func NewBasicSlabStorage() *BasicSlabStorage {
-	return &BasicSlabStorage{slabs: make(map[StorageID]Slab)}
+	return &BasicSlabStorage{
+		slabs:        make(map[StorageID]Slab),
+		storageIndex: make(map[Address]uint64),
+	}
+}
+
+func (s *BasicSlabStorage) GenerateStorageID(address Address) (StorageID, error) {
+	index := s.storageIndex[address] + 1
+	s.storageIndex[address] = index
+	return NewStorageID(address, index), nil
 }
 
 func (s *BasicSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
@@ -32,9 +58,107 @@ func (s *BasicSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
 }
 
 func (s *BasicSlabStorage) Store(slab Slab) {
-	s.slabs[slab.ID()] = slab
+	id := slab.ID()
+	s.slabs[id] = slab
+
+	// Don't reuse index of slabs stored with externally allocated StorageID
+	// (e.g. decoded from data).
+	if id.Index > s.storageIndex[id.Address] {
+		s.storageIndex[id.Address] = id.Index
+	}
 }
 
 func (s *BasicSlabStorage) Remove(id StorageID) {
 	delete(s.slabs, id)
 }
+
+// trackedSlabStorage records StorageIDs of slabs stored or removed
+// through it, so that only changed slabs are committed.
+type trackedSlabStorage struct {
+	SlabStorage
+	stored  map[StorageID]bool
+	removed map[StorageID]bool
+
+	// snapshots preserve versions of slabs before they are modified
+	snapshots []*snapshotSlabStorage
+}
+
+func newTrackedSlabStorage(storage SlabStorage) *trackedSlabStorage {
+	return &trackedSlabStorage{
+		SlabStorage: storage,
+		stored:      make(map[StorageID]bool),
+		removed:     make(map[StorageID]bool),
+	}
+}
+
+func (s *trackedSlabStorage) Store(slab Slab) {
+	s.SlabStorage.Store(slab)
+	s.stored[slab.ID()] = true
+	delete(s.removed, slab.ID())
+}
+
+func (s *trackedSlabStorage) Remove(id StorageID) {
+	if len(s.snapshots) > 0 {
+		slab, found, err := s.SlabStorage.Retrieve(id)
+		if err == nil && found {
+			s.beforeUpdate(slab)
+		}
+	}
+	s.SlabStorage.Remove(id)
+	s.removed[id] = true
+	delete(s.stored, id)
+}
+
+// beforeUpdate preserves a copy of slab for each snapshot which doesn't
+// have one yet. It must be called before slab is modified or removed.
+func (s *trackedSlabStorage) beforeUpdate(slab Slab) {
+	for _, snapshot := range s.snapshots {
+		if _, ok := snapshot.preserved[slab.ID()]; !ok {
+			snapshot.preserved[slab.ID()] = slab.Clone()
+		}
+	}
+
+	// Value nested in container is loaded with storage tracking changes
+	// of the container, so snapshots of the container preserve its slabs.
+	if tracked, ok := s.SlabStorage.(*trackedSlabStorage); ok {
+		tracked.beforeUpdate(slab)
+	}
+}
+
+// commit writes copies of slabs stored since last commit to storage,
+// and removes slabs removed since last commit from storage.
+func (s *trackedSlabStorage) commit(storage SlabStorage) error {
+	for _, id := range sortedStorageIDs(s.stored) {
+		slab, found, err := s.Retrieve(id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("slab %s not found", id)
+		}
+
+		storage.Store(slab.Clone())
+	}
+
+	for _, id := range sortedStorageIDs(s.removed) {
+		storage.Remove(id)
+	}
+
+	s.reset()
+	return nil
+}
+
+func (s *trackedSlabStorage) reset() {
+	s.stored = make(map[StorageID]bool)
+	s.removed = make(map[StorageID]bool)
+}
+
+// sortedStorageIDs returns ids in ascending order.
+func sortedStorageIDs(ids map[StorageID]bool) []StorageID {
+	sorted := make([]StorageID, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Less(sorted[j]) })
+	return sorted
+}
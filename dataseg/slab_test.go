@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicSlabStorageGenerateStorageID(t *testing.T) {
+
+	address1 := Address{0, 0, 0, 0, 0, 0, 0, 1}
+	address2 := Address{0, 0, 0, 0, 0, 0, 0, 2}
+
+	storage := NewBasicSlabStorage()
+
+	for i := uint64(1); i <= 3; i++ {
+		id, err := storage.GenerateStorageID(address1)
+		require.NoError(t, err)
+		assert.Equal(t, NewStorageID(address1, i), id)
+	}
+
+	// Each address has its own index
+	id, err := storage.GenerateStorageID(address2)
+	require.NoError(t, err)
+	assert.Equal(t, NewStorageID(address2, 1), id)
+
+	// Index isn't reused after storing slab with higher index
+	slab := &ArraySlab{header: &ArraySlabHeader{id: NewStorageID(address2, 10)}}
+	storage.Store(slab)
+
+	id, err = storage.GenerateStorageID(address2)
+	require.NoError(t, err)
+	assert.Equal(t, NewStorageID(address2, 11), id)
+
+	id, err = storage.GenerateStorageID(address1)
+	require.NoError(t, err)
+	assert.Equal(t, NewStorageID(address1, 4), id)
+}
+
+func TestDeterministicStorageID(t *testing.T) {
+
+	newEncodedArray := func() []byte {
+		array := newTestArrayValue(t, NewBasicSlabStorage(), nil)
+		for i := 0; i < 1000; i++ {
+			err := array.Append(UInt32Value(i))
+			require.NoError(t, err)
+		}
+		for i := 0; i < 500; i++ {
+			err := array.Remove(uint32(i))
+			require.NoError(t, err)
+		}
+
+		b, err := array.GetSerizable().Encode()
+		require.NoError(t, err)
+		return b
+	}
+
+	assert.Equal(t, newEncodedArray(), newEncodedArray())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// snapshotSlabStorage retrieves slabs preserved for a snapshot, and slabs
+// not modified since snapshot from live storage. It is read-only, so values
+// using it reject modification before slabs are stored or removed.
+type snapshotSlabStorage struct {
+	live      SlabStorage
+	preserved map[StorageID]Slab
+
+	// err records attempt to store or remove slab, which SlabStorage
+	// can't return, so that it is returned by following operations.
+	err error
+}
+
+var _ SlabStorage = &snapshotSlabStorage{}
+
+func (s *snapshotSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	if slab, ok := s.preserved[id]; ok {
+		return slab, true, nil
+	}
+
+	slab, found, err := s.live.Retrieve(id)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	// Array modifies headers of its meta slabs in place, so snapshot uses
+	// copies of meta slabs it's traversing instead of sharing them with array.
+	if meta, ok := slab.(*ArrayMetaSlab); ok {
+		return meta.Clone(), true, nil
+	}
+	return slab, true, nil
+}
+
+func (s *snapshotSlabStorage) Store(slab Slab) {
+	s.err = fmt.Errorf("can't store slab %s in snapshot", slab.ID())
+}
+
+func (s *snapshotSlabStorage) Remove(id StorageID) {
+	s.err = fmt.Errorf("can't remove slab %s from snapshot", id)
+}
+
+func (s *snapshotSlabStorage) GenerateStorageID(Address) (StorageID, error) {
+	if s.err != nil {
+		return StorageID{}, s.err
+	}
+	return StorageID{}, errors.New("can't generate storage id in snapshot")
+}
+
+// readOnly returns true if slabs are stored in snapshot storage,
+// directly or through other tracked storages.
+func (s *trackedSlabStorage) readOnly() bool {
+	switch storage := s.SlabStorage.(type) {
+	case *snapshotSlabStorage:
+		return true
+	case *trackedSlabStorage:
+		return storage.readOnly()
+	default:
+		return false
+	}
+}
+
+// checkWritable returns error if container value is a version from
+// snapshot, including values nested in snapshot.
+func (c *slabContainer) checkWritable() error {
+	if c.storage.readOnly() {
+		return errors.New("can't modify value in snapshot")
+	}
+	return nil
+}
+
+// ArraySnapshot is an immutable version of ArrayValue. Slabs are shared
+// with the array until the array modifies them, at which point the array
+// preserves a copy for the snapshot.
+type ArraySnapshot struct {
+	array   *ArrayValue
+	source  *trackedSlabStorage
+	storage *snapshotSlabStorage
+}
+
+// Snapshot returns an immutable snapshot of current version of array.
+// Snapshot should be released when no longer needed, so array stops
+// preserving slabs for it.
+func (v *ArrayValue) Snapshot() (*ArraySnapshot, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &snapshotSlabStorage{
+		live:      v.storage.SlabStorage,
+		preserved: make(map[StorageID]Slab),
+	}
+
+	// Snapshot root is a copy, so it doesn't share root with array
+	root := metaSlab.Clone().(*ArrayMetaSlab)
+	storage.preserved[root.ID()] = root
+
+	array := &ArrayValue{slabContainer: newSlabContainer(storage), metaSlab: root}
+
+	v.storage.snapshots = append(v.storage.snapshots, storage)
+
+	return &ArraySnapshot{
+		array:   array,
+		source:  v.storage,
+		storage: storage,
+	}, nil
+}
+
+func (s *ArraySnapshot) Size() uint32 {
+	return s.array.Size()
+}
+
+func (s *ArraySnapshot) Get(index uint32) (Value, error) {
+	return s.array.Get(index)
+}
+
+// Iterate calls fn for each element in order until fn returns false or error.
+func (s *ArraySnapshot) Iterate(fn func(Value) (bool, error)) error {
+	return s.array.Iterate(fn)
+}
+
+// Release stops preserving slabs for this snapshot. Snapshot must not
+// be used after it is released.
+func (s *ArraySnapshot) Release() {
+	snapshots := s.source.snapshots
+	for i, snapshot := range snapshots {
+		if snapshot == s.storage {
+			s.source.snapshots = append(snapshots[:i:i], snapshots[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyArraySnapshot(t *testing.T, snapshot *ArraySnapshot, values []Value) {
+	require.Equal(t, uint32(len(values)), snapshot.Size())
+
+	for i := 0; i < len(values); i++ {
+		v, err := snapshot.Get(uint32(i))
+		require.NoError(t, err)
+		require.Equal(t, values[i], v)
+	}
+
+	i := 0
+	err := snapshot.Iterate(func(v Value) (bool, error) {
+		require.Equal(t, values[i], v)
+		i++
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(values), i)
+}
+
+func TestArraySnapshot(t *testing.T) {
+
+	values := make([]Value, 2000)
+	for i := 0; i < len(values); i++ {
+		values[i] = UInt32Value(i)
+	}
+
+	t.Run("copy on write", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+		array := newTestArrayValue(t, storage, values)
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		// Only root is copied when snapshot is created
+		assert.Equal(t, 1, len(snapshot.storage.preserved))
+
+		err = array.Set(1000, UInt32Value(0))
+		require.NoError(t, err)
+
+		// Modified slabs on the path from root are copied
+		assert.True(t, len(snapshot.storage.preserved) > 1)
+		assert.True(t, len(snapshot.storage.preserved) < 10)
+
+		newValues := append([]Value(nil), values...)
+		newValues[1000] = UInt32Value(0)
+
+		for i := 0; i < 1500; i++ {
+			err := array.Remove(0)
+			require.NoError(t, err)
+		}
+		newValues = newValues[1500:]
+
+		for i := 0; i < 100; i++ {
+			err := array.Insert(10, UInt32Value(i))
+			require.NoError(t, err)
+			newValues = append(newValues[:10], append([]Value{UInt32Value(i)}, newValues[10:]...)...)
+
+			err = array.Append(UInt32Value(i))
+			require.NoError(t, err)
+			newValues = append(newValues, UInt32Value(i))
+		}
+
+		verifyArraySnapshot(t, snapshot, values)
+		verifyArrayTree(t, array, newValues)
+	})
+
+	t.Run("modify during iteration", func(t *testing.T) {
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		i := 0
+		err = snapshot.Iterate(func(v Value) (bool, error) {
+			require.Equal(t, values[i], v)
+			i++
+
+			err := array.Remove(0)
+			require.NoError(t, err)
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, len(values), i)
+		assert.Equal(t, uint32(0), array.Size())
+	})
+
+	t.Run("multiple snapshots", func(t *testing.T) {
+		array := newTestArrayValue(t, NewBasicSlabStorage(), values)
+
+		snapshot1, err := array.Snapshot()
+		require.NoError(t, err)
+
+		err = array.Append(UInt32Value(2000))
+		require.NoError(t, err)
+		values2 := append(append([]Value(nil), values...), UInt32Value(2000))
+
+		snapshot2, err := array.Snapshot()
+		require.NoError(t, err)
+
+		err = array.Set(0, UInt32Value(2001))
+		require.NoError(t, err)
+
+		verifyArraySnapshot(t, snapshot1, values)
+		verifyArraySnapshot(t, snapshot2, values2)
+
+		snapshot1.Release()
+		snapshot2.Release()
+		assert.Equal(t, 0, len(array.storage.snapshots))
+
+		// Released snapshot doesn't preserve slabs
+		preserved := len(snapshot1.storage.preserved)
+		err = array.Set(1000, UInt32Value(2002))
+		require.NoError(t, err)
+		assert.Equal(t, preserved, len(snapshot1.storage.preserved))
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		inlined := newTestArrayValue(t, storage, []Value{UInt32Value(0)})
+		nested := newTestArrayValue(t, storage, values[:100])
+		array := newTestArrayValue(t, storage, []Value{inlined, nested, UInt32Value(1)})
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		// Arrays nested in snapshot reject modification
+		for i := uint32(0); i < 2; i++ {
+			v, err := snapshot.Get(i)
+			require.NoError(t, err)
+			child := v.(*ArrayValue)
+			size := child.Size()
+
+			require.Error(t, child.Append(UInt32Value(2)))
+			require.Error(t, child.Insert(0, UInt32Value(2)))
+			require.Error(t, child.Set(0, UInt32Value(2)))
+			require.Error(t, child.Remove(0))
+			assert.Equal(t, size, child.Size())
+
+			// Array from snapshot can't be added to live array
+			require.Error(t, array.Append(child))
+		}
+
+		require.Error(t, snapshot.array.Append(UInt32Value(2)))
+		verifyArrayTree(t, array, []Value{inlined, nested, UInt32Value(1)})
+	})
+
+	t.Run("nested arrays", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		inlined := newTestArrayValue(t, storage, []Value{UInt32Value(0)})
+		nested := newTestArrayValue(t, storage, values[:1000])
+		array := newTestArrayValue(t, storage, []Value{inlined, nested})
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		// Modify nested arrays through array, including their non-root slabs
+		for i := uint32(0); i < 2; i++ {
+			v, err := array.Get(i)
+			require.NoError(t, err)
+			child := v.(*ArrayValue)
+			for j := 0; j < 500; j++ {
+				require.NoError(t, child.Append(UInt32Value(j)))
+			}
+			require.NoError(t, child.Set(0, UInt32Value(1)))
+			require.NoError(t, child.Remove(1))
+		}
+
+		// Nested arrays in snapshot keep their version at snapshot time
+		for i, want := range [][]Value{{UInt32Value(0)}, values[:1000]} {
+			v, err := snapshot.Get(uint32(i))
+			require.NoError(t, err)
+			child := v.(*ArrayValue)
+			require.Equal(t, uint32(len(want)), child.Size())
+			j := 0
+			err = child.Iterate(func(v Value) (bool, error) {
+				require.Equal(t, want[j], v)
+				j++
+				return true, nil
+			})
+			require.NoError(t, err)
+		}
+
+		v, err := array.Get(1)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1499), v.(*ArrayValue).Size())
+	})
+
+	t.Run("read-only composite", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+
+		fields := map[string]Value{"balance": UInt64Value(1)}
+		composite := newTestCompositeValue(t, storage, fields)
+		array := newTestArrayValue(t, storage, []Value{composite})
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		v, err := snapshot.Get(0)
+		require.NoError(t, err)
+		child := v.(*CompositeValue)
+
+		require.Error(t, child.SetField("balance", UInt64Value(2)))
+		require.Error(t, child.SetField("id", UInt64Value(2)))
+		_, err = child.RemoveField("balance")
+		require.Error(t, err)
+
+		for _, c := range []*CompositeValue{composite, child} {
+			assert.Equal(t, uint32(1), c.FieldCount())
+			v, found, err := c.GetField("balance")
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, UInt64Value(1), v)
+		}
+	})
+
+	t.Run("store in snapshot storage", func(t *testing.T) {
+		storage := NewBasicSlabStorage()
+		array := newTestArrayValue(t, storage, values[:10])
+
+		snapshot, err := array.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+
+		// Writes to snapshot storage are returned as errors of following operations
+		snapshot.storage.Store(snapshot.array.metaSlab)
+		_, _, err = snapshot.storage.Retrieve(array.metaSlab.ID())
+		require.Error(t, err)
+		_, err = snapshot.Get(0)
+		require.Error(t, err)
+	})
+}
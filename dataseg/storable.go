@@ -4,9 +4,34 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
-type StorageID uint32
+// Address is the address of account owning slabs.
+type Address [8]byte
+
+// StorageID identifies a slab by its owner address and an index
+// allocated by SlabStorage, which increases monotonically per owner.
+type StorageID struct {
+	Address Address
+	Index   uint64
+}
+
+func NewStorageID(address Address, index uint64) StorageID {
+	return StorageID{Address: address, Index: index}
+}
+
+func (id StorageID) String() string {
+	return fmt.Sprintf("0x%x.%d", id.Address[:], id.Index)
+}
+
+// Less returns true if id is ordered before other by address and then by index.
+func (id StorageID) Less(other StorageID) bool {
+	if c := bytes.Compare(id.Address[:], other.Address[:]); c != 0 {
+		return c < 0
+	}
+	return id.Index < other.Index
+}
 
 type Serializable interface {
 	Encode() ([]byte, error)
@@ -29,9 +54,37 @@ type Storage interface {
 }
 
 const (
-	cborTagStorageID = 255
+	cborTagStorageID  = 255
+	cborTagOverflowID = 254
+
+	cborTagSomeValue      = 130
+	cborTagAddressValue   = 131
+	cborTagCompositeValue = 132
+	cborTagArrayValue     = 134
+	cborTagCharacterValue = 136
+	cborTagPathValue      = 200
+
+	cborTagInt8Value   = 153
+	cborTagInt16Value  = 154
+	cborTagInt32Value  = 155
+	cborTagInt64Value  = 156
+	cborTagInt128Value = 157
+	cborTagInt256Value = 158
+
+	cborTagUInt8Value   = 161
+	cborTagUInt16Value  = 162
+	cborTagUInt32Value  = 163
+	cborTagUInt64Value  = 164
+	cborTagUInt128Value = 165
+	cborTagUInt256Value = 166
+
+	cborTagWord8Value  = 169
+	cborTagWord16Value = 170
+	cborTagWord32Value = 171
+	cborTagWord64Value = 172
 
-	cborTagUInt32Value = 163
+	cborTagFix64Value  = 179
+	cborTagUFix64Value = 187
 )
 
 type UInt32Serializable struct {
@@ -70,7 +123,7 @@ func (s *UInt32Serializable) Decode(b []byte) error {
 	}
 
 	s.v = UInt32Value(binary.BigEndian.Uint32(b[3:]))
-	s.cached = b
+	s.cached = b[:s.ByteSize()]
 	return nil
 }
 
@@ -87,34 +140,116 @@ func (s *UInt32Serializable) GetValue() Value {
 }
 
 func decodeSerializable(data []byte) (Serializable, []byte, error) {
-	if len(data) < 2 {
+	if len(data) == 0 {
 		return nil, data, errors.New("wrong data size")
 	}
 
-	if bytes.Equal([]byte{0xd8, cborTagUInt32Value}, data[:2]) {
-		s := &UInt32Serializable{}
-		err := s.Decode(data)
-		if err != nil {
-			return nil, data, err
+	var s Serializable
+	switch data[0] & 0xe0 {
+	case cborMajorByteString:
+		s = &BytesSerializable{}
+	case cborMajorTextString:
+		s = &StringSerializable{}
+	case cborMajorSimpleValue:
+		switch data[0] {
+		case cborFalse, cborTrue:
+			s = &BoolSerializable{}
+		case cborNil:
+			s = &NilSerializable{}
+		default:
+			return nil, nil, errors.New("not supported serializable format")
+		}
+	default:
+		if len(data) < 2 || data[0] != 0xd8 {
+			return nil, nil, errors.New("not supported serializable format")
+		}
+		s = newTaggedSerializable(data[1])
+		if s == nil {
+			return nil, nil, errors.New("not supported serializable format")
 		}
-		return s, data[s.ByteSize():], nil
 	}
 
-	return nil, nil, errors.New("not supported serializable format")
+	err := s.Decode(data)
+	if err != nil {
+		return nil, data, err
+	}
+	return s, data[s.ByteSize():], nil
 }
 
-// Encode encodes UInt32Value as
+// newTaggedSerializable returns empty Serializable of type encoded
+// with CBOR tag number, or nil if tag isn't supported.
+func newTaggedSerializable(tag byte) Serializable {
+	switch tag {
+	case cborTagStorageID:
+		return &StorageID{}
+	case cborTagOverflowID:
+		return &OverflowID{}
+	case cborTagArrayValue:
+		return &InlineArraySerializable{}
+	case cborTagCompositeValue:
+		return &CompositeSlab{}
+	case cborTagSomeValue:
+		return &SomeSerializable{}
+	case cborTagAddressValue:
+		return &AddressSerializable{}
+	case cborTagCharacterValue:
+		return &CharacterSerializable{}
+	case cborTagPathValue:
+		return &PathSerializable{}
+	case cborTagUInt32Value:
+		return &UInt32Serializable{}
+	case cborTagInt8Value:
+		return &Int8Serializable{}
+	case cborTagInt16Value:
+		return &Int16Serializable{}
+	case cborTagInt32Value:
+		return &Int32Serializable{}
+	case cborTagInt64Value:
+		return &Int64Serializable{}
+	case cborTagInt128Value:
+		return &Int128Serializable{}
+	case cborTagInt256Value:
+		return &Int256Serializable{}
+	case cborTagUInt8Value:
+		return &UInt8Serializable{}
+	case cborTagUInt16Value:
+		return &UInt16Serializable{}
+	case cborTagUInt64Value:
+		return &UInt64Serializable{}
+	case cborTagUInt128Value:
+		return &UInt128Serializable{}
+	case cborTagUInt256Value:
+		return &UInt256Serializable{}
+	case cborTagWord8Value:
+		return &Word8Serializable{}
+	case cborTagWord16Value:
+		return &Word16Serializable{}
+	case cborTagWord32Value:
+		return &Word32Serializable{}
+	case cborTagWord64Value:
+		return &Word64Serializable{}
+	case cborTagFix64Value:
+		return &Fix64Serializable{}
+	case cborTagUFix64Value:
+		return &UFix64Serializable{}
+	default:
+		return nil
+	}
+}
+
+// Encode encodes StorageID as
 // cbor.Tag{
 //		Number:  cborTagStorageID,
-//		Content: uint32,
+//		Content: []byte(address (8 bytes) + index (8 bytes)),
 // }
 func (s *StorageID) Encode() ([]byte, error) {
 	buf := make([]byte, s.ByteSize())
 
 	buf[0] = 0xd8
 	buf[1] = cborTagStorageID
-	buf[2] = 0 | byte(26)
-	binary.BigEndian.PutUint32(buf[3:], uint32(*s))
+	buf[2] = 0x40 | byte(16)
+	copy(buf[3:], s.Address[:])
+	binary.BigEndian.PutUint64(buf[11:], s.Index)
 
 	return buf, nil
 }
@@ -124,24 +259,35 @@ func (s *StorageID) Decode(b []byte) error {
 		return errors.New("too short for StorageID type")
 	}
 
-	if !bytes.Equal([]byte{0xd8, cborTagStorageID, 26}, b[:3]) {
-		return errors.New("not StorageD type")
+	if !bytes.Equal([]byte{0xd8, cborTagStorageID, 0x40 | byte(16)}, b[:3]) {
+		return errors.New("not StorageID type")
 	}
 
-	*s = StorageID(binary.BigEndian.Uint32(b[3:]))
+	copy(s.Address[:], b[3:])
+	s.Index = binary.BigEndian.Uint64(b[11:])
 	return nil
 }
 
 func (s *StorageID) ByteSize() uint32 {
-	// tag number (2 bytes) + content content (5 bytes)
-	return 7
+	// tag number (2 bytes) + byte string head (1 byte) + address (8 bytes) + index (8 bytes)
+	return 19
 }
 
 func (s *StorageID) IsConstantSized() bool {
 	return true
 }
 
-// TODO:
+// GetValue returns StorageID as reference to value stored in slabs with
+// this id, e.g. for StorageID decoded by decodeSerializable. The value is
+// loaded from storage holding the slabs (see containerValue). Containers
+// resolve their StorageID elements to the value (see ArrayValue.value).
 func (s *StorageID) GetValue() Value {
-	return nil
+	return *s
+}
+
+// GetSerizable returns StorageID, so that reference returned by GetValue
+// can be encoded. Containers don't store it as element or field, because
+// they can't take ownership of the value it references.
+func (s StorageID) GetSerizable() Serializable {
+	return &s
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageIDEncodeDecode(t *testing.T) {
+	id := NewStorageID(Address{1, 2, 3, 4, 5, 6, 7, 8}, 0x0102030405060708)
+
+	b, err := id.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, int(id.ByteSize()), len(b))
+	assert.Equal(t, []byte{0xd8, cborTagStorageID, 0x50}, b[:3])
+
+	var decoded StorageID
+	err = decoded.Decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, id, decoded)
+
+	err = decoded.Decode(b[:len(b)-1])
+	require.Error(t, err)
+
+	// StorageID element is reference to value resolved by container
+	s, rest, err := decodeSerializable(b)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, &id, s)
+
+	// StorageID value is reference which can be encoded, but not stored in container
+	v := s.GetValue()
+	assert.Equal(t, id, v)
+	assert.Equal(t, &id, v.GetSerizable())
+
+	storage := NewBasicSlabStorage()
+	array := newTestArrayValue(t, storage, nil)
+	require.Error(t, array.Append(v))
+	require.Error(t, array.Append(SomeValue{Value: v}))
+	composite := newTestCompositeValue(t, storage, nil)
+	require.Error(t, composite.SetField("id", v))
+	require.Error(t, composite.SetField("id", SomeValue{Value: v}))
+	assert.Equal(t, uint32(0), array.Size())
+	assert.Equal(t, uint32(0), composite.FieldCount())
+}
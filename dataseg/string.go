@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	cborMajorByteString = 0x40
+	cborMajorTextString = 0x60
+)
+
+// cborHeadSize returns size of CBOR head with argument n
+// using the shortest encoding.
+func cborHeadSize(n uint64) uint32 {
+	switch {
+	case n < 24:
+		return 1
+	case n <= 0xff:
+		return 2
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	default:
+		return 9
+	}
+}
+
+// encodeCBORHead writes CBOR head of major type with argument n to buf
+// and returns number of bytes written.
+func encodeCBORHead(buf []byte, major byte, n uint64) int {
+	switch {
+	case n < 24:
+		buf[0] = major | byte(n)
+		return 1
+	case n <= 0xff:
+		buf[0] = major | 24
+		buf[1] = byte(n)
+		return 2
+	case n <= 0xffff:
+		buf[0] = major | 25
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		return 3
+	case n <= 0xffffffff:
+		buf[0] = major | 26
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		return 5
+	default:
+		buf[0] = major | 27
+		binary.BigEndian.PutUint64(buf[1:], n)
+		return 9
+	}
+}
+
+// decodeCBORHead decodes CBOR head of major type and returns its argument
+// and number of bytes read.
+func decodeCBORHead(b []byte, major byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errors.New("too short for CBOR head")
+	}
+	if b[0]&0xe0 != major {
+		return 0, 0, fmt.Errorf("wrong CBOR major type 0x%x, want 0x%x", b[0]&0xe0, major)
+	}
+
+	ai := b[0] & 0x1f
+	if ai < 24 {
+		return uint64(ai), 1, nil
+	}
+
+	var size int
+	switch ai {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported CBOR head 0x%x", b[0])
+	}
+
+	if len(b) < 1+size {
+		return 0, 0, errors.New("too short for CBOR head")
+	}
+
+	var n uint64
+	for _, c := range b[1 : 1+size] {
+		n = n<<8 | uint64(c)
+	}
+	return n, 1 + size, nil
+}
+
+// decodeCBORString decodes CBOR byte or text string and returns its content.
+// Returned content shares the underlying array with b.
+func decodeCBORString(b []byte, major byte) ([]byte, error) {
+	n, headSize, err := decodeCBORHead(b, major)
+	if err != nil {
+		return nil, err
+	}
+	// ByteSize() of decoded value assumes the shortest head
+	if uint32(headSize) != cborHeadSize(n) {
+		return nil, errors.New("CBOR string head isn't in the shortest form")
+	}
+	if uint64(len(b)-headSize) < n {
+		return nil, errors.New("too short for CBOR string")
+	}
+	return b[headSize : uint64(headSize)+n], nil
+}
+
+type StringSerializable struct {
+	v StringValue
+}
+
+// Encode encodes StringValue as CBOR text string.
+func (s *StringSerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+	n := encodeCBORHead(buf, cborMajorTextString, uint64(len(s.v)))
+	copy(buf[n:], s.v)
+	return buf, nil
+}
+
+func (s *StringSerializable) Decode(b []byte) error {
+	content, err := decodeCBORString(b, cborMajorTextString)
+	if err != nil {
+		return err
+	}
+	s.v = StringValue(content)
+	return nil
+}
+
+// ByteSize returns size of text string head and content.
+func (s *StringSerializable) ByteSize() uint32 {
+	return cborHeadSize(uint64(len(s.v))) + uint32(len(s.v))
+}
+
+func (s *StringSerializable) IsConstantSized() bool { return false }
+
+func (s *StringSerializable) GetValue() Value {
+	return s.v
+}
+
+type BytesSerializable struct {
+	v BytesValue
+}
+
+// Encode encodes BytesValue as CBOR byte string.
+func (s *BytesSerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+	n := encodeCBORHead(buf, cborMajorByteString, uint64(len(s.v)))
+	copy(buf[n:], s.v)
+	return buf, nil
+}
+
+func (s *BytesSerializable) Decode(b []byte) error {
+	content, err := decodeCBORString(b, cborMajorByteString)
+	if err != nil {
+		return err
+	}
+	// Copy content so value doesn't share encoded slab data
+	s.v = append(BytesValue{}, content...)
+	return nil
+}
+
+// ByteSize returns size of byte string head and content.
+func (s *BytesSerializable) ByteSize() uint32 {
+	return cborHeadSize(uint64(len(s.v))) + uint32(len(s.v))
+}
+
+func (s *BytesSerializable) IsConstantSized() bool { return false }
+
+func (s *BytesSerializable) GetValue() Value {
+	return s.v
+}
+
+type CharacterSerializable struct {
+	v CharacterValue
+}
+
+// Encode encodes CharacterValue as
+//
+//	cbor.Tag{
+//			Number:  cborTagCharacterValue,
+//			Content: string(v),
+//	}
+func (s *CharacterSerializable) Encode() ([]byte, error) {
+	buf := make([]byte, s.ByteSize())
+
+	buf[0] = 0xd8
+	buf[1] = cborTagCharacterValue
+	n := encodeCBORHead(buf[2:], cborMajorTextString, uint64(len(s.v)))
+	copy(buf[2+n:], s.v)
+
+	return buf, nil
+}
+
+func (s *CharacterSerializable) Decode(b []byte) error {
+	if len(b) < 3 {
+		return errors.New("too short for CharacterValue type")
+	}
+
+	if b[0] != 0xd8 || b[1] != cborTagCharacterValue {
+		return errors.New("not CharacterValue type")
+	}
+
+	content, err := decodeCBORString(b[2:], cborMajorTextString)
+	if err != nil {
+		return err
+	}
+	s.v = CharacterValue(content)
+	return nil
+}
+
+// ByteSize returns size of tag number (2 bytes) and text string.
+func (s *CharacterSerializable) ByteSize() uint32 {
+	return 2 + cborHeadSize(uint64(len(s.v))) + uint32(len(s.v))
+}
+
+func (s *CharacterSerializable) IsConstantSized() bool { return false }
+
+func (s *CharacterSerializable) GetValue() Value {
+	return s.v
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringBytesEncodeDecode(t *testing.T) {
+
+	for _, n := range []int{0, 1, 23, 24, 255, 256, 65535, 65536} {
+		values := []Value{
+			StringValue(strings.Repeat("a", n)),
+			BytesValue(make([]byte, n)),
+		}
+
+		for _, v := range values {
+			s := v.GetSerizable()
+			assert.False(t, s.IsConstantSized())
+
+			b, err := s.Encode()
+			require.NoError(t, err)
+			assert.Equal(t, int(s.ByteSize()), len(b))
+			assert.Equal(t, int(cborHeadSize(uint64(n)))+n, len(b))
+
+			decoded, rest, err := decodeSerializable(append(b, 0xff))
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0xff}, rest)
+			assert.Equal(t, s.ByteSize(), decoded.ByteSize())
+			assert.Equal(t, v, decoded.GetValue())
+
+			if n > 0 {
+				_, _, err = decodeSerializable(b[:len(b)-1])
+				require.Error(t, err)
+			}
+		}
+	}
+
+	b, err := StringValue("abc").GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x63, 'a', 'b', 'c'}, b)
+
+	b, err = BytesValue{1, 2}.GetSerizable().Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x42, 1, 2}, b)
+
+	// Head must be in the shortest form
+	_, _, err = decodeSerializable([]byte{0x78, 3, 'a', 'b', 'c'})
+	require.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package main
+
+import "errors"
+
+// slabWriteSet holds slabs stored and removed in one transaction level.
+type slabWriteSet struct {
+	stored  map[StorageID]Slab
+	removed map[StorageID]bool
+
+	// copies holds copies of slabs retrieved from lower levels or base storage.
+	// Slabs are modified in place and then stored again, so modifications
+	// are made to copies instead of slabs visible to lower levels.
+	copies map[StorageID]Slab
+}
+
+func newSlabWriteSet() *slabWriteSet {
+	return &slabWriteSet{
+		stored:  make(map[StorageID]Slab),
+		removed: make(map[StorageID]bool),
+		copies:  make(map[StorageID]Slab),
+	}
+}
+
+// retrieve returns slab from this write set. If removed is true,
+// slab is removed in this write set and lower levels must not be searched.
+func (w *slabWriteSet) retrieve(id StorageID) (slab Slab, found bool, removed bool) {
+	if slab, ok := w.stored[id]; ok {
+		return slab, true, false
+	}
+	if slab, ok := w.copies[id]; ok {
+		return slab, true, false
+	}
+	return nil, false, w.removed[id]
+}
+
+// TransactionalSlabStorage buffers stored and removed slabs in write sets
+// layered over base storage. Changes are written to base storage on Commit
+// and discarded on Rollback. Savepoint starts a nested write set which can
+// be rolled back or released independently.
+//
+// Values using this storage retrieve their slabs through it, so they see
+// the state of the current write set after rollback.
+type TransactionalSlabStorage struct {
+	base      SlabStorage
+	writeSets []*slabWriteSet
+}
+
+var _ SlabStorage = &TransactionalSlabStorage{}
+
+func NewTransactionalSlabStorage(base SlabStorage) *TransactionalSlabStorage {
+	return &TransactionalSlabStorage{
+		base:      base,
+		writeSets: []*slabWriteSet{newSlabWriteSet()},
+	}
+}
+
+func (s *TransactionalSlabStorage) top() *slabWriteSet {
+	return s.writeSets[len(s.writeSets)-1]
+}
+
+func (s *TransactionalSlabStorage) Store(slab Slab) {
+	top := s.top()
+	top.stored[slab.ID()] = slab
+	delete(top.copies, slab.ID())
+	delete(top.removed, slab.ID())
+}
+
+func (s *TransactionalSlabStorage) Retrieve(id StorageID) (Slab, bool, error) {
+	top := s.top()
+
+	for i := len(s.writeSets) - 1; i >= 0; i-- {
+		slab, found, removed := s.writeSets[i].retrieve(id)
+		if removed {
+			return nil, false, nil
+		}
+		if found {
+			if i == len(s.writeSets)-1 {
+				return slab, true, nil
+			}
+			slab = slab.Clone()
+			top.copies[id] = slab
+			return slab, true, nil
+		}
+	}
+
+	slab, found, err := s.base.Retrieve(id)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	slab = slab.Clone()
+	top.copies[id] = slab
+	return slab, true, nil
+}
+
+func (s *TransactionalSlabStorage) Remove(id StorageID) {
+	top := s.top()
+	delete(top.stored, id)
+	delete(top.copies, id)
+	top.removed[id] = true
+}
+
+// GenerateStorageID allocates StorageID from base storage, so indexes
+// aren't reused after rollback. StorageIDs of slabs stored in write sets
+// but not yet committed to base storage are skipped.
+func (s *TransactionalSlabStorage) GenerateStorageID(address Address) (StorageID, error) {
+	for {
+		id, err := s.base.GenerateStorageID(address)
+		if err != nil {
+			return StorageID{}, err
+		}
+		if !s.isStored(id) {
+			return id, nil
+		}
+	}
+}
+
+func (s *TransactionalSlabStorage) isStored(id StorageID) bool {
+	for _, writeSet := range s.writeSets {
+		if _, ok := writeSet.stored[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Savepoint starts a nested write set.
+func (s *TransactionalSlabStorage) Savepoint() {
+	s.writeSets = append(s.writeSets, newSlabWriteSet())
+}
+
+// RollbackToSavepoint discards changes made since last Savepoint.
+func (s *TransactionalSlabStorage) RollbackToSavepoint() error {
+	if len(s.writeSets) == 1 {
+		return errors.New("no savepoint")
+	}
+	s.writeSets = s.writeSets[:len(s.writeSets)-1]
+	return nil
+}
+
+// ReleaseSavepoint merges changes made since last Savepoint into
+// the enclosing write set.
+func (s *TransactionalSlabStorage) ReleaseSavepoint() error {
+	if len(s.writeSets) == 1 {
+		return errors.New("no savepoint")
+	}
+	s.release()
+	return nil
+}
+
+func (s *TransactionalSlabStorage) release() {
+	top := s.top()
+	s.writeSets = s.writeSets[:len(s.writeSets)-1]
+
+	for _, slab := range top.stored {
+		s.Store(slab)
+	}
+	for id := range top.removed {
+		s.Remove(id)
+	}
+}
+
+// Commit releases all savepoints and writes stored and removed slabs
+// to base storage.
+func (s *TransactionalSlabStorage) Commit() {
+	for len(s.writeSets) > 1 {
+		s.release()
+	}
+
+	top := s.top()
+
+	stored := make(map[StorageID]bool, len(top.stored))
+	for id := range top.stored {
+		stored[id] = true
+	}
+
+	for _, id := range sortedStorageIDs(stored) {
+		s.base.Store(top.stored[id])
+	}
+	for _, id := range sortedStorageIDs(top.removed) {
+		s.base.Remove(id)
+	}
+
+	s.writeSets = []*slabWriteSet{newSlabWriteSet()}
+}
+
+// Rollback discards all changes, including changes made after savepoints.
+func (s *TransactionalSlabStorage) Rollback() {
+	s.writeSets = []*slabWriteSet{newSlabWriteSet()}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionalSlabStorage(t *testing.T) {
+
+	newBase := func(t *testing.T) (*BasicSlabStorage, StorageID, []Value) {
+		values := make([]Value, 500)
+		for i := 0; i < len(values); i++ {
+			values[i] = UInt32Value(i)
+		}
+
+		base := NewBasicSlabStorage()
+		array := newTestArrayValue(t, base, values)
+		return base, array.metaSlab.ID(), values
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		base, id, values := newBase(t)
+		slabCount := len(base.slabs)
+
+		storage := NewTransactionalSlabStorage(base)
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+
+		for i := 0; i < 400; i++ {
+			err := array.Remove(0)
+			require.NoError(t, err)
+		}
+		err = array.Append(UInt32Value(1000))
+		require.NoError(t, err)
+		assert.Equal(t, uint32(101), array.Size())
+
+		storage.Rollback()
+
+		// Base storage isn't modified
+		assert.Equal(t, slabCount, len(base.slabs))
+
+		array2, err := NewArrayValueFromStorage(base, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+
+		// Array sees rolled back state
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		base, id, values := newBase(t)
+
+		storage := NewTransactionalSlabStorage(base)
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+
+		for i := 0; i < 400; i++ {
+			err := array.Remove(0)
+			require.NoError(t, err)
+		}
+		values = values[400:]
+
+		storage.Commit()
+
+		array2, err := NewArrayValueFromStorage(base, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+
+		verifyArrayTree(t, array, values)
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		base, id, values := newBase(t)
+
+		storage := NewTransactionalSlabStorage(base)
+
+		err := storage.RollbackToSavepoint()
+		require.Error(t, err)
+
+		err = storage.ReleaseSavepoint()
+		require.Error(t, err)
+
+		array, err := NewArrayValueFromStorage(storage, id)
+		require.NoError(t, err)
+
+		err = array.Set(0, UInt32Value(1000))
+		require.NoError(t, err)
+		values[0] = UInt32Value(1000)
+
+		storage.Savepoint()
+
+		for i := 0; i < 300; i++ {
+			err := array.Remove(1)
+			require.NoError(t, err)
+		}
+
+		err = storage.RollbackToSavepoint()
+		require.NoError(t, err)
+		verifyArrayTree(t, array, values)
+
+		storage.Savepoint()
+
+		err = array.Insert(1, UInt32Value(1001))
+		require.NoError(t, err)
+		values = append(values[:1], append([]Value{UInt32Value(1001)}, values[1:]...)...)
+
+		storage.Savepoint()
+
+		err = array.Set(2, UInt32Value(1002))
+		require.NoError(t, err)
+		values[2] = UInt32Value(1002)
+
+		err = storage.ReleaseSavepoint()
+		require.NoError(t, err)
+
+		storage.Commit()
+
+		array2, err := NewArrayValueFromStorage(base, id)
+		require.NoError(t, err)
+		verifyArrayTree(t, array2, values)
+	})
+}
@@ -1,12 +1,5 @@
 package main
 
-var storageIDCounter uint32 = 0
-
-func generateStorageID() StorageID {
-	storageIDCounter++
-	return StorageID(storageIDCounter)
-}
-
 // Bit returns the bit at index `idx` in the byte array `b` (big endian)
 //
 // The function assumes b has at least idx bits. The caller must make sure this condition is met.
@@ -24,3 +17,10 @@ func SetBit(b []byte, i int) {
 	i &= 7
 	b[byteIndex] |= 1 << (7 - i)
 }
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,5 +1,12 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
 // from github.com/onflow/cadence/runtime/interpreter/value.go
 
 type Value interface {
@@ -17,73 +24,1428 @@ func (v UInt32Value) GetSerizable() Serializable {
 	return &UInt32Serializable{v: v}
 }
 
+// Int8Value
+
+type Int8Value int8
+
+func (v Int8Value) GetSerizable() Serializable {
+	return &Int8Serializable{v: v}
+}
+
+// Int16Value
+
+type Int16Value int16
+
+func (v Int16Value) GetSerizable() Serializable {
+	return &Int16Serializable{v: v}
+}
+
+// Int32Value
+
+type Int32Value int32
+
+func (v Int32Value) GetSerizable() Serializable {
+	return &Int32Serializable{v: v}
+}
+
+// Int64Value
+
+type Int64Value int64
+
+func (v Int64Value) GetSerizable() Serializable {
+	return &Int64Serializable{v: v}
+}
+
+// Int128Value
+
+type Int128Value struct {
+	BigInt *big.Int
+}
+
+func NewInt128ValueFromInt64(v int64) Int128Value {
+	return Int128Value{BigInt: new(big.Int).SetInt64(v)}
+}
+
+func NewInt128ValueFromBigInt(v *big.Int) Int128Value {
+	return Int128Value{BigInt: v}
+}
+
+func (v Int128Value) GetSerizable() Serializable {
+	return &Int128Serializable{v: v}
+}
+
+// Int256Value
+
+type Int256Value struct {
+	BigInt *big.Int
+}
+
+func NewInt256ValueFromInt64(v int64) Int256Value {
+	return Int256Value{BigInt: new(big.Int).SetInt64(v)}
+}
+
+func NewInt256ValueFromBigInt(v *big.Int) Int256Value {
+	return Int256Value{BigInt: v}
+}
+
+func (v Int256Value) GetSerizable() Serializable {
+	return &Int256Serializable{v: v}
+}
+
+// UInt8Value
+
+type UInt8Value uint8
+
+func (v UInt8Value) GetSerizable() Serializable {
+	return &UInt8Serializable{v: v}
+}
+
+// UInt16Value
+
+type UInt16Value uint16
+
+func (v UInt16Value) GetSerizable() Serializable {
+	return &UInt16Serializable{v: v}
+}
+
+// UInt64Value
+
+type UInt64Value uint64
+
+func (v UInt64Value) GetSerizable() Serializable {
+	return &UInt64Serializable{v: v}
+}
+
+// UInt128Value
+
+type UInt128Value struct {
+	BigInt *big.Int
+}
+
+func NewUInt128ValueFromUint64(v uint64) UInt128Value {
+	return UInt128Value{BigInt: new(big.Int).SetUint64(v)}
+}
+
+func NewUInt128ValueFromBigInt(v *big.Int) UInt128Value {
+	return UInt128Value{BigInt: v}
+}
+
+func (v UInt128Value) GetSerizable() Serializable {
+	return &UInt128Serializable{v: v}
+}
+
+// UInt256Value
+
+type UInt256Value struct {
+	BigInt *big.Int
+}
+
+func NewUInt256ValueFromUint64(v uint64) UInt256Value {
+	return UInt256Value{BigInt: new(big.Int).SetUint64(v)}
+}
+
+func NewUInt256ValueFromBigInt(v *big.Int) UInt256Value {
+	return UInt256Value{BigInt: v}
+}
+
+func (v UInt256Value) GetSerizable() Serializable {
+	return &UInt256Serializable{v: v}
+}
+
+// Word8Value
+
+type Word8Value uint8
+
+func (v Word8Value) GetSerizable() Serializable {
+	return &Word8Serializable{v: v}
+}
+
+// Word16Value
+
+type Word16Value uint16
+
+func (v Word16Value) GetSerizable() Serializable {
+	return &Word16Serializable{v: v}
+}
+
+// Word32Value
+
+type Word32Value uint32
+
+func (v Word32Value) GetSerizable() Serializable {
+	return &Word32Serializable{v: v}
+}
+
+// Word64Value
+
+type Word64Value uint64
+
+func (v Word64Value) GetSerizable() Serializable {
+	return &Word64Serializable{v: v}
+}
+
+// Fix64Value is a signed fixed-point number with 8 decimal places,
+// stored as integer multiple of 10^-8.
+
+type Fix64Value int64
+
+func (v Fix64Value) GetSerizable() Serializable {
+	return &Fix64Serializable{v: v}
+}
+
+// UFix64Value is an unsigned fixed-point number with 8 decimal places,
+// stored as integer multiple of 10^-8.
+
+type UFix64Value uint64
+
+func (v UFix64Value) GetSerizable() Serializable {
+	return &UFix64Serializable{v: v}
+}
+
+// BoolValue
+
+type BoolValue bool
+
+func (v BoolValue) GetSerizable() Serializable {
+	return &BoolSerializable{v: v}
+}
+
+// NilValue is the absent value of optional type.
+
+type NilValue struct{}
+
+func (v NilValue) GetSerizable() Serializable {
+	return &NilSerializable{}
+}
+
+// SomeValue is the present value of optional type.
+
+type SomeValue struct {
+	Value Value
+}
+
+func (v SomeValue) GetSerizable() Serializable {
+	return &SomeSerializable{inner: v.Value.GetSerizable()}
+}
+
+// StringValue
+
+type StringValue string
+
+func (v StringValue) GetSerizable() Serializable {
+	return &StringSerializable{v: v}
+}
+
+// BytesValue
+
+type BytesValue []byte
+
+func (v BytesValue) GetSerizable() Serializable {
+	return &BytesSerializable{v: v}
+}
+
+// CharacterValue is a single grapheme cluster.
+
+type CharacterValue string
+
+func (v CharacterValue) GetSerizable() Serializable {
+	return &CharacterSerializable{v: v}
+}
+
+// AddressValue
+
+type AddressValue Address
+
+func (v AddressValue) GetSerizable() Serializable {
+	return &AddressSerializable{v: v}
+}
+
+// PathValue
+
+type PathValue struct {
+	Domain     PathDomain
+	Identifier string
+}
+
+func (v PathValue) GetSerizable() Serializable {
+	return &PathSerializable{v: v}
+}
+
 // ArrayValue
 
 type ArrayValue struct {
+	slabContainer
+
 	// metaSlab replaces values
 	metaSlab *ArrayMetaSlab
+
+	// inlined is set while array is inlined in element of parent array
+	// instead of being stored in its own slabs. metaSlab is nil then.
+	inlined *inlinedArray
+}
+
+// NewArrayValue creates ArrayValue with values, storing its slabs owned
+// by address in storage.
+func NewArrayValue(storage SlabStorage, address Address, values []Value) (*ArrayValue, error) {
+	container := newSlabContainer(storage)
+
+	metaSlab, err := newArrayMetaSlab(container.storage, address)
+	if err != nil {
+		return nil, err
+	}
+
+	array := &ArrayValue{slabContainer: container, metaSlab: metaSlab}
+
+	container.storage.Store(metaSlab)
+
+	for i, v := range values {
+		element, err := array.element(v, uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		err = metaSlab.Append(container.storage, element)
+		if err != nil {
+			return nil, err
+		}
+		err = metaSlab.updateRoot(container.storage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return array, nil
 }
 
-func NewArrayValue(values []Value) *ArrayValue {
-	metaSlab := &ArrayMetaSlab{
-		id: generateStorageID(),
+// NewArrayValueFromEncodedData decodes ArrayValue from data, storing its slabs in storage.
+func NewArrayValueFromEncodedData(storage SlabStorage, data []byte) (*ArrayValue, error) {
+	container := newSlabContainer(storage)
+
+	// Meta slab id is decoded from data
+	metaSlab := &ArrayMetaSlab{header: &ArraySlabHeader{}}
+
+	err := metaSlab.decode(container.storage, data, false)
+	if err != nil {
+		return nil, err
 	}
 
-	array := &ArrayValue{metaSlab: metaSlab}
+	container.storage.Store(metaSlab)
 
-	metaSlab.v = array
+	return &ArrayValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewLazyArrayValueFromEncodedData decodes meta slabs of ArrayValue from data,
+// storing its slabs in storage. Data slabs are decoded on first access,
+// so data must not be modified while the array is in use.
+func NewLazyArrayValueFromEncodedData(storage SlabStorage, data []byte) (*ArrayValue, error) {
+	container := newSlabContainer(storage)
 
-	for _, v := range values {
-		metaSlab.Append(v.GetSerizable())
+	// Meta slab id is decoded from data
+	metaSlab := &ArrayMetaSlab{header: &ArraySlabHeader{}}
+
+	err := metaSlab.decode(container.storage, data, true)
+	if err != nil {
+		return nil, err
 	}
-	return array
+
+	container.storage.Store(metaSlab)
+
+	return &ArrayValue{slabContainer: container, metaSlab: metaSlab}, nil
 }
 
-func NewArrayValueFromEncodedData(data []byte) (*ArrayValue, error) {
-	metaSlab := &ArrayMetaSlab{
-		id: generateStorageID(),
+// NewArrayValueFromStorage loads ArrayValue with root slab id from storage.
+// Slabs are retrieved from storage on access.
+func NewArrayValueFromStorage(storage SlabStorage, id StorageID) (*ArrayValue, error) {
+	root, err := retrieveRootSlab(storage, id, arrayKind)
+	if err != nil {
+		return nil, err
 	}
 
-	array := &ArrayValue{metaSlab: metaSlab}
+	return &ArrayValue{slabContainer: newSlabContainer(storage), metaSlab: root.(*ArrayMetaSlab)}, nil
+}
 
-	metaSlab.v = array
+// root retrieves root slab of array from storage.
+// It returns error if array is inlined in parent array.
+func (v *ArrayValue) root() (*ArrayMetaSlab, error) {
+	slab, err := v.inlinedSlab()
+	if err != nil {
+		return nil, err
+	}
+	if slab != nil {
+		return nil, fmt.Errorf("array %s is inlined in parent array", v.inlined.id)
+	}
 
-	err := metaSlab.Decode(data)
+	root, err := v.retrieveRoot(v.metaSlab.ID(), arrayKind)
 	if err != nil {
 		return nil, err
 	}
+	return root.(*ArrayMetaSlab), nil
+}
 
-	return array, nil
+// rootForUpdate retrieves root slab which is about to be modified,
+// so that snapshots can preserve its current version.
+func (v *ArrayValue) rootForUpdate() (*ArrayMetaSlab, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	v.storage.beforeUpdate(metaSlab)
+	return metaSlab, nil
+}
+
+// node returns node holding elements of array: data slab of inlined array,
+// or root slab of array stored in its own slabs.
+func (v *ArrayValue) node() (ArrayNode, error) {
+	slab, err := v.inlinedSlab()
+	if err != nil {
+		return nil, err
+	}
+	if slab != nil {
+		return slab, nil
+	}
+	return v.root()
+}
+
+// update calls fn with node holding elements of array to modify them.
+func (v *ArrayValue) update(fn func(ArrayNode) error) error {
+	slab, err := v.inlinedSlab()
+	if err != nil {
+		return err
+	}
+	if slab != nil {
+		return v.updateInlined(slab, fn)
+	}
+
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return err
+	}
+	err = fn(metaSlab)
+	if err != nil {
+		return err
+	}
+	return metaSlab.updateRoot(v.storage)
+}
+
+// ID returns StorageID of root slab, which identifies array
+// also while it's inlined in parent array.
+func (v *ArrayValue) ID() StorageID {
+	if v.inlined != nil {
+		return v.inlined.id
+	}
+	return v.metaSlab.ID()
 }
 
 func (v *ArrayValue) GetSerizable() Serializable {
-	return v.metaSlab
+	slab, err := v.inlinedSlab()
+	if err != nil {
+		id := v.ID()
+		return &id
+	}
+	if slab != nil {
+		return &InlineArraySerializable{id: v.inlined.id, slab: slab}
+	}
+
+	metaSlab, err := v.root()
+	if err != nil {
+		metaSlab = v.metaSlab
+	}
+	return &containerSerializable{root: metaSlab, storage: v.storage, value: v}
 }
 
+// Size returns number of elements, or 0 if root slab can't be retrieved.
 func (v *ArrayValue) Size() uint32 {
-	return v.metaSlab.GetCount()
+	node, err := v.node()
+	if err != nil {
+		return 0
+	}
+	return node.Header().count
+}
+
+// element returns Serializable stored as element for value at index.
+// Nested ArrayValue is inlined if it's small enough, otherwise it's stored
+// as StorageID of its root slab, so it must be stored in the same storage
+// as this array. Nested array is owned by this array after it's added,
+// so it can't be added to another array, or to an array nested in it.
+// Nested CompositeValue is referenced by StorageID (see compositeReference),
+// also when it's held by SomeValue (see optionalElement). Other values
+// too large for data slab are stored in overflow slabs.
+func (v *ArrayValue) element(value Value, index uint32) (Serializable, error) {
+	switch value := value.(type) {
+	case *CompositeValue:
+		return compositeReference(v.storage, value)
+	case *ArrayValue:
+		return v.nestedArray(value, index, true)
+	case StorageID:
+		return nil, fmt.Errorf("value referenced by StorageID %s must be loaded from storage", value)
+	case SomeValue:
+		if holdsContainer(value) {
+			return v.optionalElement(value)
+		}
+	}
+	return v.overflow(value.GetSerizable())
+}
+
+// optionalElement returns Serializable stored as element for SomeValue
+// holding container value, which is stored like element of this array.
+// Nested ArrayValue isn't inlined, because inlined arrays are found by
+// their element in parent array (see nestedElement).
+func (v *ArrayValue) optionalElement(value SomeValue) (Serializable, error) {
+	var inner Serializable
+	var err error
+	switch value := value.Value.(type) {
+	case *ArrayValue:
+		inner, err = v.nestedArray(value, 0, false)
+	case SomeValue:
+		inner, err = v.optionalElement(value)
+	default:
+		inner, err = v.element(value, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SomeSerializable{inner: inner}, nil
+}
+
+// nestedArray returns Serializable stored as element for nested array
+// child at index: data slab of child if inline is set and child is small
+// enough (see inline), otherwise StorageID of its root slab.
+func (v *ArrayValue) nestedArray(child *ArrayValue, index uint32, inline bool) (Serializable, error) {
+	if child.inlined != nil {
+		return nil, fmt.Errorf("array %s is already nested in another array", child.ID())
+	}
+
+	if child.storage.readOnly() {
+		return nil, fmt.Errorf("array %s is in snapshot", child.ID())
+	}
+
+	id := child.ID()
+	if id == v.ID() {
+		return nil, errors.New("can't add array to itself")
+	}
+
+	slab, found, err := v.storage.Retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	metaSlab, ok := slab.(*ArrayMetaSlab)
+	if !found || !ok {
+		return nil, fmt.Errorf("nested array %s isn't in array storage", id)
+	}
+
+	if metaSlab.isNested() {
+		return nil, fmt.Errorf("array %s is already nested in %s", id, metaSlab.parent)
+	}
+
+	cycle, err := v.isNestedIn(id)
+	if err != nil {
+		return nil, err
+	}
+	if cycle {
+		return nil, fmt.Errorf("array %s contains array %s", id, v.ID())
+	}
+
+	var inlined *InlineArraySerializable
+	if inline {
+		inlined, err = child.inline()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inlined == nil {
+		// Root slab of nested array is stored with this array's changes
+		nested, err := NewArrayValueFromStorage(v.storage, id)
+		if err != nil {
+			return nil, err
+		}
+		root, err := nested.rootForUpdate()
+		if err != nil {
+			return nil, err
+		}
+		err = root.setParent(nested.storage, v.ID())
+		if err != nil {
+			return nil, err
+		}
+		return &id, nil
+	}
+
+	// Slabs of inlined array are removed with this array's changes
+	err = removeArraySlabs(metaSlab, v.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	child.metaSlab = nil
+	child.storage = newTrackedSlabStorage(v.storage)
+	child.inlined = &inlinedArray{id: id, parent: v, index: index}
+
+	return inlined, nil
+}
+
+// value returns Value of element at index. Nested ArrayValue and
+// CompositeValue are loaded from storage of this array, so that their
+// changes are committed with this array.
+func (v *ArrayValue) value(element Serializable, index uint32) (Value, error) {
+	switch e := element.(type) {
+	case *StorageID:
+		return containerValue(v.storage, *e)
+	case *OverflowID:
+		s, err := decodeOverflow(v.storage, e.id)
+		if err != nil {
+			return nil, err
+		}
+		return s.GetValue(), nil
+	case *InlineArraySerializable:
+		return &ArrayValue{
+			slabContainer: newSlabContainer(v.storage),
+			inlined:       &inlinedArray{id: e.id, parent: v, index: index},
+		}, nil
+	case *SomeSerializable:
+		inner, err := v.value(e.inner, index)
+		if err != nil {
+			return nil, err
+		}
+		return SomeValue{Value: inner}, nil
+	default:
+		return element.GetValue(), nil
+	}
 }
 
 func (v *ArrayValue) Get(index uint32) (Value, error) {
-	serizable, err := v.metaSlab.Get(index)
+	node, err := v.node()
+	if err != nil {
+		return nil, err
+	}
+	serizable, err := node.Get(v.storage, index)
 	if err != nil {
 		return nil, err
 	}
-	return serizable.GetValue(), nil
+	return v.value(serizable, index)
+}
+
+// iterateElements calls fn for each element in order until fn returns false or error.
+func (v *ArrayValue) iterateElements(fn func(Serializable) (bool, error)) error {
+	node, err := v.node()
+	if err != nil {
+		return err
+	}
+
+	if metaSlab, ok := node.(*ArrayMetaSlab); ok {
+		return metaSlab.Iterate(v.storage, fn)
+	}
+
+	// Copy elements so fn can modify the array without affecting iteration
+	elements := append([]Serializable(nil), node.(*ArraySlab).elements...)
+	for _, element := range elements {
+		resume, err := fn(element)
+		if err != nil || !resume {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate calls fn for each element in order until fn returns false or error.
+func (v *ArrayValue) Iterate(fn func(Value) (bool, error)) error {
+	index := uint32(0)
+	return v.iterateElements(func(s Serializable) (bool, error) {
+		value, err := v.value(s, index)
+		if err != nil {
+			return false, err
+		}
+		index++
+		return fn(value)
+	})
 }
 
-func (v *ArrayValue) Append(value Value) {
-	v.metaSlab.Append(value.GetSerizable())
+func (v *ArrayValue) Append(value Value) error {
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	element, err := v.element(value, v.Size())
+	if err != nil {
+		return err
+	}
+	return v.update(func(node ArrayNode) error {
+		return node.Append(v.storage, element)
+	})
 }
 
 func (v *ArrayValue) Remove(index uint32) error {
-	return v.metaSlab.Remove(index)
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	return v.update(func(node ArrayNode) error {
+		element, err := node.Get(v.storage, index)
+		if err != nil {
+			return err
+		}
+		err = node.Remove(v.storage, index)
+		if err != nil {
+			return err
+		}
+		return v.removeElement(element)
+	})
+}
+
+// checkIndex returns error if index is out of bounds, or past the last
+// element unless end is allowed. It's checked before element is created,
+// because creating element takes ownership of nested array and stores
+// overflow slabs (see element).
+func (v *ArrayValue) checkIndex(index uint32, end bool) error {
+	node, err := v.node()
+	if err != nil {
+		return err
+	}
+	count := node.Header().count
+	if index > count || (index == count && !end) {
+		return fmt.Errorf("out of bounds")
+	}
+	return nil
 }
 
 func (v *ArrayValue) Insert(index uint32, value Value) error {
-	return v.metaSlab.Insert(index, value.GetSerizable())
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	if err := v.checkIndex(index, true); err != nil {
+		return err
+	}
+	element, err := v.element(value, index)
+	if err != nil {
+		return err
+	}
+	return v.update(func(node ArrayNode) error {
+		if index == node.Header().count {
+			return node.Append(v.storage, element)
+		}
+		return node.Insert(v.storage, index, element)
+	})
 }
 
 func (v *ArrayValue) Set(index uint32, value Value) error {
-	return v.metaSlab.Set(index, value.GetSerizable())
+	if err := v.checkWritable(); err != nil {
+		return err
+	}
+	if err := v.checkIndex(index, false); err != nil {
+		return err
+	}
+	element, err := v.element(value, index)
+	if err != nil {
+		return err
+	}
+	return v.update(func(node ArrayNode) error {
+		old, err := node.Get(v.storage, index)
+		if err != nil {
+			return err
+		}
+		err = node.Set(v.storage, index, element)
+		if err != nil {
+			return err
+		}
+		return v.removeElement(old)
+	})
+}
+
+// Commit writes copies of slabs created or modified since last commit
+// to storage, and removes slabs deleted since last commit from storage.
+// Changes of inlined array are committed with its parent array.
+func (v *ArrayValue) Commit(storage SlabStorage) error {
+	slab, err := v.inlinedSlab()
+	if err != nil {
+		return err
+	}
+	if slab != nil {
+		return nil
+	}
+	return v.storage.commit(storage)
+}
+
+// DictionaryValue
+
+type DictionaryValue struct {
+	slabContainer
+
+	metaSlab *DictionaryMetaSlab
+}
+
+// NewDictionaryValue creates empty DictionaryValue, storing its slabs
+// owned by address in storage.
+func NewDictionaryValue(storage SlabStorage, address Address) (*DictionaryValue, error) {
+	container := newSlabContainer(storage)
+
+	metaSlab, err := newDictionaryMetaSlab(container.storage, address, NewAcceptAllMask())
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &DictionaryValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewDictionaryValueFromEncodedData decodes DictionaryValue from data,
+// storing its slabs in storage.
+func NewDictionaryValueFromEncodedData(storage SlabStorage, data []byte) (*DictionaryValue, error) {
+	container := newSlabContainer(storage)
+
+	// Meta slab id is decoded from data
+	metaSlab := &DictionaryMetaSlab{header: &DictionarySlabHeader{mask: NewAcceptAllMask()}}
+
+	err := metaSlab.decode(container.storage, data)
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &DictionaryValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewDictionaryValueFromStorage loads DictionaryValue with root slab id
+// from storage. Slabs are retrieved from storage on access.
+func NewDictionaryValueFromStorage(storage SlabStorage, id StorageID) (*DictionaryValue, error) {
+	root, err := retrieveRootSlab(storage, id, dictionaryKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DictionaryValue{slabContainer: newSlabContainer(storage), metaSlab: root.(*DictionaryMetaSlab)}, nil
+}
+
+// root retrieves root slab of dictionary from storage.
+func (v *DictionaryValue) root() (*DictionaryMetaSlab, error) {
+	root, err := v.retrieveRoot(v.metaSlab.ID(), dictionaryKind)
+	if err != nil {
+		return nil, err
+	}
+	return root.(*DictionaryMetaSlab), nil
+}
+
+// rootForUpdate retrieves root slab which is about to be modified.
+func (v *DictionaryValue) rootForUpdate() (*DictionaryMetaSlab, error) {
+	if err := v.checkWritable(); err != nil {
+		return nil, err
+	}
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	v.storage.beforeUpdate(metaSlab)
+	return metaSlab, nil
+}
+
+func (v *DictionaryValue) GetSerizable() Serializable {
+	metaSlab, err := v.root()
+	if err != nil {
+		metaSlab = v.metaSlab
+	}
+	return &containerSerializable{root: metaSlab, storage: v.storage, value: v}
+}
+
+// Size returns number of entries, or 0 if root slab can't be retrieved.
+func (v *DictionaryValue) Size() uint32 {
+	metaSlab, err := v.root()
+	if err != nil {
+		return 0
+	}
+	return metaSlab.GetCount()
+}
+
+// Get returns value of key, or false if key isn't in dictionary.
+func (v *DictionaryValue) Get(key Value) (Value, bool, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, false, err
+	}
+
+	k := key.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return nil, false, err
+	}
+
+	serizable, found, err := metaSlab.Get(v.storage, hash, k)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	return serizable.GetValue(), true, nil
+}
+
+func (v *DictionaryValue) Has(key Value) (bool, error) {
+	_, found, err := v.Get(key)
+	return found, err
+}
+
+// Set sets value of key, inserting key if it isn't in dictionary.
+func (v *DictionaryValue) Set(key Value, value Value) error {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return err
+	}
+
+	k := key.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return err
+	}
+
+	_, err = metaSlab.Set(v.storage, dictionaryEntry{hash: hash, key: k, value: value.GetSerizable()})
+	if err != nil {
+		return err
+	}
+	return metaSlab.updateRoot(v.storage)
+}
+
+// Remove removes key. It returns false if key isn't in dictionary.
+func (v *DictionaryValue) Remove(key Value) (bool, error) {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return false, err
+	}
+
+	k := key.GetSerizable()
+	hash, err := hashKey(k)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := metaSlab.Remove(v.storage, hash, k)
+	if err != nil {
+		return false, err
+	}
+	return removed, metaSlab.updateRoot(v.storage)
+}
+
+// Iterate calls fn for each key and value in order of key hash
+// until fn returns false or error.
+func (v *DictionaryValue) Iterate(fn func(key Value, value Value) (bool, error)) error {
+	metaSlab, err := v.root()
+	if err != nil {
+		return err
+	}
+	return metaSlab.Iterate(v.storage, func(key Serializable, value Serializable) (bool, error) {
+		return fn(key.GetValue(), value.GetValue())
+	})
+}
+
+// OrderedMapValue
+
+type OrderedMapValue struct {
+	slabContainer
+
+	metaSlab *OrderedMapMetaSlab
+}
+
+// NewOrderedMapValue creates empty OrderedMapValue, storing its slabs
+// owned by address in storage.
+func NewOrderedMapValue(storage SlabStorage, address Address) (*OrderedMapValue, error) {
+	container := newSlabContainer(storage)
+
+	metaSlab, err := newOrderedMapMetaSlab(container.storage, address)
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &OrderedMapValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewOrderedMapValueFromEncodedData decodes OrderedMapValue from data,
+// storing its slabs in storage.
+func NewOrderedMapValueFromEncodedData(storage SlabStorage, data []byte) (*OrderedMapValue, error) {
+	container := newSlabContainer(storage)
+
+	// Meta slab id is decoded from data
+	metaSlab := &OrderedMapMetaSlab{header: &OrderedMapSlabHeader{}}
+
+	err := metaSlab.decode(container.storage, data)
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(metaSlab)
+
+	return &OrderedMapValue{slabContainer: container, metaSlab: metaSlab}, nil
+}
+
+// NewOrderedMapValueFromStorage loads OrderedMapValue with root slab id
+// from storage. Slabs are retrieved from storage on access.
+func NewOrderedMapValueFromStorage(storage SlabStorage, id StorageID) (*OrderedMapValue, error) {
+	root, err := retrieveRootSlab(storage, id, orderedMapKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderedMapValue{slabContainer: newSlabContainer(storage), metaSlab: root.(*OrderedMapMetaSlab)}, nil
+}
+
+// root retrieves root slab of ordered map from storage.
+func (v *OrderedMapValue) root() (*OrderedMapMetaSlab, error) {
+	root, err := v.retrieveRoot(v.metaSlab.ID(), orderedMapKind)
+	if err != nil {
+		return nil, err
+	}
+	return root.(*OrderedMapMetaSlab), nil
+}
+
+// rootForUpdate retrieves root slab which is about to be modified.
+func (v *OrderedMapValue) rootForUpdate() (*OrderedMapMetaSlab, error) {
+	if err := v.checkWritable(); err != nil {
+		return nil, err
+	}
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	v.storage.beforeUpdate(metaSlab)
+	return metaSlab, nil
+}
+
+func (v *OrderedMapValue) GetSerizable() Serializable {
+	metaSlab, err := v.root()
+	if err != nil {
+		metaSlab = v.metaSlab
+	}
+	return &containerSerializable{root: metaSlab, storage: v.storage, value: v}
+}
+
+// Size returns number of entries, or 0 if root slab can't be retrieved.
+func (v *OrderedMapValue) Size() uint32 {
+	metaSlab, err := v.root()
+	if err != nil {
+		return 0
+	}
+	return metaSlab.GetCount()
+}
+
+// Get returns value of key, or false if key isn't in ordered map.
+func (v *OrderedMapValue) Get(key Value) (Value, bool, error) {
+	serizable, found, err := v.get(key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	return serizable.GetValue(), true, nil
+}
+
+// get returns Serializable stored as value of key, or false if key isn't in ordered map.
+func (v *OrderedMapValue) get(key Value) (Serializable, bool, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, false, err
+	}
+
+	k, err := orderedKey(key.GetSerizable())
+	if err != nil {
+		return nil, false, err
+	}
+
+	return metaSlab.Get(v.storage, k)
+}
+
+func (v *OrderedMapValue) Has(key Value) (bool, error) {
+	_, found, err := v.Get(key)
+	return found, err
+}
+
+// Set sets value of key, inserting key if it isn't in ordered map.
+func (v *OrderedMapValue) Set(key Value, value Value) error {
+	return v.set(key, value.GetSerizable())
+}
+
+// set sets Serializable stored as value of key, inserting key if it isn't in ordered map.
+func (v *OrderedMapValue) set(key Value, value Serializable) error {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return err
+	}
+
+	entry, err := newOrderedMapEntry(key.GetSerizable(), value)
+	if err != nil {
+		return err
+	}
+
+	_, err = metaSlab.Set(v.storage, entry)
+	if err != nil {
+		return err
+	}
+	return metaSlab.updateRoot(v.storage)
+}
+
+// Remove removes key. It returns false if key isn't in ordered map.
+func (v *OrderedMapValue) Remove(key Value) (bool, error) {
+	metaSlab, err := v.rootForUpdate()
+	if err != nil {
+		return false, err
+	}
+
+	k, err := orderedKey(key.GetSerizable())
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := metaSlab.Remove(v.storage, k)
+	if err != nil {
+		return false, err
+	}
+	return removed, metaSlab.updateRoot(v.storage)
+}
+
+// Floor returns key and value of entry with the greatest key less than
+// or equal to key, or false if there isn't such entry.
+func (v *OrderedMapValue) Floor(key Value) (Value, Value, bool, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	k, err := orderedKey(key.GetSerizable())
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	entry, found, err := metaSlab.Floor(v.storage, k)
+	if err != nil || !found {
+		return nil, nil, false, err
+	}
+	return entry.key.GetValue(), entry.value.GetValue(), true, nil
+}
+
+// Ceiling returns key and value of entry with the least key greater than
+// or equal to key, or false if there isn't such entry.
+func (v *OrderedMapValue) Ceiling(key Value) (Value, Value, bool, error) {
+	metaSlab, err := v.root()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	k, err := orderedKey(key.GetSerizable())
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	entry, found, err := metaSlab.Ceiling(v.storage, k)
+	if err != nil || !found {
+		return nil, nil, false, err
+	}
+	return entry.key.GetValue(), entry.value.GetValue(), true, nil
+}
+
+// Iterate calls fn for each key and value in order of key
+// until fn returns false or error.
+func (v *OrderedMapValue) Iterate(fn func(key Value, value Value) (bool, error)) error {
+	return v.IterateRange(nil, nil, fn)
+}
+
+// IterateRange calls fn for each key and value with key greater than or
+// equal to start and less than end in order of key, until fn returns false
+// or error. If start or end is nil, range isn't bounded on that side.
+func (v *OrderedMapValue) IterateRange(start Value, end Value, fn func(key Value, value Value) (bool, error)) error {
+	metaSlab, err := v.root()
+	if err != nil {
+		return err
+	}
+
+	var startKey, endKey []byte
+	if start != nil {
+		startKey, err = orderedKey(start.GetSerizable())
+		if err != nil {
+			return err
+		}
+	}
+	if end != nil {
+		endKey, err = orderedKey(end.GetSerizable())
+		if err != nil {
+			return err
+		}
+	}
+
+	return metaSlab.Iterate(v.storage, startKey, endKey, func(key Serializable, value Serializable) (bool, error) {
+		return fn(key.GetValue(), value.GetValue())
+	})
+}
+
+// CompositeValue
+
+type CompositeValue struct {
+	slabContainer
+
+	slab *CompositeSlab
+}
+
+// NewCompositeValue creates CompositeValue with type ID, kind and fields,
+// storing its slabs owned by address in storage. Fields are set in order
+// of name, so encoded data doesn't depend on iteration order of fields.
+func NewCompositeValue(
+	storage SlabStorage,
+	address Address,
+	typeID string,
+	kind CompositeKind,
+	fields map[string]Value,
+) (*CompositeValue, error) {
+	container := newSlabContainer(storage)
+
+	id, err := container.storage.GenerateStorageID(address)
+	if err != nil {
+		return nil, err
+	}
+
+	slab := newCompositeSlab(id, typeID, kind)
+
+	composite := &CompositeValue{slabContainer: container, slab: slab}
+
+	container.storage.Store(slab)
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := composite.SetField(name, fields[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return composite, nil
+}
+
+// NewCompositeValueFromEncodedData decodes CompositeValue from data,
+// storing its slabs in storage.
+func NewCompositeValueFromEncodedData(storage SlabStorage, data []byte) (*CompositeValue, error) {
+	container := newSlabContainer(storage)
+
+	// Composite slab id is decoded from data
+	slab := &CompositeSlab{}
+
+	err := slab.decode(container.storage, data)
+	if err != nil {
+		return nil, err
+	}
+
+	container.storage.Store(slab)
+
+	return &CompositeValue{slabContainer: container, slab: slab}, nil
+}
+
+// NewCompositeValueFromStorage loads CompositeValue with root slab id
+// from storage. Slabs are retrieved from storage on access.
+func NewCompositeValueFromStorage(storage SlabStorage, id StorageID) (*CompositeValue, error) {
+	root, err := retrieveRootSlab(storage, id, compositeKind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompositeValue{slabContainer: newSlabContainer(storage), slab: root.(*CompositeSlab)}, nil
+}
+
+// root retrieves root slab of composite from storage.
+func (v *CompositeValue) root() (*CompositeSlab, error) {
+	root, err := v.retrieveRoot(v.slab.ID(), compositeKind)
+	if err != nil {
+		return nil, err
+	}
+	return root.(*CompositeSlab), nil
+}
+
+// rootForUpdate retrieves root slab which is about to be modified.
+func (v *CompositeValue) rootForUpdate() (*CompositeSlab, error) {
+	if err := v.checkWritable(); err != nil {
+		return nil, err
+	}
+	slab, err := v.root()
+	if err != nil {
+		return nil, err
+	}
+	v.storage.beforeUpdate(slab)
+	return slab, nil
+}
+
+func (v *CompositeValue) GetSerizable() Serializable {
+	slab, err := v.root()
+	if err != nil {
+		slab = v.slab
+	}
+	return &containerSerializable{root: slab, storage: v.storage, value: v}
+}
+
+// ID returns StorageID of root slab, which identifies composite.
+func (v *CompositeValue) ID() StorageID {
+	return v.slab.ID()
+}
+
+func (v *CompositeValue) TypeID() string {
+	return v.slab.typeID
+}
+
+func (v *CompositeValue) Kind() CompositeKind {
+	return v.slab.kind
+}
+
+// FieldCount returns number of fields, or 0 if root slab can't be retrieved.
+func (v *CompositeValue) FieldCount() uint32 {
+	slab, err := v.root()
+	if err != nil {
+		return 0
+	}
+	if slab.fields == nil {
+		return v.fieldMapValue(slab).Size()
+	}
+	return slab.fields.header.count
+}
+
+// GetField returns value of field name, or false if composite doesn't have the field.
+func (v *CompositeValue) GetField(name string) (Value, bool, error) {
+	slab, err := v.root()
+	if err != nil {
+		return nil, false, err
+	}
+
+	serizable, found, err := v.getField(slab, name)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	value, err := v.fieldValue(serizable)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// getField returns Serializable stored as value of field name,
+// or false if composite doesn't have the field.
+func (v *CompositeValue) getField(slab *CompositeSlab, name string) (Serializable, bool, error) {
+	if slab.fields == nil {
+		return v.fieldMapValue(slab).get(StringValue(name))
+	}
+
+	k, err := orderedKey(StringValue(name).GetSerizable())
+	if err != nil {
+		return nil, false, err
+	}
+
+	return slab.fields.Get(v.storage, k)
+}
+
+// SetField sets value of field name, adding the field if composite doesn't have it.
+// Fields are moved to their own slabs when composite slab grows past maxThreshold.
+func (v *CompositeValue) SetField(name string, value Value) error {
+	slab, err := v.rootForUpdate()
+	if err != nil {
+		return err
+	}
+
+	old, found, err := v.getField(slab, name)
+	if err != nil {
+		return err
+	}
+
+	serizable, err := v.field(value)
+	if err != nil {
+		return err
+	}
+
+	if slab.fields == nil {
+		err = v.fieldMapValue(slab).set(StringValue(name), serizable)
+		if err != nil {
+			return err
+		}
+	} else {
+		entry, err := newOrderedMapEntry(StringValue(name).GetSerizable(), serizable)
+		if err != nil {
+			return err
+		}
+
+		_, err = slab.fields.Set(v.storage, entry)
+		if err != nil {
+			return err
+		}
+
+		if slab.ByteSize() > maxThreshold {
+			err = v.moveFields(slab)
+			if err != nil {
+				return err
+			}
+		}
+
+		v.storage.Store(slab)
+	}
+
+	if !found {
+		return nil
+	}
+	return v.removeField(old)
+}
+
+// RemoveField removes field name. It returns false if composite doesn't have the field.
+func (v *CompositeValue) RemoveField(name string) (bool, error) {
+	slab, err := v.rootForUpdate()
+	if err != nil {
+		return false, err
+	}
+
+	old, found, err := v.getField(slab, name)
+	if err != nil || !found {
+		return false, err
+	}
+
+	if slab.fields == nil {
+		_, err = v.fieldMapValue(slab).Remove(StringValue(name))
+		if err != nil {
+			return false, err
+		}
+	} else {
+		k, err := orderedKey(StringValue(name).GetSerizable())
+		if err != nil {
+			return false, err
+		}
+
+		_, err = slab.fields.Remove(v.storage, k)
+		if err != nil {
+			return false, err
+		}
+
+		v.storage.Store(slab)
+	}
+
+	return true, v.removeField(old)
+}
+
+// IterateFields calls fn for each field name and value in order of
+// name until fn returns false or error.
+func (v *CompositeValue) IterateFields(fn func(name string, value Value) (bool, error)) error {
+	slab, err := v.root()
+	if err != nil {
+		return err
+	}
+
+	iterate := func(key Serializable, value Serializable) (bool, error) {
+		name, ok := key.GetValue().(StringValue)
+		if !ok {
+			return false, fmt.Errorf("field name of composite %s is %T, not StringValue", slab.id, key.GetValue())
+		}
+		fieldValue, err := v.fieldValue(value)
+		if err != nil {
+			return false, err
+		}
+		return fn(string(name), fieldValue)
+	}
+
+	if slab.fields == nil {
+		metaSlab, err := v.fieldMapValue(slab).root()
+		if err != nil {
+			return err
+		}
+		return metaSlab.Iterate(v.storage, nil, nil, iterate)
+	}
+
+	for _, e := range slab.fields.entries {
+		resume, err := iterate(e.key, e.value)
+		if err != nil || !resume {
+			return err
+		}
+	}
+	return nil
 }